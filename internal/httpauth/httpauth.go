@@ -0,0 +1,267 @@
+package httpauth
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Transport adds HTTP Basic or Digest credentials to camera requests.
+// The scheme is chosen from the camera's WWW-Authenticate challenge; once a
+// challenge has been seen it is reused so later requests authenticate
+// without an extra round trip.
+type Transport struct {
+	Base     http.RoundTripper
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	challenge *digestChallenge // nil until a Digest challenge is received
+	basic     bool             // camera asked for Basic auth
+	nc        uint32           // Digest nonce count
+}
+
+// digestChallenge holds the parameters of a WWW-Authenticate: Digest header
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+// RoundTrip implements http.RoundTripper. Like any RoundTripper it closes
+// the request body, even when it returns an error.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(t.authorize(req))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	if !t.handleChallenge(resp.Header.Values("WWW-Authenticate")) {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	// The first attempt consumed the body (e.g. a BirdDog JSON POST), so
+	// the retry needs a fresh copy
+	retry := req
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			req.Body.Close()
+			return nil, fmt.Errorf("cannot resend request body after an auth challenge")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			req.Body.Close()
+			return nil, err
+		}
+		retry = req.Clone(req.Context())
+		retry.Body = body
+	}
+
+	return t.Base.RoundTrip(t.authorize(retry))
+}
+
+// authorize returns a clone of req carrying credentials for the last seen challenge
+func (t *Transport) authorize(req *http.Request) *http.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.challenge == nil && !t.basic {
+		return req
+	}
+
+	r := req.Clone(req.Context())
+	if t.challenge != nil {
+		t.nc++
+		r.Header.Set("Authorization", t.challenge.authorization(r.Method, r.URL.RequestURI(), t.Username, t.Password, t.nc))
+	} else {
+		r.SetBasicAuth(t.Username, t.Password)
+	}
+	return r
+}
+
+// handleChallenge records the auth scheme requested by the camera.
+// Returns false if the challenge is unsupported or was already answered
+// with the same nonce (i.e. the credentials are wrong).
+func (t *Transport) handleChallenge(headers []string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, h := range headers {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(h), " ")
+		switch strings.ToLower(scheme) {
+		case "digest":
+			ch := parseDigestChallenge(params)
+			if ch.hash() == nil {
+				continue // Unsupported algorithm; the camera may offer another
+			}
+			if t.challenge != nil && t.challenge.nonce == ch.nonce && !ch.stale {
+				return false
+			}
+			t.challenge = &ch.digestChallenge
+			t.nc = 0
+			return true
+		case "basic":
+			if t.basic {
+				return false
+			}
+			t.basic = true
+			return true
+		}
+	}
+	return false
+}
+
+type parsedChallenge struct {
+	digestChallenge
+	stale bool
+}
+
+// parseDigestChallenge parses the comma separated key=value list of a Digest challenge
+func parseDigestChallenge(params string) parsedChallenge {
+	var ch parsedChallenge
+	for _, field := range splitChallengeParams(params) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "realm":
+			ch.realm = value
+		case "nonce":
+			ch.nonce = value
+		case "opaque":
+			ch.opaque = value
+		case "algorithm":
+			ch.algorithm = value
+		case "qop":
+			// Prefer "auth" if the camera offers several options
+			for _, q := range strings.Split(value, ",") {
+				if strings.TrimSpace(q) == "auth" {
+					ch.qop = "auth"
+				}
+			}
+		case "stale":
+			ch.stale = strings.EqualFold(value, "true")
+		}
+	}
+	return ch
+}
+
+// splitChallengeParams splits on commas that are not inside quoted strings
+func splitChallengeParams(s string) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// hash returns the hex digest function for the challenge's algorithm, or
+// nil if it isn't supported
+func (ch *digestChallenge) hash() func(string) string {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(ch.algorithm), "-sess")) {
+	case "", "MD5":
+		return md5Hex
+	case "SHA-256":
+		return sha256Hex
+	}
+	return nil
+}
+
+// authorization builds the Authorization header value for a Digest challenge
+// (RFC 7616: MD5 or SHA-256, optionally -sess)
+func (ch *digestChallenge) authorization(method, uri, username, password string, nc uint32) string {
+	h := ch.hash()
+	ha1 := h(fmt.Sprintf("%s:%s:%s", username, ch.realm, password))
+	ha2 := h(fmt.Sprintf("%s:%s", method, uri))
+
+	var cnonce, response string
+	ncValue := fmt.Sprintf("%08x", nc)
+	if strings.HasSuffix(strings.ToLower(ch.algorithm), "-sess") {
+		cnonce = newCNonce()
+		ha1 = h(fmt.Sprintf("%s:%s:%s", ha1, ch.nonce, cnonce))
+	}
+	if ch.qop == "auth" {
+		if cnonce == "" {
+			cnonce = newCNonce()
+		}
+		response = h(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, ch.nonce, ncValue, cnonce, ch.qop, ha2))
+	} else {
+		response = h(fmt.Sprintf("%s:%s:%s", ha1, ch.nonce, ha2))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		username, ch.realm, ch.nonce, uri, response)
+	if ch.algorithm != "" {
+		fmt.Fprintf(&b, `, algorithm=%s`, ch.algorithm)
+	}
+	if ch.opaque != "" {
+		fmt.Fprintf(&b, `, opaque="%s"`, ch.opaque)
+	}
+	if ch.qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce="%s"`, ch.qop, ncValue, cnonce)
+	} else if cnonce != "" {
+		// -sess without qop: the server needs the cnonce for HA1
+		fmt.Fprintf(&b, `, cnonce="%s"`, cnonce)
+	}
+	return b.String()
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func newCNonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TLSConfig builds the TLS client configuration for HTTPS cameras, trusting
+// the PEM certificates in caCertFile (if set) instead of the system roots
+func TLSConfig(caCertFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tc := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if caCertFile != "" {
+		pem, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+		}
+		tc.RootCAs = pool
+	}
+	return tc, nil
+}
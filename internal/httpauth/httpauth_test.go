@@ -0,0 +1,313 @@
+package httpauth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// digestServer is a camera stand-in requiring Digest auth
+type digestServer struct {
+	algorithm string
+	qop       string // "" for RFC 2069 style responses
+	username  string
+	password  string
+
+	mu         sync.Mutex
+	nonce      string
+	nonces     int
+	staleAfter int // Mark the nonce stale after this many good requests (0 = never)
+	used       int
+	challenges int
+}
+
+func (d *digestServer) newNonce() {
+	d.nonces++
+	d.nonce = fmt.Sprintf("nonce%d", d.nonces)
+	d.used = 0
+}
+
+func (d *digestServer) challenge(w http.ResponseWriter, stale bool) {
+	d.challenges++
+	h := fmt.Sprintf(`Digest realm="cam", nonce="%s", opaque="op"`, d.nonce)
+	if d.algorithm != "" {
+		h += ", algorithm=" + d.algorithm
+	}
+	if d.qop != "" {
+		h += fmt.Sprintf(`, qop="%s"`, d.qop)
+	}
+	if stale {
+		h += ", stale=true"
+	}
+	w.Header().Add("WWW-Authenticate", h)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func (d *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.nonce == "" {
+		d.newNonce()
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		d.challenge(w, false)
+		return
+	}
+	p := map[string]string{}
+	for _, field := range splitChallengeParams(strings.TrimPrefix(auth, "Digest ")) {
+		k, v, _ := strings.Cut(field, "=")
+		p[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"`)
+	}
+
+	ch := digestChallenge{algorithm: d.algorithm}
+	h := ch.hash()
+	ha1 := h(d.username + ":cam:" + d.password)
+	if strings.HasSuffix(strings.ToLower(d.algorithm), "-sess") {
+		if p["cnonce"] == "" {
+			http.Error(w, "missing cnonce", http.StatusBadRequest)
+			return
+		}
+		ha1 = h(ha1 + ":" + p["nonce"] + ":" + p["cnonce"])
+	}
+	ha2 := h(r.Method + ":" + p["uri"])
+	var want string
+	if d.qop != "" {
+		if p["qop"] != "auth" || p["nc"] == "" || p["cnonce"] == "" {
+			http.Error(w, "missing qop fields", http.StatusBadRequest)
+			return
+		}
+		want = h(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+	} else {
+		want = h(ha1 + ":" + p["nonce"] + ":" + ha2)
+	}
+
+	if p["response"] != want || p["username"] != d.username || p["opaque"] != "op" || p["uri"] != r.URL.RequestURI() {
+		d.challenge(w, false)
+		return
+	}
+	if p["nonce"] != d.nonce {
+		d.challenge(w, true)
+		return
+	}
+	if d.staleAfter > 0 && d.used >= d.staleAfter {
+		d.newNonce()
+		d.challenge(w, true)
+		return
+	}
+	d.used++
+	fmt.Fprint(w, "ok")
+}
+
+func get(t *testing.T, tr *Transport, url string) int {
+	t.Helper()
+	resp, err := (&http.Client{Transport: tr}).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestDigest(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		qop       string
+	}{
+		{"qop auth", "", "auth"},
+		{"qop options", "MD5", "auth,auth-int"},
+		{"no qop", "MD5", ""},
+		{"MD5-sess no qop", "MD5-sess", ""},
+		{"MD5-sess qop auth", "MD5-sess", "auth"},
+		{"SHA-256", "SHA-256", "auth"},
+		{"SHA-256-sess", "SHA-256-sess", "auth"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &digestServer{algorithm: tt.algorithm, qop: tt.qop, username: "admin", password: "secret"}
+			srv := httptest.NewServer(d)
+			defer srv.Close()
+
+			tr := &Transport{Base: http.DefaultTransport, Username: "admin", Password: "secret"}
+			for i := 0; i < 3; i++ {
+				if code := get(t, tr, srv.URL+"/cgi-bin/aw_ptz?cmd=%23O&res=1"); code != http.StatusOK {
+					t.Fatalf("request %d: status %d", i, code)
+				}
+			}
+			// Later requests reuse the challenge without another 401
+			if d.challenges != 1 {
+				t.Errorf("got %d challenges, want 1", d.challenges)
+			}
+		})
+	}
+}
+
+func TestDigestStaleNonce(t *testing.T) {
+	d := &digestServer{qop: "auth", username: "admin", password: "secret", staleAfter: 2}
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	tr := &Transport{Base: http.DefaultTransport, Username: "admin", Password: "secret"}
+	for i := 0; i < 5; i++ {
+		if code := get(t, tr, srv.URL+"/"); code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, code)
+		}
+	}
+	if d.nonces < 2 {
+		t.Errorf("nonce was never renewed")
+	}
+}
+
+func TestDigestWrongCredentials(t *testing.T) {
+	d := &digestServer{qop: "auth", username: "admin", password: "secret"}
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	tr := &Transport{Base: http.DefaultTransport, Username: "admin", Password: "wrong"}
+	if code := get(t, tr, srv.URL+"/"); code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", code)
+	}
+	// One challenge answered once, not retried in a loop
+	if d.challenges != 2 {
+		t.Errorf("got %d challenges, want 2", d.challenges)
+	}
+}
+
+func TestDigestUnsupportedAlgorithm(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Add("WWW-Authenticate", `Digest realm="cam", nonce="n", algorithm=SHA-512-256, qop="auth"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	tr := &Transport{Base: http.DefaultTransport, Username: "admin", Password: "secret"}
+	if code := get(t, tr, srv.URL+"/"); code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", code)
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1 (no answer to an unsupported challenge)", requests)
+	}
+}
+
+func TestDigestPrefersSupportedChallenge(t *testing.T) {
+	d := &digestServer{qop: "auth", username: "admin", password: "secret"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Add("WWW-Authenticate", `Digest realm="cam", nonce="x", algorithm=SHA-512-256, qop="auth"`)
+		}
+		d.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	tr := &Transport{Base: http.DefaultTransport, Username: "admin", Password: "secret"}
+	if code := get(t, tr, srv.URL+"/"); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+}
+
+func TestBasic(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cam"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	tr := &Transport{Base: http.DefaultTransport, Username: "admin", Password: "secret"}
+	if code := get(t, tr, srv.URL+"/"); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	tr = &Transport{Base: http.DefaultTransport, Username: "admin", Password: "wrong"}
+	if code := get(t, tr, srv.URL+"/"); code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", code)
+	}
+}
+
+func TestDigestPostBody(t *testing.T) {
+	d := &digestServer{qop: "auth", username: "admin", password: "secret"}
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		d.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	const body = `{"Preset":"Preset-1"}`
+	client := &http.Client{Transport: &Transport{Base: http.DefaultTransport, Username: "admin", Password: "secret"}}
+	resp, err := client.Post(srv.URL+"/recall", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if len(bodies) != 2 || bodies[1] != body {
+		t.Errorf("server read bodies %q, want the body resent after the challenge", bodies)
+	}
+
+	// A body that can't be replayed fails rather than sending it empty
+	req, _ := http.NewRequest("POST", srv.URL+"/recall", io.NopCloser(strings.NewReader(body)))
+	tr := &Transport{Base: http.DefaultTransport, Username: "admin", Password: "secret"}
+	if resp, err := tr.RoundTrip(req); err == nil {
+		resp.Body.Close()
+		t.Error("no error for a body that can't be resent")
+	}
+}
+
+// closeBody records whether a request body was closed
+type closeBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+// challengeOnly answers every request with a Digest challenge without
+// reading or closing the body, leaving that to the Transport
+type challengeOnly struct{}
+
+func (challengeOnly) RoundTrip(*http.Request) (*http.Response, error) {
+	h := http.Header{}
+	h.Set("WWW-Authenticate", `Digest realm="cam", nonce="n", qop="auth"`)
+	return &http.Response{StatusCode: http.StatusUnauthorized, Header: h, Body: http.NoBody}, nil
+}
+
+func TestBodyClosedOnError(t *testing.T) {
+	tests := []struct {
+		name    string
+		getBody func() (io.ReadCloser, error)
+	}{
+		{"no GetBody", nil},
+		{"GetBody fails", func() (io.ReadCloser, error) { return nil, fmt.Errorf("gone") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &closeBody{Reader: strings.NewReader("{}")}
+			req, _ := http.NewRequest("POST", "http://cam/recall", body)
+			req.GetBody = tt.getBody
+
+			tr := &Transport{Base: challengeOnly{}, Username: "admin", Password: "secret"}
+			if resp, err := tr.RoundTrip(req); err == nil {
+				resp.Body.Close()
+				t.Fatal("no error for a body that can't be resent")
+			}
+			if !body.closed {
+				t.Error("request body left open")
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package httpcgi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"ptz-remote/internal/httpauth"
+	"ptz-remote/internal/ptz"
+)
+
+const minInterval = 50 * time.Millisecond // ~20 commands/sec max
+
+// compiledRequest is a Request with parsed templates
+type compiledRequest struct {
+	method      string
+	path        *template.Template
+	body        *template.Template // nil if the request has no body
+	contentType string
+}
+
+// Controller drives a camera through a vendor's HTTP CGI or REST API
+type Controller struct {
+	vendor  string
+	baseURL string
+	client  *http.Client
+	cmds    CommandMap
+	reqs    map[string]*compiledRequest // by command name; missing = unsupported
+	stopCh  chan struct{}
+
+	// Pan/tilt state
+	panTilt struct {
+		ptz.Throttle
+		pending, sent struct{ pan, tilt float64 }
+	}
+
+	// Zoom state
+	zoom struct {
+		ptz.Throttle
+		pending, sent float64
+	}
+}
+
+// Config for HTTP CGI controller
+type Config struct {
+	Address  string      // Camera IP address or hostname
+	Vendor   string      // Key in Vendors, e.g. "ptzoptics"
+	Commands *CommandMap // Optional; overrides the Vendor lookup
+	Username string      // Optional; enables Basic/Digest authentication
+	Password string
+	HTTPS    bool
+
+	CACertFile         string // Optional PEM file used to verify the camera certificate
+	InsecureSkipVerify bool   // Skip certificate verification (self-signed cameras)
+}
+
+// NewController creates a controller for the given vendor's HTTP API
+func NewController(cfg Config) (*Controller, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("camera address is required")
+	}
+
+	var cmds CommandMap
+	if cfg.Commands != nil {
+		cmds = *cfg.Commands
+	} else {
+		var ok bool
+		cmds, ok = Vendors[cfg.Vendor]
+		if !ok {
+			return nil, fmt.Errorf("unknown vendor: %s", cfg.Vendor)
+		}
+	}
+
+	reqs, err := compile(cmds)
+	if err != nil {
+		return nil, fmt.Errorf("vendor %s: %w", cfg.Vendor, err)
+	}
+
+	scheme := "http"
+	transport := &http.Transport{
+		MaxIdleConns:        4,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     30 * time.Second,
+	}
+	if cfg.HTTPS {
+		scheme = "https"
+		tc, err := httpauth.TLSConfig(cfg.CACertFile, cfg.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tc
+	}
+
+	host := cfg.Address
+	if cmds.Port != 0 {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = net.JoinHostPort(host, strconv.Itoa(cmds.Port))
+	}
+
+	var rt http.RoundTripper = transport
+	if cfg.Username != "" {
+		rt = &httpauth.Transport{
+			Base:     transport,
+			Username: cfg.Username,
+			Password: cfg.Password,
+		}
+	}
+
+	c := &Controller{
+		vendor:  cfg.Vendor,
+		baseURL: fmt.Sprintf("%s://%s", scheme, host),
+		client: &http.Client{
+			Timeout:   500 * time.Millisecond,
+			Transport: rt,
+		},
+		cmds:   cmds,
+		reqs:   reqs,
+		stopCh: make(chan struct{}),
+	}
+
+	// Wire up throttle flush callbacks
+	c.panTilt.Interval = minInterval
+	c.panTilt.StopCh = c.stopCh
+	c.panTilt.Flush = func() {
+		if c.panTilt.pending != c.panTilt.sent {
+			c.sendPanTiltCmd(c.panTilt.pending.pan, c.panTilt.pending.tilt)
+			c.panTilt.sent = c.panTilt.pending
+		}
+	}
+
+	c.zoom.Interval = minInterval
+	c.zoom.StopCh = c.stopCh
+	c.zoom.Flush = func() {
+		if c.zoom.pending != c.zoom.sent {
+			c.sendZoomCmd(c.zoom.pending)
+			c.zoom.sent = c.zoom.pending
+		}
+	}
+
+	return c, nil
+}
+
+// compile parses the templates of every configured command
+func compile(cmds CommandMap) (map[string]*compiledRequest, error) {
+	reqs := make(map[string]*compiledRequest)
+	for name, r := range map[string]Request{
+		"move":          cmds.Move,
+		"stop":          cmds.Stop,
+		"zoom_in":       cmds.ZoomIn,
+		"zoom_out":      cmds.ZoomOut,
+		"zoom_stop":     cmds.ZoomStop,
+		"preset_recall": cmds.PresetRecall,
+		"preset_save":   cmds.PresetSave,
+	} {
+		if r.Path == "" {
+			continue
+		}
+		cr := &compiledRequest{method: r.Method, contentType: r.ContentType}
+		if cr.method == "" {
+			cr.method = http.MethodGet
+		}
+		var err error
+		if cr.path, err = template.New(name).Parse(r.Path); err != nil {
+			return nil, fmt.Errorf("%s path: %w", name, err)
+		}
+		if r.Body != "" {
+			if cr.body, err = template.New(name).Parse(r.Body); err != nil {
+				return nil, fmt.Errorf("%s body: %w", name, err)
+			}
+			if cr.contentType == "" {
+				cr.contentType = "application/json"
+			}
+		}
+		reqs[name] = cr
+	}
+	return reqs, nil
+}
+
+// Close closes the controller
+func (c *Controller) Close() error {
+	close(c.stopCh)
+	return nil
+}
+
+// PanTilt sends a pan/tilt command
+// pan: -1.0 (left) to 1.0 (right)
+// tilt: -1.0 (down) to 1.0 (up)
+func (c *Controller) PanTilt(pan, tilt float64) error {
+	if c.reqs["move"] == nil {
+		if pan == 0 && tilt == 0 {
+			return nil
+		}
+		return fmt.Errorf("pan/tilt not supported by %s API", c.vendor)
+	}
+
+	c.panTilt.Lock()
+	c.panTilt.pending.pan = pan
+	c.panTilt.pending.tilt = tilt
+	changed := c.panTilt.pending != c.panTilt.sent
+	c.panTilt.Unlock()
+
+	if changed {
+		c.panTilt.Trigger()
+	}
+	return nil
+}
+
+// Zoom sends a zoom command
+// zoom: -1.0 (wide/out) to 1.0 (tele/in)
+func (c *Controller) Zoom(zoom float64) error {
+	if c.reqs["zoom_in"] == nil {
+		if zoom == 0 {
+			return nil
+		}
+		return fmt.Errorf("zoom not supported by %s API", c.vendor)
+	}
+
+	c.zoom.Lock()
+	c.zoom.pending = zoom
+	changed := c.zoom.pending != c.zoom.sent
+	c.zoom.Unlock()
+
+	if changed {
+		c.zoom.Trigger()
+	}
+	return nil
+}
+
+// Stop stops all PTZ movement immediately
+func (c *Controller) Stop() error {
+	c.panTilt.Lock()
+	c.panTilt.pending = struct{ pan, tilt float64 }{}
+	c.panTilt.sent = c.panTilt.pending
+	c.panTilt.Unlock()
+
+	c.zoom.Lock()
+	c.zoom.pending = 0
+	c.zoom.sent = 0
+	c.zoom.Unlock()
+
+	c.send("stop", Params{})
+	c.send("zoom_stop", Params{})
+	return nil
+}
+
+// RecallPreset recalls a preset position within the vendor's preset range
+func (c *Controller) RecallPreset(preset int) error {
+	if err := c.checkPreset(preset); err != nil {
+		return err
+	}
+	return c.send("preset_recall", Params{Preset: preset})
+}
+
+// SavePreset saves current position to a preset within the vendor's preset range
+func (c *Controller) SavePreset(preset int) error {
+	if err := c.checkPreset(preset); err != nil {
+		return err
+	}
+	return c.send("preset_save", Params{Preset: preset})
+}
+
+// Capabilities describes what the vendor's command map supports
+func (c *Controller) Capabilities() ptz.Capabilities {
+	caps := ptz.Capabilities{
+		PanTilt:   c.reqs["move"] != nil,
+		Zoom:      c.reqs["zoom_in"] != nil,
+		Presets:   c.reqs["preset_recall"] != nil,
+		PresetMin: c.cmds.PresetMin,
+		PresetMax: c.cmds.PresetMax,
+	}
+	if caps.PanTilt {
+		caps.PanSpeedSteps = c.cmds.PanSpeed.Max - c.cmds.PanSpeed.Min + 1
+		caps.TiltSpeedSteps = c.cmds.TiltSpeed.Max - c.cmds.TiltSpeed.Min + 1
+	}
+	if caps.Zoom {
+		caps.ZoomSpeedSteps = c.cmds.ZoomSpeed.Max - c.cmds.ZoomSpeed.Min + 1
+	}
+	return caps
+}
+
+func (c *Controller) checkPreset(preset int) error {
+	if preset < c.cmds.PresetMin || preset > c.cmds.PresetMax {
+		return fmt.Errorf("preset must be %d-%d for %s", c.cmds.PresetMin, c.cmds.PresetMax, c.vendor)
+	}
+	return nil
+}
+
+// sendPanTiltCmd sends the move command in the combined direction, or stop
+func (c *Controller) sendPanTiltCmd(pan, tilt float64) {
+	var vertical, horizontal string
+	if tilt > 0.05 {
+		vertical = "up"
+	} else if tilt < -0.05 {
+		vertical = "down"
+	}
+	if pan < -0.05 {
+		horizontal = "left"
+	} else if pan > 0.05 {
+		horizontal = "right"
+	}
+
+	direction := vertical + horizontal
+	if direction == "" {
+		c.send("stop", Params{})
+		return
+	}
+	if name, ok := c.cmds.Directions[direction]; ok {
+		direction = name
+	}
+
+	panSpeed := scale(pan, c.cmds.PanSpeed)
+	tiltSpeed := scale(tilt, c.cmds.TiltSpeed)
+	c.send("move", Params{
+		Direction: direction,
+		PanSpeed:  panSpeed,
+		TiltSpeed: tiltSpeed,
+		Pan:       signed(pan, panSpeed),
+		Tilt:      signed(tilt, tiltSpeed),
+	})
+}
+
+// sendZoomCmd sends zoom in, zoom out or zoom stop
+func (c *Controller) sendZoomCmd(zoom float64) {
+	speed := scale(zoom, c.cmds.ZoomSpeed)
+	params := Params{ZoomSpeed: speed, Zoom: signed(zoom, speed)}
+	switch {
+	case zoom > 0.05:
+		c.send("zoom_in", params)
+	case zoom < -0.05:
+		c.send("zoom_out", params)
+	default:
+		c.send("zoom_stop", params)
+	}
+}
+
+// send renders a command and sends it (fire-and-forget, non-blocking)
+func (c *Controller) send(name string, params Params) error {
+	cr := c.reqs[name]
+	if cr == nil {
+		return fmt.Errorf("%s not supported by %s API", strings.ReplaceAll(name, "_", " "), c.vendor)
+	}
+
+	var path, body bytes.Buffer
+	if err := cr.path.Execute(&path, params); err != nil {
+		return err
+	}
+	if cr.body != nil {
+		if err := cr.body.Execute(&body, params); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		req, err := http.NewRequest(cr.method, c.baseURL+path.String(), bytes.NewReader(body.Bytes()))
+		if err != nil {
+			log.Printf("HTTP CGI: %v", err)
+			return
+		}
+		if cr.body != nil {
+			req.Header.Set("Content-Type", cr.contentType)
+		}
+		resp, err := c.client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	return nil
+}
+
+// scale maps |v| (0.0-1.0) onto the vendor's speed range
+func scale(v float64, r SpeedRange) int {
+	if v < 0 {
+		v = -v
+	}
+	if v > 1 {
+		v = 1
+	}
+	return r.Min + int(v*float64(r.Max-r.Min)+0.5)
+}
+
+// signed gives speed the sign of v
+func signed(v float64, speed int) int {
+	if v < 0 {
+		return -speed
+	}
+	return speed
+}
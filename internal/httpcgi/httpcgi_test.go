@@ -0,0 +1,185 @@
+package httpcgi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// request is what the camera stand-in received
+type request struct {
+	method, uri, body, contentType string
+}
+
+// newCamera starts a stand-in that records every request
+func newCamera(t *testing.T) (string, <-chan request) {
+	t.Helper()
+	reqs := make(chan request, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs <- request{r.Method, r.URL.RequestURI(), string(body), r.Header.Get("Content-Type")}
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), reqs
+}
+
+// received collects n requests, sorted by URI since commands are sent
+// concurrently
+func received(t *testing.T, reqs <-chan request, n int) []request {
+	t.Helper()
+	var got []request
+	for len(got) < n {
+		select {
+		case r := <-reqs:
+			got = append(got, r)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d requests, want %d", len(got), n)
+		}
+	}
+	select {
+	case r := <-reqs:
+		t.Errorf("unexpected request %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].uri < got[j].uri })
+	return got
+}
+
+func get(uri string) request {
+	return request{method: http.MethodGet, uri: uri}
+}
+
+func TestCommands(t *testing.T) {
+	rest := CommandMap{
+		PanSpeed:  SpeedRange{Min: 0, Max: 100},
+		TiltSpeed: SpeedRange{Min: 0, Max: 100},
+		Move:      Request{Method: "PUT", Path: "/ptz?pan={{.Pan}}&tilt={{.Tilt}}", Body: `{"dir":"{{.Direction}}"}`, ContentType: "text/plain"},
+		Stop:      Request{Method: "PUT", Path: "/ptz?pan=0&tilt=0"},
+	}
+	birdDog := Vendors["birddog"]
+	birdDog.Port = 0 // The stand-in's port
+
+	tests := []struct {
+		name string
+		cmds CommandMap
+		call func(c *Controller) error
+		want []request
+	}{
+		{"right", ptzOptics, func(c *Controller) error { return c.PanTilt(1, 0) },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&right&24&1")}},
+		{"down", ptzOptics, func(c *Controller) error { return c.PanTilt(0, -1) },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&down&1&20")}},
+		{"diagonal renamed", ptzOptics, func(c *Controller) error { return c.PanTilt(-0.5, 0.5) },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&leftup&13&11")}},
+		{"within the deadzone", ptzOptics, func(c *Controller) error { return c.PanTilt(0.01, -0.01) },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&ptzstop&0&0")}},
+		{"zoom in", ptzOptics, func(c *Controller) error { return c.Zoom(1) },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&zoomin&7")}},
+		{"zoom out", ptzOptics, func(c *Controller) error { return c.Zoom(-0.5) },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&zoomout&4")}},
+		{"stop", ptzOptics, func(c *Controller) error { return c.Stop() },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&ptzstop&0&0"), get("/cgi-bin/ptzctrl.cgi?ptzcmd&zoomstop&0")}},
+		{"recall preset", ptzOptics, func(c *Controller) error { return c.RecallPreset(3) },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&poscall&3")}},
+		{"save preset", ptzOptics, func(c *Controller) error { return c.SavePreset(254) },
+			[]request{get("/cgi-bin/ptzctrl.cgi?ptzcmd&posset&254")}},
+		{"signed speeds with a body", rest, func(c *Controller) error { return c.PanTilt(-1, 0.5) },
+			[]request{{"PUT", "/ptz?pan=-100&tilt=50", `{"dir":"upleft"}`, "text/plain"}}},
+		{"BirdDog recall", birdDog, func(c *Controller) error { return c.RecallPreset(5) },
+			[]request{{"POST", "/recall", `{"Preset":"Preset-5"}`, "application/json"}}},
+		{"BirdDog save", birdDog, func(c *Controller) error { return c.SavePreset(64) },
+			[]request{{"POST", "/save", `{"Preset":"Preset-64"}`, "application/json"}}},
+		{"BirdDog stop", birdDog, func(c *Controller) error {
+			if err := c.PanTilt(0, 0); err != nil {
+				return err
+			}
+			return c.Zoom(0)
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, reqs := newCamera(t)
+			c, err := NewController(Config{Address: addr, Vendor: "test", Commands: &tt.cmds})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if err := tt.call(c); err != nil {
+				t.Fatal(err)
+			}
+			got := received(t, reqs, len(tt.want))
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("request %d: %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	addr, reqs := newCamera(t)
+	c, err := NewController(Config{Address: addr, Vendor: "birddog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for name, err := range map[string]error{
+		"pan/tilt":       c.PanTilt(1, 0),
+		"zoom":           c.Zoom(-1),
+		"preset 0":       c.RecallPreset(0),
+		"preset 65":      c.SavePreset(65),
+		"unknown vendor": func() error { _, err := NewController(Config{Address: addr, Vendor: "nope"}); return err }(),
+	} {
+		if err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+	received(t, reqs, 0)
+
+	caps := c.Capabilities()
+	if caps.PanTilt || caps.Zoom || !caps.Presets || caps.PresetMin != 1 || caps.PresetMax != 64 {
+		t.Errorf("capabilities %+v", caps)
+	}
+}
+
+func TestVendorPort(t *testing.T) {
+	for addr, want := range map[string]string{
+		"cam.local":      "http://cam.local:8080",
+		"cam.local:1234": "http://cam.local:8080",
+		"10.0.0.5":       "http://10.0.0.5:8080",
+	} {
+		c, err := NewController(Config{Address: addr, Vendor: "birddog"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.baseURL != want {
+			t.Errorf("%s: base URL %s, want %s", addr, c.baseURL, want)
+		}
+		c.Close()
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	if _, err := NewController(Config{Address: "cam", Commands: &CommandMap{Move: Request{Path: "/{{.Direction"}}}); err == nil {
+		t.Error("unterminated template accepted")
+	}
+
+	c, err := NewController(Config{Address: "cam", Commands: &CommandMap{
+		PresetMax:    10,
+		PresetRecall: Request{Path: "/preset/{{.Unknown}}"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.RecallPreset(1); err == nil {
+		t.Error("template with an unknown field executed")
+	}
+}
@@ -0,0 +1,116 @@
+package httpcgi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Request is a templated HTTP request. Path and Body are text/template
+// strings executed with a Params value, e.g.
+// "/cgi-bin/ptzctrl.cgi?ptzcmd&{{.Direction}}&{{.PanSpeed}}&{{.TiltSpeed}}".
+type Request struct {
+	Method      string `json:"method,omitempty"` // Default GET
+	Path        string `json:"path"`
+	Body        string `json:"body,omitempty"`
+	ContentType string `json:"content_type,omitempty"` // Default application/json when Body is set
+}
+
+// SpeedRange is the vendor's integer speed scale for one axis
+type SpeedRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// CommandMap describes a vendor's HTTP API. Commands left empty are
+// reported as unsupported.
+type CommandMap struct {
+	Port int `json:"port,omitempty"` // Default 80 (443 with HTTPS)
+
+	PanSpeed  SpeedRange `json:"pan_speed"`
+	TiltSpeed SpeedRange `json:"tilt_speed"`
+	ZoomSpeed SpeedRange `json:"zoom_speed"`
+	PresetMin int        `json:"preset_min"`
+	PresetMax int        `json:"preset_max"`
+
+	// Directions renames the default direction names (up, down, left, right,
+	// upleft, upright, downleft, downright) to the vendor's, e.g. "upleft": "leftup"
+	Directions map[string]string `json:"directions,omitempty"`
+
+	Move         Request `json:"move"`          // Pan/tilt in .Direction at .PanSpeed/.TiltSpeed
+	Stop         Request `json:"stop"`          // Stop pan/tilt
+	ZoomIn       Request `json:"zoom_in"`       // Zoom tele at .ZoomSpeed
+	ZoomOut      Request `json:"zoom_out"`      // Zoom wide at .ZoomSpeed
+	ZoomStop     Request `json:"zoom_stop"`     // Stop zoom
+	PresetRecall Request `json:"preset_recall"` // Recall .Preset
+	PresetSave   Request `json:"preset_save"`   // Save .Preset
+}
+
+// Params are the values available to request templates
+type Params struct {
+	Direction string // Vendor direction name
+	PanSpeed  int    // Unsigned speeds in the vendor's range
+	TiltSpeed int
+	ZoomSpeed int
+	Pan       int // Signed speeds (negative = left/down/wide), for REST style APIs
+	Tilt      int
+	Zoom      int
+	Preset    int
+}
+
+// ptzOptics is the CGI API shared by PTZOptics and many OEM cameras (Marshall, etc.)
+var ptzOptics = CommandMap{
+	PanSpeed:  SpeedRange{Min: 1, Max: 24},
+	TiltSpeed: SpeedRange{Min: 1, Max: 20},
+	ZoomSpeed: SpeedRange{Min: 0, Max: 7},
+	PresetMin: 0,
+	PresetMax: 254,
+	Directions: map[string]string{
+		"upleft":    "leftup",
+		"upright":   "rightup",
+		"downleft":  "leftdown",
+		"downright": "rightdown",
+	},
+	Move:         Request{Path: "/cgi-bin/ptzctrl.cgi?ptzcmd&{{.Direction}}&{{.PanSpeed}}&{{.TiltSpeed}}"},
+	Stop:         Request{Path: "/cgi-bin/ptzctrl.cgi?ptzcmd&ptzstop&0&0"},
+	ZoomIn:       Request{Path: "/cgi-bin/ptzctrl.cgi?ptzcmd&zoomin&{{.ZoomSpeed}}"},
+	ZoomOut:      Request{Path: "/cgi-bin/ptzctrl.cgi?ptzcmd&zoomout&{{.ZoomSpeed}}"},
+	ZoomStop:     Request{Path: "/cgi-bin/ptzctrl.cgi?ptzcmd&zoomstop&0"},
+	PresetRecall: Request{Path: "/cgi-bin/ptzctrl.cgi?ptzcmd&poscall&{{.Preset}}"},
+	PresetSave:   Request{Path: "/cgi-bin/ptzctrl.cgi?ptzcmd&posset&{{.Preset}}"},
+}
+
+// birdDog is the BirdDog REST API on port 8080. It exposes preset recall and
+// save; live pan/tilt/zoom is only available over VISCA.
+var birdDog = CommandMap{
+	Port:         8080,
+	PresetMin:    1,
+	PresetMax:    64,
+	PresetRecall: Request{Method: "POST", Path: "/recall", Body: `{"Preset":"Preset-{{.Preset}}"}`},
+	PresetSave:   Request{Method: "POST", Path: "/save", Body: `{"Preset":"Preset-{{.Preset}}"}`},
+}
+
+// Vendors holds the built-in command maps by name
+var Vendors = map[string]CommandMap{
+	"ptzoptics": ptzOptics,
+	"marshall":  ptzOptics,
+	"birddog":   birdDog,
+}
+
+// LoadVendors reads additional command maps from a JSON file of the form
+// {"vendor-name": {CommandMap...}} and adds them to Vendors, replacing
+// built-in entries with the same name
+func LoadVendors(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var vendors map[string]CommandMap
+	if err := json.Unmarshal(data, &vendors); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for name, cmds := range vendors {
+		Vendors[name] = cmds
+	}
+	return nil
+}
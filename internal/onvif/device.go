@@ -0,0 +1,54 @@
+package onvif
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeviceInformation is the GetDeviceInformation response, plus whether the
+// camera advertises a PTZ service
+type DeviceInformation struct {
+	Manufacturer    string
+	Model           string
+	FirmwareVersion string
+	SerialNumber    string
+	HasPTZ          bool
+}
+
+// DeviceInformation queries the camera's manufacturer, model and firmware
+func (c *Controller) DeviceInformation() (DeviceInformation, error) {
+	var resp struct {
+		Info DeviceInformation `xml:"Body>GetDeviceInformationResponse"`
+	}
+	if err := c.call(c.deviceURL, `<tds:GetDeviceInformation/>`, &resp); err != nil {
+		return DeviceInformation{}, fmt.Errorf("GetDeviceInformation failed: %w", err)
+	}
+
+	info := resp.Info
+	info.Manufacturer = strings.TrimSpace(info.Manufacturer)
+	info.Model = strings.TrimSpace(info.Model)
+	info.FirmwareVersion = strings.TrimSpace(info.FirmwareVersion)
+	info.SerialNumber = strings.TrimSpace(info.SerialNumber)
+	info.HasPTZ = c.ptzURL != ""
+	return info, nil
+}
+
+// Identify queries an ONVIF camera's device information without resolving
+// a media profile or loading presets
+func Identify(cfg Config) (DeviceInformation, error) {
+	if cfg.Address == "" {
+		return DeviceInformation{}, fmt.Errorf("camera address is required")
+	}
+
+	c := newClient(cfg)
+	c.syncClock()
+
+	info, err := c.DeviceInformation()
+	if err != nil {
+		return info, err
+	}
+	if c.resolveServices() == nil {
+		info.HasPTZ = true
+	}
+	return info, nil
+}
@@ -0,0 +1,117 @@
+package onvif
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const wsDiscoveryAddr = "239.255.255.250:3702"
+
+const probeTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">
+<e:Header>
+<w:MessageID>uuid:%s</w:MessageID>
+<w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>
+<w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action>
+</e:Header>
+<e:Body>
+<d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe>
+</e:Body>
+</e:Envelope>`
+
+// Device is an ONVIF camera found by WS-Discovery
+type Device struct {
+	Endpoint string   // WS-Addressing endpoint reference (usually urn:uuid:...)
+	XAddrs   []string // Device service URLs
+	Name     string   // From the onvif://www.onvif.org/name/ scope
+	Hardware string   // From the onvif://www.onvif.org/hardware/ scope
+}
+
+// Discover sends a WS-Discovery probe on the LAN and collects the cameras
+// that answer within timeout
+func Discover(timeout time.Duration) ([]Device, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", wsDiscoveryAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	probe := fmt.Sprintf(probeTemplate, newUUID())
+	if _, err := conn.WriteToUDP([]byte(probe), dst); err != nil {
+		return nil, fmt.Errorf("failed to send probe: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var devices []Device
+	seen := make(map[string]bool)
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// Read deadline reached
+			break
+		}
+		for _, d := range parseProbeMatches(buf[:n]) {
+			if seen[d.Endpoint] {
+				continue
+			}
+			seen[d.Endpoint] = true
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// parseProbeMatches extracts devices from a ProbeMatches response
+func parseProbeMatches(data []byte) []Device {
+	var resp struct {
+		Matches []struct {
+			Address string `xml:"EndpointReference>Address"`
+			Scopes  string `xml:"Scopes"`
+			XAddrs  string `xml:"XAddrs"`
+		} `xml:"Body>ProbeMatches>ProbeMatch"`
+	}
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil
+	}
+
+	var devices []Device
+	for _, m := range resp.Matches {
+		d := Device{
+			Endpoint: strings.TrimSpace(m.Address),
+			XAddrs:   strings.Fields(m.XAddrs),
+		}
+		for _, scope := range strings.Fields(m.Scopes) {
+			if v, ok := strings.CutPrefix(scope, "onvif://www.onvif.org/name/"); ok {
+				d.Name, _ = url.PathUnescape(v)
+			} else if v, ok := strings.CutPrefix(scope, "onvif://www.onvif.org/hardware/"); ok {
+				d.Hardware, _ = url.PathUnescape(v)
+			}
+		}
+		if d.Endpoint == "" && len(d.XAddrs) > 0 {
+			d.Endpoint = d.XAddrs[0]
+		}
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+// newUUID returns a random (version 4) UUID string
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0F) | 0x40
+	b[8] = (b[8] & 0x3F) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
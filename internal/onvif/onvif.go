@@ -0,0 +1,412 @@
+package onvif
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/ptz"
+)
+
+const minInterval = 100 * time.Millisecond // ~10 commands/sec max, SOAP requests are heavy
+
+// Controller manages ONVIF PTZ communication with a camera over SOAP
+type Controller struct {
+	client       *http.Client
+	username     string
+	password     string
+	clockOffset  time.Duration // Camera clock minus local clock, for UsernameToken
+	deviceURL    string
+	mediaURL     string
+	ptzURL       string
+	profileToken string
+	stopCh       chan struct{}
+
+	// Requests are sent in order by a single worker so a Stop is never
+	// overtaken by an earlier move. Moves are not queued: moveReady tells
+	// the worker to send whatever velocity is pending when it gets to it.
+	requests  chan func()
+	moveReady chan struct{}
+
+	presetsMu sync.Mutex
+	presets   map[int]string // Preset number -> camera preset token
+
+	// Combined pan/tilt/zoom velocity; ContinuousMove sets all axes at once
+	move struct {
+		ptz.Throttle
+		pending, sent struct{ pan, tilt, zoom float64 }
+	}
+}
+
+// Config for ONVIF controller
+type Config struct {
+	Address      string // Camera host[:port] or device service URL
+	Username     string
+	Password     string
+	ProfileToken string // Media profile to control; default: first profile with PTZ
+}
+
+// NewController connects to an ONVIF camera and resolves its PTZ service and profile
+func NewController(cfg Config) (*Controller, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("camera address is required")
+	}
+
+	c := newClient(cfg)
+
+	c.syncClock()
+
+	if err := c.resolveServices(); err != nil {
+		return nil, err
+	}
+	if c.profileToken == "" {
+		if err := c.resolveProfile(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.loadPresets(); err != nil {
+		log.Printf("ONVIF: Failed to load presets: %v", err)
+	}
+
+	// Wire up throttle flush callback
+	c.move.Interval = minInterval
+	c.move.StopCh = c.stopCh
+	c.move.Flush = func() {
+		if c.move.pending != c.move.sent {
+			select {
+			case c.moveReady <- struct{}{}:
+			default: // Already signalled
+			}
+		}
+	}
+
+	go c.worker()
+
+	return c, nil
+}
+
+// newClient creates a controller that can make SOAP calls to the device
+// service but has not resolved its PTZ service or profile yet
+func newClient(cfg Config) *Controller {
+	deviceURL := cfg.Address
+	if !strings.Contains(deviceURL, "://") {
+		deviceURL = fmt.Sprintf("http://%s/onvif/device_service", cfg.Address)
+	}
+
+	return &Controller{
+		client: &http.Client{
+			Timeout: 2 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:        4,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     30 * time.Second,
+			},
+		},
+		username:     cfg.Username,
+		password:     cfg.Password,
+		deviceURL:    deviceURL,
+		profileToken: cfg.ProfileToken,
+		stopCh:       make(chan struct{}),
+		requests:     make(chan func(), 16),
+		moveReady:    make(chan struct{}, 1),
+		presets:      make(map[int]string),
+	}
+}
+
+// Close closes the controller
+func (c *Controller) Close() error {
+	close(c.stopCh)
+	return nil
+}
+
+// PanTilt sends a pan/tilt command
+// pan: -1.0 (left) to 1.0 (right)
+// tilt: -1.0 (down) to 1.0 (up)
+func (c *Controller) PanTilt(pan, tilt float64) error {
+	c.move.Lock()
+	c.move.pending.pan = deadzone(pan)
+	c.move.pending.tilt = deadzone(tilt)
+	changed := c.move.pending != c.move.sent
+	c.move.Unlock()
+
+	if changed {
+		c.move.Trigger()
+	}
+	return nil
+}
+
+// Zoom sends a zoom command
+// zoom: -1.0 (wide/out) to 1.0 (tele/in)
+func (c *Controller) Zoom(zoom float64) error {
+	c.move.Lock()
+	c.move.pending.zoom = deadzone(zoom)
+	changed := c.move.pending != c.move.sent
+	c.move.Unlock()
+
+	if changed {
+		c.move.Trigger()
+	}
+	return nil
+}
+
+// Stop stops all PTZ movement immediately
+func (c *Controller) Stop() error {
+	c.move.Lock()
+	c.move.pending = struct{ pan, tilt, zoom float64 }{}
+	c.move.sent = c.move.pending
+	c.move.Unlock()
+
+	c.enqueue(func() {
+		body := fmt.Sprintf(`<tptz:Stop><tptz:ProfileToken>%s</tptz:ProfileToken><tptz:PanTilt>true</tptz:PanTilt><tptz:Zoom>true</tptz:Zoom></tptz:Stop>`,
+			escape(c.profileToken))
+		if err := c.call(c.ptzURL, body, nil); err != nil {
+			log.Printf("ONVIF: Stop failed: %v", err)
+		}
+	})
+	return nil
+}
+
+// RecallPreset recalls a preset position (0-255)
+func (c *Controller) RecallPreset(preset int) error {
+	if preset < 0 || preset > 255 {
+		return fmt.Errorf("preset must be 0-255")
+	}
+	token := c.presetToken(preset)
+	body := fmt.Sprintf(`<tptz:GotoPreset><tptz:ProfileToken>%s</tptz:ProfileToken><tptz:PresetToken>%s</tptz:PresetToken></tptz:GotoPreset>`,
+		escape(c.profileToken), escape(token))
+	return c.do(func() error { return c.call(c.ptzURL, body, nil) })
+}
+
+// SavePreset saves current position to a preset (0-255).
+// The preset is stored under the name "<preset>", overwriting an existing one.
+func (c *Controller) SavePreset(preset int) error {
+	if preset < 0 || preset > 255 {
+		return fmt.Errorf("preset must be 0-255")
+	}
+
+	c.presetsMu.Lock()
+	token, exists := c.presets[preset]
+	c.presetsMu.Unlock()
+
+	tokenElem := ""
+	if exists {
+		tokenElem = fmt.Sprintf(`<tptz:PresetToken>%s</tptz:PresetToken>`, escape(token))
+	}
+	body := fmt.Sprintf(`<tptz:SetPreset><tptz:ProfileToken>%s</tptz:ProfileToken><tptz:PresetName>%d</tptz:PresetName>%s</tptz:SetPreset>`,
+		escape(c.profileToken), preset, tokenElem)
+
+	var resp struct {
+		PresetToken string `xml:"Body>SetPresetResponse>PresetToken"`
+	}
+	if err := c.do(func() error { return c.call(c.ptzURL, body, &resp) }); err != nil {
+		return err
+	}
+
+	if resp.PresetToken != "" {
+		c.presetsMu.Lock()
+		c.presets[preset] = resp.PresetToken
+		c.presetsMu.Unlock()
+	}
+	return nil
+}
+
+// Capabilities describes what ONVIF PTZ control supports
+func (c *Controller) Capabilities() ptz.Capabilities {
+	return ptz.Capabilities{
+		PanTilt:      true,
+		Zoom:         true,
+		AbsoluteMove: true,
+		Presets:      true,
+		PresetMin:    0,
+		PresetMax:    255,
+	}
+}
+
+// AbsoluteMove moves to an absolute position in the camera's generic space
+// pan, tilt: -1.0 to 1.0; zoom: 0.0 (wide) to 1.0 (tele)
+func (c *Controller) AbsoluteMove(pan, tilt, zoom float64) error {
+	body := fmt.Sprintf(`<tptz:AbsoluteMove><tptz:ProfileToken>%s</tptz:ProfileToken><tptz:Position><tt:PanTilt x="%.4f" y="%.4f"/><tt:Zoom x="%.4f"/></tptz:Position></tptz:AbsoluteMove>`,
+		escape(c.profileToken), clamp(pan, -1, 1), clamp(tilt, -1, 1), clamp(zoom, 0, 1))
+	return c.do(func() error { return c.call(c.ptzURL, body, nil) })
+}
+
+// continuousMove sends a ContinuousMove with the given velocities (-1.0 to 1.0)
+func (c *Controller) continuousMove(pan, tilt, zoom float64) {
+	body := fmt.Sprintf(`<tptz:ContinuousMove><tptz:ProfileToken>%s</tptz:ProfileToken><tptz:Velocity><tt:PanTilt x="%.4f" y="%.4f"/><tt:Zoom x="%.4f"/></tptz:Velocity></tptz:ContinuousMove>`,
+		escape(c.profileToken), clamp(pan, -1, 1), clamp(tilt, -1, 1), clamp(zoom, -1, 1))
+	if err := c.call(c.ptzURL, body, nil); err != nil {
+		log.Printf("ONVIF: ContinuousMove failed: %v", err)
+	}
+}
+
+// enqueue schedules a request on the worker, in order
+func (c *Controller) enqueue(req func()) {
+	select {
+	case c.requests <- req:
+	case <-c.stopCh:
+	}
+}
+
+// do runs a request on the worker and waits for its result
+func (c *Controller) do(req func() error) error {
+	result := make(chan error, 1)
+	c.enqueue(func() { result <- req() })
+	select {
+	case err := <-result:
+		return err
+	case <-c.stopCh:
+		return fmt.Errorf("controller closed")
+	}
+}
+
+// worker sends queued requests one at a time
+func (c *Controller) worker() {
+	for {
+		select {
+		case req := <-c.requests:
+			req()
+		case <-c.moveReady:
+			c.sendMove()
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// sendMove sends the latest pending velocity if it hasn't been sent yet,
+// so moves that went stale while a request was in flight are skipped
+func (c *Controller) sendMove() {
+	c.move.Lock()
+	p := c.move.pending
+	changed := p != c.move.sent
+	c.move.sent = p
+	c.move.Unlock()
+
+	if changed {
+		c.continuousMove(p.pan, p.tilt, p.zoom)
+	}
+}
+
+// syncClock estimates the camera clock offset; UsernameToken digests are
+// rejected when the Created timestamp is too far from the camera's clock
+func (c *Controller) syncClock() {
+	var resp struct {
+		UTC struct {
+			Date struct{ Year, Month, Day int }
+			Time struct{ Hour, Minute, Second int }
+		} `xml:"Body>GetSystemDateAndTimeResponse>SystemDateAndTime>UTCDateTime"`
+	}
+
+	// GetSystemDateAndTime does not require authentication
+	username := c.username
+	c.username = ""
+	err := c.call(c.deviceURL, `<tds:GetSystemDateAndTime/>`, &resp)
+	c.username = username
+	if err != nil || resp.UTC.Date.Year == 0 {
+		return
+	}
+
+	d, t := resp.UTC.Date, resp.UTC.Time
+	camera := time.Date(d.Year, time.Month(d.Month), d.Day, t.Hour, t.Minute, t.Second, 0, time.UTC)
+	c.clockOffset = time.Until(camera)
+}
+
+// resolveServices finds the Media and PTZ service endpoints
+func (c *Controller) resolveServices() error {
+	var resp struct {
+		Media string `xml:"Body>GetCapabilitiesResponse>Capabilities>Media>XAddr"`
+		PTZ   string `xml:"Body>GetCapabilitiesResponse>Capabilities>PTZ>XAddr"`
+	}
+	body := `<tds:GetCapabilities><tds:Category>All</tds:Category></tds:GetCapabilities>`
+	if err := c.call(c.deviceURL, body, &resp); err != nil {
+		return fmt.Errorf("GetCapabilities failed: %w", err)
+	}
+	if resp.PTZ == "" {
+		return fmt.Errorf("camera does not advertise a PTZ service")
+	}
+	c.mediaURL = strings.TrimSpace(resp.Media)
+	c.ptzURL = strings.TrimSpace(resp.PTZ)
+	return nil
+}
+
+// resolveProfile picks the first media profile with a PTZ configuration
+func (c *Controller) resolveProfile() error {
+	var resp struct {
+		Profiles []struct {
+			Token            string `xml:"token,attr"`
+			PTZConfiguration *struct {
+				Token string `xml:"token,attr"`
+			}
+		} `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+	if err := c.call(c.mediaURL, `<trt:GetProfiles/>`, &resp); err != nil {
+		return fmt.Errorf("GetProfiles failed: %w", err)
+	}
+	for _, p := range resp.Profiles {
+		if p.PTZConfiguration != nil {
+			c.profileToken = p.Token
+			return nil
+		}
+	}
+	return fmt.Errorf("no media profile with PTZ configuration")
+}
+
+// loadPresets maps numerically named presets to their tokens
+func (c *Controller) loadPresets() error {
+	var resp struct {
+		Presets []struct {
+			Token string `xml:"token,attr"`
+			Name  string
+		} `xml:"Body>GetPresetsResponse>Preset"`
+	}
+	body := fmt.Sprintf(`<tptz:GetPresets><tptz:ProfileToken>%s</tptz:ProfileToken></tptz:GetPresets>`, escape(c.profileToken))
+	if err := c.call(c.ptzURL, body, &resp); err != nil {
+		return err
+	}
+
+	c.presetsMu.Lock()
+	defer c.presetsMu.Unlock()
+	for _, p := range resp.Presets {
+		if n, err := strconv.Atoi(strings.TrimSpace(p.Name)); err == nil {
+			c.presets[n] = p.Token
+		} else if n, err := strconv.Atoi(p.Token); err == nil {
+			if _, ok := c.presets[n]; !ok {
+				c.presets[n] = p.Token
+			}
+		}
+	}
+	return nil
+}
+
+// presetToken returns the camera token for a preset number, assuming
+// the number itself is the token if the camera didn't list it
+func (c *Controller) presetToken(preset int) string {
+	c.presetsMu.Lock()
+	defer c.presetsMu.Unlock()
+	if token, ok := c.presets[preset]; ok {
+		return token
+	}
+	return strconv.Itoa(preset)
+}
+
+// deadzone zeroes small values so the camera fully stops
+func deadzone(v float64) float64 {
+	if v > -0.05 && v < 0.05 {
+		return 0
+	}
+	return v
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
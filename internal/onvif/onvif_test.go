@@ -0,0 +1,317 @@
+package onvif
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// request is the part of a SOAP request envelope the tests check
+type request struct {
+	Token struct {
+		Username string
+		Password string
+		Nonce    string
+		Created  string
+	} `xml:"Header>Security>UsernameToken"`
+	Body struct {
+		Inner string `xml:",innerxml"`
+	}
+}
+
+type vector struct {
+	X string `xml:"x,attr"`
+	Y string `xml:"y,attr"`
+}
+
+// ptzRequest is a PTZ service request body
+type ptzRequest struct {
+	XMLName      xml.Name
+	ProfileToken string
+	Velocity     struct {
+		PanTilt vector
+		Zoom    vector
+	}
+	Position struct {
+		PanTilt vector
+		Zoom    vector
+	}
+	PanTilt     string
+	Zoom        string
+	PresetToken string
+	PresetName  string
+}
+
+// camera is an ONVIF device stand-in with its clock an hour ahead
+type camera struct {
+	t        *testing.T
+	password string
+	clock    time.Time
+	url      string
+	delay    time.Duration // Time taken by each PTZ request
+
+	mu       sync.Mutex
+	requests []ptzRequest
+}
+
+func (cam *camera) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	var req request
+	if err := xml.Unmarshal(data, &req); err != nil {
+		cam.t.Errorf("bad envelope: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if strings.Contains(req.Body.Inner, "GetSystemDateAndTime") {
+		if req.Token.Username != "" {
+			cam.t.Error("GetSystemDateAndTime sent with credentials")
+		}
+		fmt.Fprintf(w, `<Envelope><Body><GetSystemDateAndTimeResponse><SystemDateAndTime><UTCDateTime>
+<Date><Year>%d</Year><Month>%d</Month><Day>%d</Day></Date>
+<Time><Hour>%d</Hour><Minute>%d</Minute><Second>%d</Second></Time>
+</UTCDateTime></SystemDateAndTime></GetSystemDateAndTimeResponse></Body></Envelope>`,
+			cam.clock.Year(), cam.clock.Month(), cam.clock.Day(), cam.clock.Hour(), cam.clock.Minute(), cam.clock.Second())
+		return
+	}
+
+	if !cam.authorized(req) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<Envelope><Body><Fault><Code><Subcode><Value>ter:NotAuthorized</Value></Subcode></Code><Reason><Text>Sender not authorized</Text></Reason></Fault></Body></Envelope>`)
+		return
+	}
+
+	switch {
+	case strings.Contains(req.Body.Inner, "GetCapabilities"):
+		fmt.Fprintf(w, `<Envelope><Body><GetCapabilitiesResponse><Capabilities>
+<Media><XAddr>%[1]s/media</XAddr></Media><PTZ><XAddr>%[1]s/ptz</XAddr></PTZ>
+</Capabilities></GetCapabilitiesResponse></Body></Envelope>`, cam.url)
+	case strings.Contains(req.Body.Inner, "GetPresets"):
+		fmt.Fprint(w, `<Envelope><Body><GetPresetsResponse><Preset token="home"><Name>2</Name></Preset></GetPresetsResponse></Body></Envelope>`)
+	default:
+		var p ptzRequest
+		if err := xml.Unmarshal([]byte(req.Body.Inner), &p); err != nil {
+			cam.t.Errorf("bad PTZ request: %v", err)
+		}
+		time.Sleep(cam.delay)
+		cam.mu.Lock()
+		cam.requests = append(cam.requests, p)
+		cam.mu.Unlock()
+		if p.XMLName.Local == "SetPreset" {
+			fmt.Fprintf(w, `<Envelope><Body><SetPresetResponse><PresetToken>tok%s</PresetToken></SetPresetResponse></Body></Envelope>`, p.PresetName)
+			return
+		}
+		fmt.Fprint(w, `<Envelope><Body/></Envelope>`)
+	}
+}
+
+// authorized checks the WS-UsernameToken password digest and that the
+// Created timestamp follows the camera's clock
+func (cam *camera) authorized(req request) bool {
+	nonce, err := base64.StdEncoding.DecodeString(req.Token.Nonce)
+	if err != nil || len(nonce) == 0 {
+		return false
+	}
+	created, err := time.Parse(time.RFC3339, req.Token.Created)
+	if err != nil || created.Sub(cam.clock).Abs() > time.Minute {
+		cam.t.Errorf("Created %s, camera clock %s", req.Token.Created, cam.clock.Format(time.RFC3339))
+		return false
+	}
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(req.Token.Created))
+	h.Write([]byte(cam.password))
+	return req.Token.Username == "admin" && req.Token.Password == base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// moves returns the PTZ requests the camera has received
+func (cam *camera) moves() []ptzRequest {
+	cam.mu.Lock()
+	defer cam.mu.Unlock()
+	return append([]ptzRequest(nil), cam.requests...)
+}
+
+func newCamera(t *testing.T) *camera {
+	cam := &camera{t: t, password: "secret", clock: time.Now().Add(time.Hour).UTC()}
+	srv := httptest.NewServer(cam)
+	t.Cleanup(srv.Close)
+	cam.url = srv.URL
+	return cam
+}
+
+// waitFor polls until the camera has received n PTZ requests
+func waitFor(t *testing.T, cam *camera, n int) []ptzRequest {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(cam.moves()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	moves := cam.moves()
+	if len(moves) < n {
+		t.Fatalf("got %d requests, want %d", len(moves), n)
+	}
+	return moves
+}
+
+func TestUsernameToken(t *testing.T) {
+	cam := newCamera(t)
+
+	c, err := NewController(Config{Address: cam.url, Username: "admin", Password: "wrong", ProfileToken: "prof"})
+	if err == nil {
+		c.Close()
+		t.Fatal("connected with the wrong password")
+	}
+	if !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("error %q, want the SOAP fault", err)
+	}
+
+	c, err = NewController(Config{Address: cam.url, Username: "admin", Password: "secret", ProfileToken: "prof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestContinuousMoveAndStop(t *testing.T) {
+	cam := newCamera(t)
+	c, err := NewController(Config{Address: cam.url, Username: "admin", Password: "secret", ProfileToken: "prof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.PanTilt(0.5, -0.25)
+	waitFor(t, cam, 1)
+	c.Zoom(1.5) // Clamped
+	waitFor(t, cam, 2)
+	c.Stop()
+
+	moves := waitFor(t, cam, 3)
+	want := []struct {
+		name          string
+		pan, tilt, zm string
+	}{
+		{"ContinuousMove", "0.5000", "-0.2500", "0.0000"},
+		{"ContinuousMove", "0.5000", "-0.2500", "1.0000"},
+		{"Stop", "", "", ""},
+	}
+	for i, w := range want {
+		m := moves[i]
+		if m.XMLName.Local != w.name || m.ProfileToken != "prof" {
+			t.Fatalf("request %d: %s for %q, want %s for \"prof\"", i, m.XMLName.Local, m.ProfileToken, w.name)
+		}
+		v := m.Velocity
+		if v.PanTilt.X != w.pan || v.PanTilt.Y != w.tilt || v.Zoom.X != w.zm {
+			t.Errorf("request %d: velocity %v %v, want %s %s %s", i, v.PanTilt, v.Zoom, w.pan, w.tilt, w.zm)
+		}
+	}
+	if s := moves[2]; s.PanTilt != "true" || s.Zoom != "true" {
+		t.Errorf("Stop PanTilt=%q Zoom=%q, want both true", s.PanTilt, s.Zoom)
+	}
+}
+
+func TestMovesCoalesce(t *testing.T) {
+	cam := newCamera(t)
+	cam.delay = 300 * time.Millisecond
+	c, err := NewController(Config{Address: cam.url, Username: "admin", Password: "secret", ProfileToken: "prof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A one second joystick sweep while the camera is slow to answer
+	for i := 1; i <= 100; i++ {
+		c.PanTilt(float64(i)/100, 0)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stale moves are skipped, so the final velocity follows the move in
+	// flight instead of waiting behind a backlog
+	sent := func(name, pan string, within time.Duration) {
+		t.Helper()
+		deadline := time.Now().Add(within)
+		for {
+			moves := cam.moves()
+			if n := len(moves); n > 0 && moves[n-1].XMLName.Local == name && moves[n-1].Velocity.PanTilt.X == pan {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s %s not sent within %s, got %d requests", name, pan, within, len(moves))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	sent("ContinuousMove", "1.0000", 800*time.Millisecond)
+
+	c.PanTilt(-1, 0)
+	c.Stop()
+	sent("Stop", "", 800*time.Millisecond)
+}
+
+func TestAbsoluteMove(t *testing.T) {
+	cam := newCamera(t)
+	c, err := NewController(Config{Address: cam.url, Username: "admin", Password: "secret", ProfileToken: "prof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if !c.Capabilities().AbsoluteMove {
+		t.Error("absolute_move capability not reported")
+	}
+	if err := c.AbsoluteMove(0.5, -2, -1); err != nil { // Tilt and zoom clamped
+		t.Fatal(err)
+	}
+	m := waitFor(t, cam, 1)[0]
+	if m.XMLName.Local != "AbsoluteMove" || m.ProfileToken != "prof" {
+		t.Fatalf("%s for %q, want AbsoluteMove for \"prof\"", m.XMLName.Local, m.ProfileToken)
+	}
+	if p := m.Position; p.PanTilt.X != "0.5000" || p.PanTilt.Y != "-1.0000" || p.Zoom.X != "0.0000" {
+		t.Errorf("position %v %v, want 0.5000 -1.0000 0.0000", p.PanTilt, p.Zoom)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	cam := newCamera(t)
+	c, err := NewController(Config{Address: cam.url, Username: "admin", Password: "secret", ProfileToken: "prof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	steps := []struct {
+		call  func() error
+		name  string
+		token string // PresetToken sent
+	}{
+		{func() error { return c.RecallPreset(2) }, "GotoPreset", "home"}, // Listed by GetPresets
+		{func() error { return c.RecallPreset(7) }, "GotoPreset", "7"},    // Unknown; the number is the token
+		{func() error { return c.SavePreset(5) }, "SetPreset", ""},        // New preset
+		{func() error { return c.SavePreset(5) }, "SetPreset", "tok5"},    // Overwrites the camera's token
+		{func() error { return c.RecallPreset(5) }, "GotoPreset", "tok5"},
+	}
+	for i, step := range steps {
+		if err := step.call(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		m := waitFor(t, cam, i+1)[i]
+		if m.XMLName.Local != step.name || m.PresetToken != step.token || m.ProfileToken != "prof" {
+			t.Errorf("step %d: %s token %q, want %s token %q", i, m.XMLName.Local, m.PresetToken, step.name, step.token)
+		}
+		if m.XMLName.Local == "SetPreset" && m.PresetName != "5" {
+			t.Errorf("step %d: preset name %q, want \"5\"", i, m.PresetName)
+		}
+	}
+
+	if err := c.RecallPreset(256); err == nil {
+		t.Error("preset 256 accepted")
+	}
+}
@@ -0,0 +1,96 @@
+package onvif
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const envelopeTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl">
+<s:Header>%s</s:Header>
+<s:Body>%s</s:Body>
+</s:Envelope>`
+
+const securityTemplate = `<wsse:Security s:mustUnderstand="1" xmlns:wsse="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd" xmlns:wsu="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">
+<wsse:UsernameToken>
+<wsse:Username>%s</wsse:Username>
+<wsse:Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">%s</wsse:Password>
+<wsse:Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">%s</wsse:Nonce>
+<wsu:Created>%s</wsu:Created>
+</wsse:UsernameToken>
+</wsse:Security>`
+
+// soapFault is the SOAP 1.2 fault body
+type soapFault struct {
+	Code   string `xml:"Body>Fault>Code>Subcode>Value"`
+	Reason string `xml:"Body>Fault>Reason>Text"`
+}
+
+// call posts a SOAP request to endpoint and decodes the response envelope into resp.
+// resp may be nil if the response body is not needed.
+func (c *Controller) call(endpoint, body string, resp any) error {
+	envelope := fmt.Sprintf(envelopeTemplate, c.securityHeader(), body)
+
+	httpResp, err := c.client.Post(endpoint, "application/soap+xml; charset=utf-8", strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		var fault soapFault
+		if xml.Unmarshal(data, &fault) == nil && fault.Reason != "" {
+			return fmt.Errorf("SOAP fault: %s (%s)", strings.TrimSpace(fault.Reason), fault.Code)
+		}
+		return fmt.Errorf("camera returned %s", httpResp.Status)
+	}
+
+	if resp == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, resp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// securityHeader builds the WS-UsernameToken header, or "" without credentials.
+// PasswordDigest = Base64(SHA1(nonce + created + password)).
+func (c *Controller) securityHeader() string {
+	if c.username == "" {
+		return ""
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	created := time.Now().Add(c.clockOffset).UTC().Format("2006-01-02T15:04:05.000Z")
+
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(c.password))
+	digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return fmt.Sprintf(securityTemplate,
+		escape(c.username), digest, base64.StdEncoding.EncodeToString(nonce), created)
+}
+
+// escape returns s with XML special characters escaped
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
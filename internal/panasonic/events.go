@@ -0,0 +1,235 @@
+package panasonic
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"ptz-remote/internal/ptz"
+)
+
+// registerInterval is how often the notification registration is refreshed.
+// Cameras forget registrations when they reboot or enter standby.
+const registerInterval = 60 * time.Second
+
+// Event is a state change pushed by the camera over the update-notification channel
+type Event interface {
+	isEvent()
+}
+
+// TallyEvent reports a tally lamp change
+type TallyEvent struct {
+	Color string // "red" or "green"
+	On    bool
+}
+
+// PowerEvent reports a power state change
+type PowerEvent struct {
+	State string // "on", "standby" or "transition"
+}
+
+// PresetCompleteEvent reports that a preset recall has finished moving
+type PresetCompleteEvent struct {
+	Preset int
+}
+
+// PositionEvent reports the pan/tilt position (raw camera units, 0000-FFFF)
+type PositionEvent struct {
+	Pan, Tilt int
+}
+
+// LensEvent reports the lens position (raw camera units, 000-FFF)
+type LensEvent struct {
+	Zoom, Focus, Iris int
+}
+
+// UnknownEvent carries a notification this package does not parse
+type UnknownEvent struct {
+	Raw string
+}
+
+func (TallyEvent) isEvent()          {}
+func (PowerEvent) isEvent()          {}
+func (PresetCompleteEvent) isEvent() {}
+func (PositionEvent) isEvent()       {}
+func (LensEvent) isEvent()           {}
+func (UnknownEvent) isEvent()        {}
+
+// Events returns the channel of camera notifications.
+// It is nil unless Config.EventPort is set, and is closed by Close.
+func (c *Controller) Events() <-chan Event {
+	return c.events
+}
+
+// startEvents opens the notification listener and registers it with the camera
+func (c *Controller) startEvents(port int) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen for camera events: %w", err)
+	}
+	c.eventLn = ln
+	c.eventPort = ln.Addr().(*net.TCPAddr).Port
+	c.events = make(chan Event, 64)
+
+	c.eventWG.Add(2)
+	go c.acceptEvents()
+	go c.registerEvents()
+	return nil
+}
+
+// registerEvents asks the camera to push notifications to our port, and
+// refreshes the registration until the controller is closed
+func (c *Controller) registerEvents() {
+	defer c.eventWG.Done()
+
+	ticker := time.NewTicker(registerInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.eventRequest("start"); err != nil {
+			log.Printf("Panasonic: Failed to register for events: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.stopCh:
+			c.eventRequest("stop")
+			return
+		}
+	}
+}
+
+// eventRequest sends /cgi-bin/event?connect=<action>&my_port=<port>
+func (c *Controller) eventRequest(action string) error {
+	reqURL := fmt.Sprintf("%s/cgi-bin/event?connect=%s&my_port=%d&uid=0", c.rootURL, action, c.eventPort)
+	resp, err := c.client.Get(reqURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// acceptEvents accepts camera connections until the listener is closed
+func (c *Controller) acceptEvents() {
+	defer c.eventWG.Done()
+
+	for {
+		conn, err := c.eventLn.Accept()
+		if err != nil {
+			return
+		}
+		c.eventWG.Add(1)
+		go c.readEvents(conn)
+	}
+}
+
+// readEvents parses notifications from one camera connection.
+// Each notification is framed by a binary header and footer around CRLF
+// delimited ASCII command strings; only the command strings are used.
+func (c *Controller) readEvents(conn net.Conn) {
+	defer c.eventWG.Done()
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.stopCh:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(2 * registerInterval))
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(scanCRLF)
+	for scanner.Scan() {
+		conn.SetReadDeadline(time.Now().Add(2 * registerInterval))
+
+		cmd := printable(scanner.Bytes())
+		if cmd == "" {
+			continue
+		}
+		ev := parseEvent(cmd)
+		if pe, ok := ev.(PowerEvent); ok {
+			c.setPowerState(pe.State)
+		}
+		select {
+		case c.events <- ev:
+		case <-c.stopCh:
+			return
+		default:
+			// Drop event if nobody is consuming them
+		}
+	}
+}
+
+// scanCRLF is a bufio.SplitFunc splitting on "\r\n"
+func scanCRLF(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.Index(data, []byte("\r\n")); i >= 0 {
+		return i + 2, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// printable returns the longest trailing run of printable ASCII in b,
+// stripping the binary header that precedes the first command string
+func printable(b []byte) string {
+	start := len(b)
+	for start > 0 && b[start-1] >= 0x20 && b[start-1] < 0x7F {
+		start--
+	}
+	return strings.TrimSpace(string(b[start:]))
+}
+
+// parseEvent converts a notification command string to a typed event
+func parseEvent(cmd string) Event {
+	switch {
+	case strings.HasPrefix(cmd, "TLR:"):
+		return TallyEvent{Color: "red", On: cmd[4:] == "1"}
+	case strings.HasPrefix(cmd, "TLG:"):
+		return TallyEvent{Color: "green", On: cmd[4:] == "1"}
+	case cmd == "p1":
+		return PowerEvent{State: ptz.PowerOn}
+	case cmd == "p0":
+		return PowerEvent{State: ptz.PowerStandby}
+	case cmd == "p3":
+		return PowerEvent{State: ptz.PowerTransition}
+	case len(cmd) == 3 && cmd[0] == 'q':
+		// Preset playback completion: q<preset 00-99>
+		if preset, err := strconv.Atoi(cmd[1:]); err == nil {
+			return PresetCompleteEvent{Preset: preset}
+		}
+	case strings.HasPrefix(cmd, "aPC") && len(cmd) >= 11:
+		// Pan/tilt position: aPC<pan 4 hex><tilt 4 hex>
+		pan, err1 := strconv.ParseInt(cmd[3:7], 16, 32)
+		tilt, err2 := strconv.ParseInt(cmd[7:11], 16, 32)
+		if err1 == nil && err2 == nil {
+			return PositionEvent{Pan: int(pan), Tilt: int(tilt)}
+		}
+	case strings.HasPrefix(cmd, "lPI") && len(cmd) >= 12:
+		// Lens position: lPI<zoom 3 hex><focus 3 hex><iris 3 hex>
+		zoom, err1 := strconv.ParseInt(cmd[3:6], 16, 32)
+		focus, err2 := strconv.ParseInt(cmd[6:9], 16, 32)
+		iris, err3 := strconv.ParseInt(cmd[9:12], 16, 32)
+		if err1 == nil && err2 == nil && err3 == nil {
+			return LensEvent{Zoom: int(zoom), Focus: int(focus), Iris: int(iris)}
+		}
+	}
+	return UnknownEvent{Raw: cmd}
+}
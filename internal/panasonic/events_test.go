@@ -0,0 +1,132 @@
+package panasonic
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ptz-remote/internal/ptz"
+)
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want Event
+	}{
+		{"p1", PowerEvent{State: ptz.PowerOn}},
+		{"p0", PowerEvent{State: ptz.PowerStandby}},
+		{"p3", PowerEvent{State: ptz.PowerTransition}},
+		{"q05", PresetCompleteEvent{Preset: 5}},
+		{"q99", PresetCompleteEvent{Preset: 99}},
+		{"TLR:1", TallyEvent{Color: "red", On: true}},
+		{"TLR:0", TallyEvent{Color: "red", On: false}},
+		{"TLG:1", TallyEvent{Color: "green", On: true}},
+		{"TLG:0", TallyEvent{Color: "green", On: false}},
+		{"aPC80008000", PositionEvent{Pan: 0x8000, Tilt: 0x8000}},
+		{"lPI555AAAFFF", LensEvent{Zoom: 0x555, Focus: 0xAAA, Iris: 0xFFF}},
+		{"p2", UnknownEvent{Raw: "p2"}},
+		{"qAB", UnknownEvent{Raw: "qAB"}},
+		{"q5", UnknownEvent{Raw: "q5"}},
+		{"aPC8000", UnknownEvent{Raw: "aPC8000"}},
+		{"aPCXXXX8000", UnknownEvent{Raw: "aPCXXXX8000"}},
+		{"lPI555AAA", UnknownEvent{Raw: "lPI555AAA"}},
+		{"OSD:01", UnknownEvent{Raw: "OSD:01"}},
+	}
+	for _, tt := range tests {
+		if got := parseEvent(tt.cmd); got != tt.want {
+			t.Errorf("parseEvent(%q) = %#v, want %#v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestPrintable(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte("p1"), "p1"},
+		{[]byte{0x00, 0x01, 0x7F, 0xFF, 'p', '1'}, "p1"},
+		{[]byte{'x', 0x00, 'q', '0', '5'}, "q05"},
+		{[]byte(" TLR:1 "), "TLR:1"},
+		{[]byte{0x00, 0x16, 0x01}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := printable(tt.in); got != tt.want {
+			t.Errorf("printable(% X) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestEventListener registers with a stand-in camera and feeds the listener
+// a framed notification as the camera would
+func TestEventListener(t *testing.T) {
+	registered := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgi-bin/event" {
+			registered <- r.URL.RawQuery
+		}
+	}))
+	defer srv.Close()
+
+	c, err := newClient(Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.startEvents(0); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case q := <-registered:
+		if want := fmt.Sprintf("connect=start&my_port=%d&uid=0", c.eventPort); q != want {
+			t.Errorf("registration %q, want %q", q, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listener not registered with the camera")
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", c.eventPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Binary header, CRLF delimited commands, binary footer
+	frame := []byte{0x00, 0x01, 0x00, 0x2A, 0x00, 0x00, 0x00, 0x00}
+	frame = append(frame, "p1\r\nTLR:1\r\nq05\r\n"...)
+	frame = append(frame, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Event{
+		PowerEvent{State: ptz.PowerOn},
+		TallyEvent{Color: "red", On: true},
+		PresetCompleteEvent{Preset: 5},
+	}
+	for i, w := range want {
+		select {
+		case ev := <-c.Events():
+			if ev != w {
+				t.Errorf("event %d: %#v, want %#v", i, ev, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %d events, want %d", i, len(want))
+		}
+	}
+	select {
+	case ev := <-c.Events():
+		t.Errorf("unexpected event %#v from the footer", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if state := c.PowerState(); state != ptz.PowerOn {
+		t.Errorf("power state %q, want %q", state, ptz.PowerOn)
+	}
+}
@@ -2,115 +2,156 @@ package panasonic
 
 import (
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
+
+	"ptz-remote/internal/httpauth"
+	"ptz-remote/internal/ptz"
 )
 
 const minInterval = 50 * time.Millisecond // ~20 commands/sec max
 
-// throttle coalesces rapid updates, sending immediately when possible
-// and scheduling a trailing edge send for updates during cooldown
-type throttle struct {
-	mu           sync.Mutex
-	lastSendTime time.Time
-	timerRunning bool
-	stopCh       <-chan struct{}
-	flush        func()
-}
-
-func (t *throttle) trigger() {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	now := time.Now()
-	if now.Sub(t.lastSendTime) >= minInterval {
-		t.flush()
-		t.lastSendTime = now
-	} else if !t.timerRunning {
-		t.timerRunning = true
-		remaining := minInterval - now.Sub(t.lastSendTime)
-		go func() {
-			select {
-			case <-time.After(remaining):
-				t.mu.Lock()
-				t.flush()
-				t.lastSendTime = time.Now()
-				t.timerRunning = false
-				t.mu.Unlock()
-			case <-t.stopCh:
-			}
-		}()
-	}
-}
+// powerPollInterval is how often the power state is queried
+const powerPollInterval = 10 * time.Second
 
 // Controller manages HTTP CGI communication with a Panasonic PTZ camera
 type Controller struct {
-	baseURL string
+	rootURL string // scheme://address
+	baseURL string // rootURL + /cgi-bin/aw_ptz
 	client  *http.Client
 	stopCh  chan struct{}
 
+	powerMu sync.Mutex
+	power   string // Last known power state (ptz.Power* constant)
+
+	// Update notifications (nil listener when disabled)
+	eventLn   net.Listener
+	eventPort int
+	events    chan Event
+	eventWG   sync.WaitGroup
+
 	// Pan/tilt state
 	panTilt struct {
-		throttle
+		ptz.Throttle
 		pending, sent struct{ pan, tilt float64 }
 	}
 
 	// Zoom state
 	zoom struct {
-		throttle
+		ptz.Throttle
 		pending, sent float64
 	}
 }
 
 // Config for Panasonic controller
 type Config struct {
-	Address string // Camera IP address or hostname (e.g., "192.168.1.100")
+	Address  string // Camera IP address or hostname (e.g., "192.168.1.100")
+	Username string // Optional; enables Basic/Digest authentication
+	Password string
+
+	HTTPS              bool   // Use https:// instead of http://
+	CACertFile         string // Optional PEM file used to verify the camera certificate
+	InsecureSkipVerify bool   // Skip certificate verification (self-signed cameras)
+
+	EventPort int // TCP port for update notifications (0 = disabled)
 }
 
 // NewController creates a new Panasonic controller
 func NewController(cfg Config) (*Controller, error) {
-	if cfg.Address == "" {
-		return nil, fmt.Errorf("camera address is required")
-	}
-
-	c := &Controller{
-		baseURL: fmt.Sprintf("http://%s/cgi-bin/aw_ptz", cfg.Address),
-		client: &http.Client{
-			Timeout: 500 * time.Millisecond,
-			Transport: &http.Transport{
-				MaxIdleConns:        4,
-				MaxIdleConnsPerHost: 4,
-				IdleConnTimeout:     30 * time.Second,
-			},
-		},
-		stopCh: make(chan struct{}),
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	// Wire up throttle flush callbacks
-	c.panTilt.stopCh = c.stopCh
-	c.panTilt.flush = func() {
+	c.panTilt.Interval = minInterval
+	c.panTilt.StopCh = c.stopCh
+	c.panTilt.Flush = func() {
 		if c.panTilt.pending != c.panTilt.sent {
 			c.sendPanTiltCmd(c.panTilt.pending.pan, c.panTilt.pending.tilt)
 			c.panTilt.sent = c.panTilt.pending
 		}
 	}
 
-	c.zoom.stopCh = c.stopCh
-	c.zoom.flush = func() {
+	c.zoom.Interval = minInterval
+	c.zoom.StopCh = c.stopCh
+	c.zoom.Flush = func() {
 		if c.zoom.pending != c.zoom.sent {
 			c.sendZoomCmd(c.zoom.pending)
 			c.zoom.sent = c.zoom.pending
 		}
 	}
 
+	if cfg.EventPort != 0 {
+		if err := c.startEvents(cfg.EventPort); err != nil {
+			return nil, err
+		}
+	}
+
+	go c.pollPower()
+
+	return c, nil
+}
+
+// newClient creates a controller that can query the camera but does not
+// send moves, listen for update notifications or poll the power state
+func newClient(cfg Config) (*Controller, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("camera address is required")
+	}
+
+	scheme := "http"
+	transport := &http.Transport{
+		MaxIdleConns:        4,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     30 * time.Second,
+	}
+	if cfg.HTTPS {
+		scheme = "https"
+		tc, err := httpauth.TLSConfig(cfg.CACertFile, cfg.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tc
+	}
+
+	var rt http.RoundTripper = transport
+	if cfg.Username != "" {
+		rt = &httpauth.Transport{
+			Base:     transport,
+			Username: cfg.Username,
+			Password: cfg.Password,
+		}
+	}
+
+	rootURL := fmt.Sprintf("%s://%s", scheme, cfg.Address)
+	c := &Controller{
+		rootURL: rootURL,
+		baseURL: rootURL + "/cgi-bin/aw_ptz",
+		client: &http.Client{
+			Timeout:   500 * time.Millisecond,
+			Transport: rt,
+		},
+		stopCh: make(chan struct{}),
+		power:  ptz.PowerUnknown,
+	}
+
 	return c, nil
 }
 
 // Close closes the controller
 func (c *Controller) Close() error {
 	close(c.stopCh)
+	if c.eventLn != nil {
+		c.eventLn.Close()
+		c.eventWG.Wait()
+		close(c.events)
+	}
 	return nil
 }
 
@@ -118,14 +159,14 @@ func (c *Controller) Close() error {
 // pan: -1.0 (left) to 1.0 (right)
 // tilt: -1.0 (down) to 1.0 (up)
 func (c *Controller) PanTilt(pan, tilt float64) error {
-	c.panTilt.mu.Lock()
+	c.panTilt.Lock()
 	c.panTilt.pending.pan = pan
 	c.panTilt.pending.tilt = tilt
 	changed := c.panTilt.pending != c.panTilt.sent
-	c.panTilt.mu.Unlock()
+	c.panTilt.Unlock()
 
 	if changed {
-		c.panTilt.trigger()
+		c.panTilt.Trigger()
 	}
 	return nil
 }
@@ -133,28 +174,28 @@ func (c *Controller) PanTilt(pan, tilt float64) error {
 // Zoom sends a zoom command
 // zoom: -1.0 (wide/out) to 1.0 (tele/in)
 func (c *Controller) Zoom(zoom float64) error {
-	c.zoom.mu.Lock()
+	c.zoom.Lock()
 	c.zoom.pending = zoom
 	changed := c.zoom.pending != c.zoom.sent
-	c.zoom.mu.Unlock()
+	c.zoom.Unlock()
 
 	if changed {
-		c.zoom.trigger()
+		c.zoom.Trigger()
 	}
 	return nil
 }
 
 // Stop stops all PTZ movement immediately
 func (c *Controller) Stop() error {
-	c.panTilt.mu.Lock()
+	c.panTilt.Lock()
 	c.panTilt.pending = struct{ pan, tilt float64 }{}
 	c.panTilt.sent = c.panTilt.pending
-	c.panTilt.mu.Unlock()
+	c.panTilt.Unlock()
 
-	c.zoom.mu.Lock()
+	c.zoom.Lock()
 	c.zoom.pending = 0
 	c.zoom.sent = 0
-	c.zoom.mu.Unlock()
+	c.zoom.Unlock()
 
 	c.sendPanTiltCmd(0, 0)
 	c.sendZoomCmd(0)
@@ -177,6 +218,119 @@ func (c *Controller) SavePreset(preset int) error {
 	return c.sendCommand(fmt.Sprintf("#M%02d", preset))
 }
 
+// Capabilities describes what Panasonic CGI control supports
+func (c *Controller) Capabilities() ptz.Capabilities {
+	return ptz.Capabilities{
+		PanTilt:        true,
+		Zoom:           true,
+		Presets:        true,
+		PresetMin:      0,
+		PresetMax:      99,
+		Power:          true,
+		Tally:          true,
+		Events:         c.eventLn != nil,
+		PanSpeedSteps:  49,
+		TiltSpeedSteps: 49,
+		ZoomSpeedSteps: 49,
+	}
+}
+
+// SetPower turns the camera on or puts it in standby
+func (c *Controller) SetPower(on bool) error {
+	cmd, state := "#O0", ptz.PowerStandby
+	if on {
+		cmd, state = "#O1", ptz.PowerOn
+	}
+	if _, err := c.query(c.baseURL, cmd); err != nil {
+		return err
+	}
+	c.setPowerState(state)
+	return nil
+}
+
+// PowerState returns the last known power state
+func (c *Controller) PowerState() string {
+	c.powerMu.Lock()
+	defer c.powerMu.Unlock()
+	return c.power
+}
+
+func (c *Controller) setPowerState(state string) {
+	c.powerMu.Lock()
+	c.power = state
+	c.powerMu.Unlock()
+}
+
+// pollPower refreshes the power state now and every powerPollInterval, so
+// changes made elsewhere show up without update notifications
+func (c *Controller) pollPower() {
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+	for {
+		c.refreshPower()
+		select {
+		case <-ticker.C:
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// refreshPower queries the current power state (#O -> p0/p1/p3)
+func (c *Controller) refreshPower() {
+	resp, err := c.query(c.baseURL, "#O")
+	if err != nil {
+		return
+	}
+	if ev, ok := parseEvent(resp).(PowerEvent); ok {
+		c.setPowerState(ev.State)
+	}
+}
+
+// Model queries the camera model name (QID -> OID:AW-UE150)
+func (c *Controller) Model() (string, error) {
+	resp, err := c.query(c.rootURL+"/cgi-bin/aw_cam", "QID")
+	if err != nil {
+		return "", err
+	}
+	model, ok := strings.CutPrefix(resp, "OID:")
+	if !ok {
+		return "", fmt.Errorf("unexpected QID response: %q", resp)
+	}
+	return model, nil
+}
+
+// Identify queries the camera model without starting a controller
+func Identify(cfg Config) (string, error) {
+	c, err := newClient(cfg)
+	if err != nil {
+		return "", err
+	}
+	defer c.client.CloseIdleConnections()
+	return c.Model()
+}
+
+// SetTally turns the red or green tally lamp on or off
+func (c *Controller) SetTally(color string, on bool) error {
+	var cmd string
+	switch color {
+	case ptz.TallyRed:
+		cmd = "TLR:"
+	case ptz.TallyGreen:
+		cmd = "TLG:"
+	default:
+		return fmt.Errorf("unsupported tally color: %s", color)
+	}
+	if on {
+		cmd += "1"
+	} else {
+		cmd += "0"
+	}
+	// Camera (non-#) commands are sent to aw_cam rather than aw_ptz
+	_, err := c.query(c.rootURL+"/cgi-bin/aw_cam", cmd)
+	return err
+}
+
 // sendPanTiltCmd sends the Panasonic pan/tilt command
 // Panasonic format: #PTS<pan><tilt> where values are 01-99 (50 = stop)
 func (c *Controller) sendPanTiltCmd(pan, tilt float64) {
@@ -210,6 +364,25 @@ func (c *Controller) sendCommand(cmd string) error {
 	return nil
 }
 
+// query sends a command and waits for the camera's response body
+func (c *Controller) query(endpoint, cmd string) (string, error) {
+	reqURL := fmt.Sprintf("%s?cmd=%s&res=1", endpoint, url.QueryEscape(cmd))
+	resp, err := c.client.Get(reqURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("camera returned %s", resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
 // speedToValue converts a -1.0 to 1.0 value to Panasonic's 01-99 range
 func speedToValue(v float64) int {
 	// Clamp to -1.0 to 1.0
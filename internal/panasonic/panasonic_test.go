@@ -0,0 +1,41 @@
+package panasonic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdentify(t *testing.T) {
+	var mu sync.Mutex
+	var cmds []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cmd := r.URL.Query().Get("cmd")
+		mu.Lock()
+		cmds = append(cmds, r.URL.Path+" "+cmd)
+		mu.Unlock()
+		if r.URL.Path == "/cgi-bin/aw_cam" && cmd == "QID" {
+			w.Write([]byte("OID:AW-UE150"))
+		}
+	}))
+	defer srv.Close()
+
+	model, err := Identify(Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model != "AW-UE150" {
+		t.Errorf("model %q, want AW-UE150", model)
+	}
+
+	// Identifying a camera doesn't start a controller polling it
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(cmds) != 1 {
+		t.Errorf("requests %q, want only the model query", cmds)
+	}
+}
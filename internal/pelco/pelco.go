@@ -0,0 +1,324 @@
+package pelco
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/ptz"
+	"ptz-remote/internal/serial"
+)
+
+const minInterval = 50 * time.Millisecond // ~20 commands/sec max, a frame takes ~30ms at 2400 baud
+
+// Command bits shared by Pelco-D (command 2) and Pelco-P (data 2)
+const (
+	bitRight    = 0x02
+	bitLeft     = 0x04
+	bitUp       = 0x08
+	bitDown     = 0x10
+	bitZoomTele = 0x20
+	bitZoomWide = 0x40
+)
+
+// Extended commands (command 2 / data 2 with the low bit set)
+const (
+	cmdSetPreset   = 0x03
+	cmdClearPreset = 0x05
+	cmdGotoPreset  = 0x07
+	cmdSetAux      = 0x09
+	cmdClearAux    = 0x0B
+	cmdZoomSpeed   = 0x25 // Pelco-D only
+)
+
+// conn is the transport to the head: a TCP serial server or a serial port
+type conn interface {
+	io.WriteCloser
+	SetWriteDeadline(t time.Time) error
+}
+
+// Controller manages Pelco-D or Pelco-P communication with a pan/tilt head
+type Controller struct {
+	conn    conn
+	mu      sync.Mutex
+	variant string
+	addr    int // Receiver address (1-255)
+	stopCh  chan struct{}
+
+	zoomSpeed int // Last Pelco-D zoom speed sent (0-3), -1 if unknown
+
+	// Pan/tilt/zoom state; every frame carries all axes
+	move struct {
+		ptz.Throttle
+		pending, sent struct{ pan, tilt, zoom float64 }
+	}
+}
+
+// Config for Pelco controller
+type Config struct {
+	Address       string // Serial: "/dev/ttyUSB0", TCP: "192.168.1.50:4001"
+	Protocol      string // "serial" or "tcp"
+	Variant       string // "d" (Pelco-D, default) or "p" (Pelco-P)
+	BaudRate      int    // Serial baud rate, default 2400 (Pelco-D) or 4800 (Pelco-P)
+	CameraAddress int    // Receiver address (1-255), default 1
+}
+
+// NewController creates a new Pelco controller
+func NewController(cfg Config) (*Controller, error) {
+	variant := cfg.Variant
+	if variant == "" {
+		variant = "d"
+	}
+	if variant != "d" && variant != "p" {
+		return nil, fmt.Errorf("unsupported Pelco variant: %s", variant)
+	}
+
+	addr := cfg.CameraAddress
+	if addr == 0 {
+		addr = 1
+	}
+	if addr < 1 || addr > 255 {
+		return nil, fmt.Errorf("camera address must be 1-255")
+	}
+
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = "serial"
+	}
+
+	var conn conn
+	var err error
+	switch protocol {
+	case "serial":
+		baud := cfg.BaudRate
+		if baud == 0 {
+			baud = 2400
+			if variant == "p" {
+				baud = 4800
+			}
+		}
+		conn, err = serial.Open(cfg.Address, baud)
+	case "tcp":
+		conn, err = net.DialTimeout("tcp", cfg.Address, 5*time.Second)
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect via %s: %w", protocol, err)
+	}
+
+	c := &Controller{
+		conn:      conn,
+		variant:   variant,
+		addr:      addr,
+		stopCh:    make(chan struct{}),
+		zoomSpeed: -1,
+	}
+
+	// Wire up throttle flush callback
+	c.move.Interval = minInterval
+	c.move.StopCh = c.stopCh
+	c.move.Flush = func() {
+		if c.move.pending != c.move.sent {
+			p := c.move.pending
+			c.sendMoveCmd(p.pan, p.tilt, p.zoom)
+			c.move.sent = c.move.pending
+		}
+	}
+
+	return c, nil
+}
+
+// Close closes the connection
+func (c *Controller) Close() error {
+	close(c.stopCh)
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// PanTilt sends a pan/tilt command. pan: -1.0 (left) to 1.0 (right), tilt: -1.0 (down) to 1.0 (up)
+func (c *Controller) PanTilt(pan, tilt float64) error {
+	c.move.Lock()
+	c.move.pending.pan = pan
+	c.move.pending.tilt = tilt
+	changed := c.move.pending != c.move.sent
+	c.move.Unlock()
+
+	if changed {
+		c.move.Trigger()
+	}
+	return nil
+}
+
+// Zoom sends a zoom command. zoom: -1.0 (wide/out) to 1.0 (tele/in)
+func (c *Controller) Zoom(zoom float64) error {
+	c.move.Lock()
+	c.move.pending.zoom = zoom
+	changed := c.move.pending != c.move.sent
+	c.move.Unlock()
+
+	if changed {
+		c.move.Trigger()
+	}
+	return nil
+}
+
+// Stop stops all PTZ movement immediately
+func (c *Controller) Stop() error {
+	c.move.Lock()
+	c.move.pending = struct{ pan, tilt, zoom float64 }{}
+	c.move.sent = c.move.pending
+	c.move.Unlock()
+
+	return c.sendFrame(0, 0, 0, 0)
+}
+
+// RecallPreset recalls a preset position (1-255)
+func (c *Controller) RecallPreset(preset int) error {
+	if preset < 1 || preset > 255 {
+		return fmt.Errorf("preset must be 1-255 for Pelco")
+	}
+	return c.sendFrame(0, cmdGotoPreset, 0, byte(preset))
+}
+
+// SavePreset saves current position to a preset (1-255)
+func (c *Controller) SavePreset(preset int) error {
+	if preset < 1 || preset > 255 {
+		return fmt.Errorf("preset must be 1-255 for Pelco")
+	}
+	return c.sendFrame(0, cmdSetPreset, 0, byte(preset))
+}
+
+// Capabilities describes what Pelco control supports
+func (c *Controller) Capabilities() ptz.Capabilities {
+	zoomSteps := 1 // Pelco-P has no zoom speed command
+	if c.variant == "d" {
+		zoomSteps = 4
+	}
+	return ptz.Capabilities{
+		PanTilt:        true,
+		Zoom:           true,
+		Presets:        true,
+		PresetMin:      1,
+		PresetMax:      255,
+		PanSpeedSteps:  64, // 0x01-0x3F and turbo
+		TiltSpeedSteps: 63,
+		ZoomSpeedSteps: zoomSteps,
+	}
+}
+
+// ClearPreset deletes a preset position (1-255)
+func (c *Controller) ClearPreset(preset int) error {
+	if preset < 1 || preset > 255 {
+		return fmt.Errorf("preset must be 1-255 for Pelco")
+	}
+	return c.sendFrame(0, cmdClearPreset, 0, byte(preset))
+}
+
+// SetAux switches an auxiliary output (1-8, e.g. wiper or lights) on or off
+func (c *Controller) SetAux(aux int, on bool) error {
+	if aux < 1 || aux > 8 {
+		return fmt.Errorf("aux must be 1-8")
+	}
+	cmd := byte(cmdClearAux)
+	if on {
+		cmd = cmdSetAux
+	}
+	return c.sendFrame(0, cmd, 0, byte(aux))
+}
+
+// sendMoveCmd sends a combined pan/tilt/zoom drive command
+func (c *Controller) sendMoveCmd(pan, tilt, zoom float64) {
+	var bits byte
+	var panSpeed, tiltSpeed byte
+
+	if pan < -0.05 {
+		bits |= bitLeft
+		panSpeed = speedByte(pan, true)
+	} else if pan > 0.05 {
+		bits |= bitRight
+		panSpeed = speedByte(pan, true)
+	}
+
+	if tilt > 0.05 {
+		bits |= bitUp
+		tiltSpeed = speedByte(tilt, false)
+	} else if tilt < -0.05 {
+		bits |= bitDown
+		tiltSpeed = speedByte(tilt, false)
+	}
+
+	if zoom > 0.05 {
+		bits |= bitZoomTele
+	} else if zoom < -0.05 {
+		bits |= bitZoomWide
+	}
+
+	// Pelco-D carries zoom speed in a separate command (0-3)
+	if c.variant == "d" && bits&(bitZoomTele|bitZoomWide) != 0 {
+		speed := clamp(int(abs(zoom)*4), 0, 3)
+		if speed != c.zoomSpeed {
+			c.sendFrame(0, cmdZoomSpeed, 0, byte(speed))
+			c.zoomSpeed = speed
+		}
+	}
+
+	c.sendFrame(0, bits, panSpeed, tiltSpeed)
+}
+
+// sendFrame builds and writes a Pelco-D or Pelco-P frame.
+// cmd1/cmd2 are Pelco-D command 1/2 (Pelco-P data 1/2); data1/data2 are the
+// pan/tilt speeds or the extended command argument.
+func (c *Controller) sendFrame(cmd1, cmd2, data1, data2 byte) error {
+	var frame []byte
+	if c.variant == "d" {
+		// FF addr cmd1 cmd2 data1 data2 checksum (sum of bytes 2-6 mod 256)
+		frame = []byte{0xFF, byte(c.addr), cmd1, cmd2, data1, data2, 0}
+		frame[6] = frame[1] + frame[2] + frame[3] + frame[4] + frame[5]
+	} else {
+		// A0 addr data1 data2 data3 data4 AF checksum (XOR of bytes 1-7);
+		// Pelco-P receivers are addressed from 0
+		frame = []byte{0xA0, byte(c.addr - 1), cmd1, cmd2, data1, data2, 0xAF, 0}
+		for _, b := range frame[:7] {
+			frame[7] ^= b
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// speedByte maps a -1.0 to 1.0 velocity to a Pelco speed (0x01-0x3F).
+// Turbo (0x40) at full deflection is only defined for pan.
+func speedByte(v float64, turbo bool) byte {
+	v = abs(v)
+	if turbo && v >= 0.99 {
+		return 0x40
+	}
+	return byte(clamp(int(v*0x3F), 1, 0x3F))
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,117 @@
+package pelco
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestController connects a controller to a TCP stand-in for the
+// serial server and returns the head's end of the connection
+func newTestController(t *testing.T, variant string) (*Controller, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	c, err := NewController(Config{Address: ln.Addr().String(), Protocol: "tcp", Variant: variant, CameraAddress: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	head, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { head.Close() })
+	return c, head
+}
+
+func readFrame(t *testing.T, head net.Conn, n int) []byte {
+	t.Helper()
+	head.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, n)
+	for got := 0; got < n; {
+		k, err := head.Read(buf[got:])
+		if err != nil {
+			t.Fatalf("read after %d of %d bytes: %v", got, n, err)
+		}
+		got += k
+	}
+	return buf
+}
+
+func TestMoveFrames(t *testing.T) {
+	tests := []struct {
+		name      string
+		variant   string
+		pan, tilt float64
+		want      []byte
+	}{
+		// Turbo is for pan only; tilt tops out at 0x3F
+		{"D full right up", "d", 1, 1, []byte{0xFF, 0x01, 0x00, 0x0A, 0x40, 0x3F, 0x8A}},
+		{"D full left down", "d", -1, -1, []byte{0xFF, 0x01, 0x00, 0x14, 0x40, 0x3F, 0x94}},
+		{"D half", "d", 0.5, -0.5, []byte{0xFF, 0x01, 0x00, 0x12, 0x1F, 0x1F, 0x51}},
+		{"D tilt only", "d", 0, 1, []byte{0xFF, 0x01, 0x00, 0x08, 0x00, 0x3F, 0x48}},
+		{"P full right up", "p", 1, 1, []byte{0xA0, 0x00, 0x00, 0x0A, 0x40, 0x3F, 0xAF, 0x7A}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, head := newTestController(t, tt.variant)
+			c.PanTilt(tt.pan, tt.tilt)
+			if got := readFrame(t, head, len(tt.want)); !bytes.Equal(got, tt.want) {
+				t.Errorf("frame % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendedFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant string
+		send    func(c *Controller) error
+		want    []byte
+	}{
+		{"D set preset", "d", func(c *Controller) error { return c.SavePreset(5) }, []byte{0xFF, 0x01, 0x00, 0x03, 0x00, 0x05, 0x09}},
+		{"D clear preset", "d", func(c *Controller) error { return c.ClearPreset(5) }, []byte{0xFF, 0x01, 0x00, 0x05, 0x00, 0x05, 0x0B}},
+		{"D goto preset", "d", func(c *Controller) error { return c.RecallPreset(5) }, []byte{0xFF, 0x01, 0x00, 0x07, 0x00, 0x05, 0x0D}},
+		{"D aux on", "d", func(c *Controller) error { return c.SetAux(2, true) }, []byte{0xFF, 0x01, 0x00, 0x09, 0x00, 0x02, 0x0C}},
+		{"D aux off", "d", func(c *Controller) error { return c.SetAux(2, false) }, []byte{0xFF, 0x01, 0x00, 0x0B, 0x00, 0x02, 0x0E}},
+		{"P set preset", "p", func(c *Controller) error { return c.SavePreset(5) }, []byte{0xA0, 0x00, 0x00, 0x03, 0x00, 0x05, 0xAF, 0x09}},
+		{"P clear preset", "p", func(c *Controller) error { return c.ClearPreset(5) }, []byte{0xA0, 0x00, 0x00, 0x05, 0x00, 0x05, 0xAF, 0x0F}},
+		{"P goto preset", "p", func(c *Controller) error { return c.RecallPreset(5) }, []byte{0xA0, 0x00, 0x00, 0x07, 0x00, 0x05, 0xAF, 0x0D}},
+		{"P aux on", "p", func(c *Controller) error { return c.SetAux(2, true) }, []byte{0xA0, 0x00, 0x00, 0x09, 0x00, 0x02, 0xAF, 0x04}},
+		{"P aux off", "p", func(c *Controller) error { return c.SetAux(2, false) }, []byte{0xA0, 0x00, 0x00, 0x0B, 0x00, 0x02, 0xAF, 0x06}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, head := newTestController(t, tt.variant)
+			if err := tt.send(c); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFrame(t, head, len(tt.want)); !bytes.Equal(got, tt.want) {
+				t.Errorf("frame % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendedRange(t *testing.T) {
+	c, _ := newTestController(t, "d")
+	for name, err := range map[string]error{
+		"goto preset 0":  c.RecallPreset(0),
+		"set preset 256": c.SavePreset(256),
+		"clear preset 0": c.ClearPreset(0),
+		"aux 0":          c.SetAux(0, true),
+		"aux 9":          c.SetAux(9, false),
+	} {
+		if err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package probe
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/onvif"
+	"ptz-remote/internal/panasonic"
+	"ptz-remote/internal/ptz"
+	"ptz-remote/internal/visca"
+)
+
+// Default ports for the probed protocols; variables so tests can point the
+// probe at stand-ins
+var (
+	viscaUDPPort = "52381"
+	viscaTCPPort = "5678"
+	httpPort     = "80" // Panasonic CGI and ONVIF
+)
+
+// Config for a probe
+type Config struct {
+	Host     string // Camera IP address or hostname; a port, if any, is ignored
+	Username string // Used for Panasonic and ONVIF
+	Password string
+	Timeout  time.Duration // Per-protocol timeout for VISCA, default 1s
+}
+
+// Result describes the protocol chosen for a camera
+type Result struct {
+	Protocol     string // "visca", "panasonic" or "onvif"
+	Transport    string // VISCA only: "udp" or "tcp"
+	Address      string // Address for the controller's Config
+	Manufacturer string
+	Model        string
+	Firmware     string
+	Detected     []string // Every protocol that answered, e.g. "visca-udp", "onvif"
+}
+
+// Probe tries each backend's identification request against host in
+// parallel and picks a controller protocol. Native protocols are preferred
+// over ONVIF since they support power and tally; ONVIF device information
+// fills in the manufacturer and model when the native reply lacks them.
+func Probe(cfg Config) (*Result, error) {
+	host := cfg.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		return nil, fmt.Errorf("camera host is required")
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+
+	viscaUDPAddr := net.JoinHostPort(host, viscaUDPPort)
+	viscaTCPAddr := net.JoinHostPort(host, viscaTCPPort)
+	httpAddr := host
+	if httpPort != "80" {
+		httpAddr = net.JoinHostPort(host, httpPort)
+	}
+
+	var (
+		wg                 sync.WaitGroup
+		viscaUDP, viscaTCP *visca.Version
+		panasonicModel     string
+		panasonicErr       error
+		onvifInfo          *onvif.DeviceInformation
+	)
+
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		if v, err := visca.Identify(viscaUDPAddr, "udp", timeout); err == nil {
+			viscaUDP = &v
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if v, err := visca.Identify(viscaTCPAddr, "tcp", timeout); err == nil {
+			viscaTCP = &v
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		panasonicModel, panasonicErr = panasonic.Identify(panasonic.Config{
+			Address:  httpAddr,
+			Username: cfg.Username,
+			Password: cfg.Password,
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		info, err := onvif.Identify(onvif.Config{
+			Address:  httpAddr,
+			Username: cfg.Username,
+			Password: cfg.Password,
+		})
+		if err == nil {
+			onvifInfo = &info
+		}
+	}()
+	wg.Wait()
+
+	r := &Result{}
+	if panasonicErr == nil {
+		r.Detected = append(r.Detected, "panasonic")
+	}
+	if viscaUDP != nil {
+		r.Detected = append(r.Detected, "visca-udp")
+	}
+	if viscaTCP != nil {
+		r.Detected = append(r.Detected, "visca-tcp")
+	}
+	if onvifInfo != nil {
+		r.Detected = append(r.Detected, "onvif")
+	}
+
+	switch {
+	case panasonicErr == nil:
+		r.Protocol = "panasonic"
+		r.Address = httpAddr
+		r.Manufacturer = "Panasonic"
+		r.Model = panasonicModel
+	case viscaUDP != nil:
+		r.Protocol, r.Transport, r.Address = "visca", "udp", viscaUDPAddr
+		r.Manufacturer, r.Model = viscaUDP.Vendor(), viscaUDP.Model()
+	case viscaTCP != nil:
+		r.Protocol, r.Transport, r.Address = "visca", "tcp", viscaTCPAddr
+		r.Manufacturer, r.Model = viscaTCP.Vendor(), viscaTCP.Model()
+	case onvifInfo != nil && onvifInfo.HasPTZ:
+		r.Protocol = "onvif"
+		r.Address = httpAddr
+	default:
+		return nil, fmt.Errorf("no supported control protocol found on %s", host)
+	}
+
+	if onvifInfo != nil {
+		if r.Protocol == "visca" || r.Protocol == "onvif" {
+			if onvifInfo.Manufacturer != "" {
+				r.Manufacturer = onvifInfo.Manufacturer
+			}
+			if onvifInfo.Model != "" {
+				r.Model = onvifInfo.Model
+			}
+		}
+		r.Firmware = onvifInfo.FirmwareVersion
+	}
+
+	return r, nil
+}
+
+// Features lists the names of a controller's capabilities, as reported
+// with the detected camera in status
+func Features(caps ptz.Capabilities) []string {
+	features := []string{}
+	for _, f := range []struct {
+		name string
+		ok   bool
+	}{
+		{"pan_tilt", caps.PanTilt},
+		{"zoom", caps.Zoom},
+		{"focus", caps.Focus},
+		{"absolute_move", caps.AbsoluteMove},
+		{"presets", caps.Presets},
+		{"power", caps.Power},
+		{"tally", caps.Tally},
+		{"events", caps.Events},
+	} {
+		if f.ok {
+			features = append(features, f.name)
+		}
+	}
+	return features
+}
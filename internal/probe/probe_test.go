@@ -0,0 +1,214 @@
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionReply is a Sony camera's answer to the version inquiry
+var versionReply = []byte{0x90, 0x50, 0x00, 0x01, 0x05, 0x1C, 0x01, 0x00, 0x02, 0xFF}
+
+// camera selects which protocols the stand-ins answer
+type camera struct {
+	panasonic bool
+	onvif     bool
+	onvifPTZ  bool
+	viscaUDP  bool
+	viscaTCP  bool
+}
+
+func (cam camera) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case cam.panasonic && r.URL.Path == "/cgi-bin/aw_cam" && r.URL.Query().Get("cmd") == "QID":
+		fmt.Fprint(w, "OID:AW-UE150")
+	case cam.onvif && r.URL.Path == "/onvif/device_service":
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "GetDeviceInformation"):
+			fmt.Fprint(w, `<Envelope><Body><GetDeviceInformationResponse><Manufacturer> BirdDog </Manufacturer>
+<Model>P200</Model><FirmwareVersion>4.5</FirmwareVersion></GetDeviceInformationResponse></Body></Envelope>`)
+		case strings.Contains(string(body), "GetCapabilities") && cam.onvifPTZ:
+			fmt.Fprint(w, `<Envelope><Body><GetCapabilitiesResponse><Capabilities>
+<PTZ><XAddr>http://camera/onvif/ptz</XAddr></PTZ></Capabilities></GetCapabilitiesResponse></Body></Envelope>`)
+		default:
+			fmt.Fprint(w, `<Envelope><Body/></Envelope>`)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// start runs the stand-ins on loopback ports and points the probe at them
+func (cam camera) start(t *testing.T) {
+	t.Helper()
+	srv := httptest.NewServer(cam)
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	setPort(t, &httpPort, port)
+
+	// A bound socket that never answers
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	_, port, _ = net.SplitHostPort(pc.LocalAddr().String())
+	setPort(t, &viscaUDPPort, port)
+	if cam.viscaUDP {
+		go answerUDP(pc)
+	}
+
+	// A closed port refuses the connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ = net.SplitHostPort(ln.Addr().String())
+	setPort(t, &viscaTCPPort, port)
+	if cam.viscaTCP {
+		t.Cleanup(func() { ln.Close() })
+		go answerTCP(ln)
+	} else {
+		ln.Close()
+	}
+}
+
+func setPort(t *testing.T, v *string, port string) {
+	old := *v
+	*v = port
+	t.Cleanup(func() { *v = old })
+}
+
+// answerUDP replies to VISCA-over-IP inquiries with the version
+func answerUDP(pc net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 8 || binary.BigEndian.Uint16(buf[0:2]) != 0x0110 {
+			continue // The RESET
+		}
+		reply := make([]byte, 8, 8+len(versionReply))
+		binary.BigEndian.PutUint16(reply[0:2], 0x0111)
+		binary.BigEndian.PutUint16(reply[2:4], uint16(len(versionReply)))
+		copy(reply[4:8], buf[4:8])
+		pc.WriteTo(append(reply, versionReply...), addr)
+	}
+}
+
+// answerTCP replies to a raw VISCA inquiry with the version
+func answerTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			buf := make([]byte, 16)
+			if _, err := conn.Read(buf); err == nil {
+				conn.Write(versionReply)
+			}
+		}()
+	}
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		camera camera
+		want   Result // Address is filled in from the stand-in ports
+	}{
+		{
+			name:   "Panasonic",
+			camera: camera{panasonic: true},
+			want:   Result{Protocol: "panasonic", Manufacturer: "Panasonic", Model: "AW-UE150", Detected: []string{"panasonic"}},
+		},
+		{
+			name:   "ONVIF",
+			camera: camera{onvif: true, onvifPTZ: true},
+			want:   Result{Protocol: "onvif", Manufacturer: "BirdDog", Model: "P200", Firmware: "4.5", Detected: []string{"onvif"}},
+		},
+		{
+			name:   "VISCA over IP",
+			camera: camera{viscaUDP: true},
+			want:   Result{Protocol: "visca", Transport: "udp", Manufacturer: "Sony", Model: "051C", Detected: []string{"visca-udp"}},
+		},
+		{
+			name:   "VISCA over TCP",
+			camera: camera{viscaTCP: true},
+			want:   Result{Protocol: "visca", Transport: "tcp", Manufacturer: "Sony", Model: "051C", Detected: []string{"visca-tcp"}},
+		},
+		{
+			name:   "VISCA named by ONVIF",
+			camera: camera{viscaUDP: true, onvif: true, onvifPTZ: true},
+			want: Result{Protocol: "visca", Transport: "udp", Manufacturer: "BirdDog", Model: "P200", Firmware: "4.5",
+				Detected: []string{"visca-udp", "onvif"}},
+		},
+		{
+			name:   "native protocol preferred",
+			camera: camera{panasonic: true, viscaTCP: true, onvif: true, onvifPTZ: true},
+			want: Result{Protocol: "panasonic", Manufacturer: "Panasonic", Model: "AW-UE150", Firmware: "4.5",
+				Detected: []string{"panasonic", "visca-tcp", "onvif"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.camera.start(t)
+			r, err := Probe(Config{Host: "127.0.0.1:9999", Timeout: 300 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := tt.want
+			switch want.Protocol {
+			case "visca":
+				port := viscaUDPPort
+				if want.Transport == "tcp" {
+					port = viscaTCPPort
+				}
+				want.Address = net.JoinHostPort("127.0.0.1", port)
+			default:
+				want.Address = net.JoinHostPort("127.0.0.1", httpPort)
+			}
+			if fmt.Sprint(*r) != fmt.Sprint(want) {
+				t.Errorf("got %+v\nwant %+v", *r, want)
+			}
+		})
+	}
+}
+
+func TestProbeNothingFound(t *testing.T) {
+	tests := []struct {
+		name   string
+		camera camera
+	}{
+		{"no answer", camera{}},
+		{"ONVIF without PTZ", camera{onvif: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.camera.start(t)
+			r, err := Probe(Config{Host: "127.0.0.1", Timeout: 300 * time.Millisecond})
+			if err == nil {
+				t.Fatalf("found %+v", *r)
+			}
+			if !strings.Contains(err.Error(), "no supported control protocol") {
+				t.Errorf("error %q", err)
+			}
+		})
+	}
+
+	if _, err := Probe(Config{}); err == nil {
+		t.Error("probed without a host")
+	}
+}
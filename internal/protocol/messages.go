@@ -10,9 +10,17 @@ const (
 	TypeOffer        = "offer"
 	TypeAnswer       = "answer"
 	TypeICECandidate = "ice_candidate"
+	TypeRenegotiate  = "renegotiate"
+	TypeICERestart   = "ice_restart"
 	TypePTZCommand   = "ptz_command"
 	TypePTZStop      = "ptz_stop"
 	TypePTZPreset    = "ptz_preset"
+	TypePower        = "power"
+	TypeTally        = "tally"
+	TypeCameraEvent  = "camera_event"
+	TypeSelectStream = "select_stream"
+	TypeStream       = "stream"
+	TypeStats        = "stats"
 	TypeError        = "error"
 )
 
@@ -21,7 +29,9 @@ const (
 	ErrCameraDisconnected = "CAMERA_DISCONNECTED"
 	ErrRTSP               = "RTSP_ERROR"
 	ErrVISCA              = "VISCA_ERROR"
+	ErrCameraCommand      = "CAMERA_ERROR" // A camera command failed, with any controller
 	ErrInvalidMessage     = "INVALID_MESSAGE"
+	ErrUnsupported        = "UNSUPPORTED"
 )
 
 // Message is the base envelope for all WebSocket messages
@@ -43,10 +53,104 @@ type PongPayload struct {
 
 // StatusPayload for status messages
 type StatusPayload struct {
-	CameraConnected bool   `json:"camera_connected"`
-	RTSPURL         string `json:"rtsp_url,omitempty"`
-	ControlProtocol string `json:"control_protocol"`
-	VideoProtocol   string `json:"video_protocol"`
+	CameraConnected bool          `json:"camera_connected"`
+	RTSPURL         string        `json:"rtsp_url,omitempty"`
+	ControlProtocol string        `json:"control_protocol"`
+	VideoProtocol   string        `json:"video_protocol"`
+	VideoCodec      string        `json:"video_codec,omitempty"`
+	Power           string        `json:"power,omitempty"`
+	Camera          *CameraInfo   `json:"camera,omitempty"`
+	Capabilities    *Capabilities `json:"capabilities,omitempty"`
+	Streams         []StreamInfo  `json:"streams,omitempty"`
+	VideoState      string        `json:"video_state,omitempty"` // On-demand video only
+	ICEServers      []ICEServer   `json:"ice_servers,omitempty"`
+}
+
+// ICEServer is a STUN or TURN server for the client's peer connection, in
+// the form of RTCIceServer
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Video states of an on-demand video source
+const (
+	VideoStateIdle      = "idle"      // Not connected, no viewers
+	VideoStateStarting  = "starting"  // Connecting for a viewer
+	VideoStateStreaming = "streaming" // Connected
+)
+
+// StreamInfo describes one of the camera's video streams, best quality first
+type StreamInfo struct {
+	Name    string `json:"name"`
+	Bitrate int    `json:"bitrate"`           // Measured, bits per second; 0 until known
+	Skipped int64  `json:"skipped,omitempty"` // Packets skipped by clients that fell behind
+}
+
+// SelectStreamPayload for select_stream requests
+type SelectStreamPayload struct {
+	Stream string `json:"stream"` // A stream name, or "auto" for bandwidth-based selection
+}
+
+// StreamPayload for stream messages, sent when a client's stream changes
+type StreamPayload struct {
+	Stream           string `json:"stream"`
+	Auto             bool   `json:"auto"`
+	EstimatedBitrate int    `json:"estimated_bitrate,omitempty"` // Bits per second
+}
+
+// StatsPayload for stats messages, sent to each client with video every few
+// seconds. RTT, jitter and loss come from the client's RTCP receiver reports
+// and are 0 until the first one.
+type StatsPayload struct {
+	ServerDelayMs    float64      `json:"server_delay_ms"`     // Source receive to WebRTC write, average
+	ServerDelayMaxMs float64      `json:"server_delay_max_ms"` // Maximum since the previous stats
+	RTTMs            float64      `json:"rtt_ms"`
+	JitterMs         float64      `json:"jitter_ms"`
+	PacketsSent      uint64       `json:"packets_sent"`
+	PacketsLost      int64        `json:"packets_lost"`
+	FractionLost     float64      `json:"fraction_lost"` // Since the previous receiver report, 0-1
+	ConnectionState  string       `json:"connection_state"`
+	ICEState         string       `json:"ice_state"`
+	LocalCandidate   string       `json:"local_candidate,omitempty"` // Selected pair, e.g. "udp host"
+	RemoteCandidate  string       `json:"remote_candidate,omitempty"`
+	BytesSent        uint64       `json:"bytes_sent"`
+	Events           []StateEvent `json:"events,omitempty"`   // State changes since the previous stats
+	Warnings         []string     `json:"warnings,omitempty"` // Loss or RTT over the thresholds
+}
+
+// StateEvent is a WebRTC connection or ICE state change
+type StateEvent struct {
+	Time  int64  `json:"time"` // Unix milliseconds
+	Kind  string `json:"kind"` // "connection" or "ice"
+	State string `json:"state"`
+}
+
+// Capabilities of the active PTZ controller, omitted from status without one
+type Capabilities struct {
+	PanTilt        bool `json:"pan_tilt"`
+	Zoom           bool `json:"zoom"`
+	Focus          bool `json:"focus"`
+	AbsoluteMove   bool `json:"absolute_move"`
+	Presets        bool `json:"presets"`
+	PresetMin      int  `json:"preset_min"`
+	PresetMax      int  `json:"preset_max"`
+	Power          bool `json:"power"`
+	Tally          bool `json:"tally"`
+	Events         bool `json:"events"`
+	PanSpeedSteps  int  `json:"pan_speed_steps"` // 0 = continuous
+	TiltSpeedSteps int  `json:"tilt_speed_steps"`
+	ZoomSpeedSteps int  `json:"zoom_speed_steps"`
+}
+
+// CameraInfo describes an auto-detected camera
+type CameraInfo struct {
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Firmware     string   `json:"firmware,omitempty"`
+	Features     []string `json:"features"`
+	Detected     []string `json:"detected"` // Every protocol that answered the probe
 }
 
 // SDPPayload for offer/answer messages
@@ -54,6 +158,12 @@ type SDPPayload struct {
 	SDP string `json:"sdp"`
 }
 
+// RenegotiatePayload asks the server for a new offer. Video, if set, adds
+// (true) or removes (false) the video track first.
+type RenegotiatePayload struct {
+	Video *bool `json:"video,omitempty"`
+}
+
 // ICECandidatePayload for ICE candidate messages
 type ICECandidatePayload struct {
 	Candidate     string `json:"candidate"`
@@ -66,14 +176,79 @@ type PTZCommandPayload struct {
 	Pan  float64 `json:"pan"`
 	Tilt float64 `json:"tilt"`
 	Zoom float64 `json:"zoom"`
+	Seq  uint32  `json:"seq,omitempty"` // Client's PTZ message counter; older messages are dropped
 }
 
+// PTZStopPayload for stop messages
+type PTZStopPayload struct {
+	Seq uint32 `json:"seq,omitempty"`
+}
+
+// PTZChannelLabel is the label of the WebRTC data channel that carries
+// ptz_command and ptz_stop messages
+const PTZChannelLabel = "ptz"
+
 // PTZPresetPayload for preset recall/save
 type PTZPresetPayload struct {
 	Action       string `json:"action"`
 	PresetNumber int    `json:"preset_number"`
 }
 
+// PowerPayload for power on/standby requests
+type PowerPayload struct {
+	On bool `json:"on"`
+}
+
+// TallyPayload for tally lamp requests
+type TallyPayload struct {
+	Color string `json:"color"`
+	On    bool   `json:"on"`
+}
+
+// Camera event names
+const (
+	EventTally          = "tally"
+	EventPower          = "power"
+	EventPresetComplete = "preset_complete"
+	EventPosition       = "position"
+	EventLens           = "lens"
+)
+
+// CameraEventPayload for camera_event messages pushed by the camera
+type CameraEventPayload struct {
+	Event string `json:"event"`
+	Data  any    `json:"data"`
+}
+
+// TallyEventData for tally events
+type TallyEventData struct {
+	Color string `json:"color"`
+	On    bool   `json:"on"`
+}
+
+// PowerEventData for power events
+type PowerEventData struct {
+	State string `json:"state"`
+}
+
+// PresetCompleteEventData for preset_complete events
+type PresetCompleteEventData struct {
+	PresetNumber int `json:"preset_number"`
+}
+
+// PositionEventData for position events (raw camera units)
+type PositionEventData struct {
+	Pan  int `json:"pan"`
+	Tilt int `json:"tilt"`
+}
+
+// LensEventData for lens events (raw camera units)
+type LensEventData struct {
+	Zoom  int `json:"zoom"`
+	Focus int `json:"focus"`
+	Iris  int `json:"iris"`
+}
+
 // ErrorPayload for error messages
 type ErrorPayload struct {
 	Code    string `json:"code"`
@@ -1,5 +1,7 @@
 package ptz
 
+import "fmt"
+
 // Controller defines the interface for PTZ camera control
 type Controller interface {
 	// PanTilt sends a pan/tilt command
@@ -20,6 +22,69 @@ type Controller interface {
 	// SavePreset saves current position to a preset (0-255)
 	SavePreset(preset int) error
 
+	// Capabilities describes what this controller supports
+	Capabilities() Capabilities
+
 	// Close closes the controller connection
 	Close() error
 }
+
+// Capabilities describes the features of a controller and its camera
+type Capabilities struct {
+	PanTilt      bool
+	Zoom         bool
+	Focus        bool
+	AbsoluteMove bool
+	Presets      bool
+	PresetMin    int // Lowest valid preset number
+	PresetMax    int // Highest valid preset number
+	Power        bool
+	Tally        bool
+	Events       bool
+
+	// Distinct speeds per direction the camera accepts for each axis;
+	// 0 means the axis takes a continuous velocity
+	PanSpeedSteps  int
+	TiltSpeedSteps int
+	ZoomSpeedSteps int
+}
+
+// CheckPreset returns an error if presets are unsupported or preset is out of range
+func (c Capabilities) CheckPreset(preset int) error {
+	if !c.Presets {
+		return fmt.Errorf("presets not supported by this camera")
+	}
+	if preset < c.PresetMin || preset > c.PresetMax {
+		return fmt.Errorf("preset must be %d-%d", c.PresetMin, c.PresetMax)
+	}
+	return nil
+}
+
+// Power states reported by Power.PowerState
+const (
+	PowerOn         = "on"
+	PowerStandby    = "standby"
+	PowerTransition = "transition"
+	PowerUnknown    = "unknown"
+)
+
+// Tally lamp colors
+const (
+	TallyRed   = "red"
+	TallyGreen = "green"
+)
+
+// Power is implemented by controllers that can switch the camera between on and standby
+type Power interface {
+	// SetPower turns the camera on (true) or puts it in standby (false)
+	SetPower(on bool) error
+
+	// PowerState returns the last known power state
+	PowerState() string
+}
+
+// Tally is implemented by controllers that can drive the camera's tally lamps
+type Tally interface {
+	// SetTally turns a tally lamp (TallyRed or TallyGreen) on or off
+	SetTally(color string, on bool) error
+}
@@ -0,0 +1,46 @@
+package ptz
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle coalesces rapid updates, sending immediately when possible
+// and scheduling a trailing edge send for updates during cooldown.
+// The embedded mutex guards the throttle and the state Flush sends.
+type Throttle struct {
+	sync.Mutex
+	Interval time.Duration   // Minimum time between flushes
+	StopCh   <-chan struct{} // Cancels a scheduled flush
+	Flush    func()          // Called with the mutex held
+
+	lastSendTime time.Time
+	timerRunning bool
+}
+
+// Trigger flushes now if the interval has passed since the last flush,
+// or schedules one for when it has
+func (t *Throttle) Trigger() {
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastSendTime) >= t.Interval {
+		t.Flush()
+		t.lastSendTime = now
+	} else if !t.timerRunning {
+		t.timerRunning = true
+		remaining := t.Interval - now.Sub(t.lastSendTime)
+		go func() {
+			select {
+			case <-time.After(remaining):
+				t.Lock()
+				t.Flush()
+				t.lastSendTime = time.Now()
+				t.timerRunning = false
+				t.Unlock()
+			case <-t.StopCh:
+			}
+		}()
+	}
+}
@@ -0,0 +1,67 @@
+package ptz
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	var flushes atomic.Int32
+	var pending, sent int
+	th := &Throttle{Interval: 50 * time.Millisecond, StopCh: stopCh}
+	th.Flush = func() {
+		sent = pending
+		flushes.Add(1)
+	}
+	update := func(v int) {
+		th.Lock()
+		pending = v
+		th.Unlock()
+		th.Trigger()
+	}
+	value := func() int {
+		th.Lock()
+		defer th.Unlock()
+		return sent
+	}
+
+	// The first update goes out at once
+	update(1)
+	if got := value(); got != 1 {
+		t.Fatalf("sent %d, want 1 immediately", got)
+	}
+
+	// Updates during the cooldown coalesce into one trailing flush
+	for v := 2; v <= 10; v++ {
+		update(v)
+	}
+	if got := flushes.Load(); got != 1 {
+		t.Errorf("%d flushes during cooldown, want 1", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := value(); got != 10 {
+		t.Errorf("sent %d after cooldown, want the latest, 10", got)
+	}
+	if got := flushes.Load(); got != 2 {
+		t.Errorf("%d flushes, want 2", got)
+	}
+}
+
+func TestThrottleStop(t *testing.T) {
+	stopCh := make(chan struct{})
+	var flushes atomic.Int32
+	th := &Throttle{Interval: 50 * time.Millisecond, StopCh: stopCh}
+	th.Flush = func() { flushes.Add(1) }
+
+	th.Trigger()
+	th.Trigger() // Scheduled
+	close(stopCh)
+	time.Sleep(100 * time.Millisecond)
+	if got := flushes.Load(); got != 1 {
+		t.Errorf("%d flushes, want the trailing one cancelled", got)
+	}
+}
@@ -0,0 +1,204 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// AMF0 type markers
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0A
+	amfDate        = 0x0B
+	amfLongString  = 0x0C
+)
+
+// amfObj is an AMF0 object; keys are written in sorted order
+type amfObj map[string]any
+
+// amfEncode serializes values as AMF0. Supported Go types are float64, int,
+// bool, string, amfObj and nil (null).
+func amfEncode(values ...any) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		amfWrite(&b, v)
+	}
+	return b.Bytes()
+}
+
+func amfWrite(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(amfNull)
+	case float64:
+		b.WriteByte(amfNumber)
+		binary.Write(b, binary.BigEndian, math.Float64bits(v))
+	case int:
+		amfWrite(b, float64(v))
+	case bool:
+		b.WriteByte(amfBoolean)
+		if v {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case string:
+		b.WriteByte(amfString)
+		amfWriteKey(b, v)
+	case amfObj:
+		b.WriteByte(amfObject)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			amfWriteKey(b, k)
+			amfWrite(b, v[k])
+		}
+		b.Write([]byte{0, 0, amfObjectEnd})
+	default:
+		panic(fmt.Sprintf("amf: unsupported type %T", v))
+	}
+}
+
+func amfWriteKey(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+// amfDecode parses a sequence of AMF0 values. Numbers decode to float64,
+// objects and ECMA arrays to amfObj, strict arrays to []any, null and
+// undefined to nil, and dates to their float64 milliseconds.
+func amfDecode(data []byte) ([]any, error) {
+	d := amfDecoder{data: data}
+	var values []any
+	for d.pos < len(d.data) {
+		v, err := d.value()
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+type amfDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *amfDecoder) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, fmt.Errorf("amf: truncated value")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *amfDecoder) value() (any, error) {
+	marker, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker[0] {
+	case amfNumber:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case amfBoolean:
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case amfString:
+		return d.key()
+	case amfLongString:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.take(int(binary.BigEndian.Uint32(b)))
+		return string(s), err
+	case amfObject:
+		return d.object()
+	case amfECMAArray:
+		if _, err := d.take(4); err != nil {
+			return nil, err
+		}
+		return d.object()
+	case amfStrictArray:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint32(b))
+		var arr []any
+		for i := 0; i < n; i++ {
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case amfDate:
+		b, err := d.take(10) // float64 ms + int16 timezone
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case amfNull, amfUndefined:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("amf: unsupported type marker %#x", marker[0])
+}
+
+func (d *amfDecoder) key() (string, error) {
+	b, err := d.take(2)
+	if err != nil {
+		return "", err
+	}
+	s, err := d.take(int(binary.BigEndian.Uint16(b)))
+	return string(s), err
+}
+
+func (d *amfDecoder) object() (amfObj, error) {
+	obj := amfObj{}
+	for {
+		k, err := d.key()
+		if err != nil {
+			return nil, err
+		}
+		if k == "" {
+			end, err := d.take(1)
+			if err != nil {
+				return nil, err
+			}
+			if end[0] == amfObjectEnd {
+				return obj, nil
+			}
+			d.pos--
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		obj[k] = v
+	}
+}
@@ -0,0 +1,258 @@
+package rtmp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Message type IDs
+const (
+	msgSetChunkSize     = 1
+	msgAbort            = 2
+	msgAcknowledgement  = 3
+	msgUserControl      = 4
+	msgWindowAckSize    = 5
+	msgSetPeerBandwidth = 6
+	msgAudio            = 8
+	msgVideo            = 9
+	msgDataAMF3         = 15
+	msgCommandAMF3      = 17
+	msgDataAMF0         = 18
+	msgCommandAMF0      = 20
+)
+
+const (
+	handshakeSize    = 1536
+	defaultChunkSize = 128
+	maxMessageSize   = 8 << 20
+)
+
+// message is a reassembled RTMP message
+type message struct {
+	typ       uint8
+	streamID  uint32
+	timestamp uint32 // milliseconds
+	payload   []byte
+}
+
+// chunkStream is the reassembly state of one chunk stream ID
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typ       uint8
+	streamID  uint32
+	extended  bool
+	payload   []byte // partial message
+}
+
+// conn reads and writes RTMP chunk streams over a network connection
+type conn struct {
+	nc net.Conn
+	br *bufio.Reader
+
+	readChunkSize  uint32
+	writeChunkSize uint32
+	streams        map[uint32]*chunkStream
+
+	ackWindow uint32 // peer's window acknowledgement size; 0 until set
+	received  uint32
+	acked     uint32
+}
+
+func newConn(nc net.Conn) *conn {
+	return &conn{
+		nc:             nc,
+		br:             bufio.NewReaderSize(nc, 64<<10),
+		readChunkSize:  defaultChunkSize,
+		writeChunkSize: defaultChunkSize,
+		streams:        make(map[uint32]*chunkStream),
+	}
+}
+
+// handshake performs the server side of the simple (unsigned) handshake,
+// which is what encoders use for publishing
+func (c *conn) handshake() error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(c.br, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != 3 {
+		return fmt.Errorf("unsupported RTMP version %d", c0c1[0])
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = 3
+	rand.Read(s0s1s2[9 : 1+handshakeSize])   // S1: time and zero, then random bytes
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:]) // S2 echoes C1
+	if _, err := c.nc.Write(s0s1s2); err != nil {
+		return err
+	}
+
+	c2 := make([]byte, handshakeSize)
+	_, err := io.ReadFull(c.br, c2)
+	return err
+}
+
+// read reads bytes for a chunk and counts them for acknowledgements
+func (c *conn) read(b []byte) error {
+	if _, err := io.ReadFull(c.br, b); err != nil {
+		return err
+	}
+	c.received += uint32(len(b))
+	return nil
+}
+
+// readMessage reads chunks until a complete message is available. Protocol
+// control messages for chunk size, abort and acknowledgement window are
+// applied here and also returned.
+func (c *conn) readMessage() (*message, error) {
+	var hdr [11]byte
+	for {
+		if err := c.read(hdr[:1]); err != nil {
+			return nil, err
+		}
+		fmtType := hdr[0] >> 6
+		csid := uint32(hdr[0] & 0x3F)
+		switch csid {
+		case 0:
+			if err := c.read(hdr[:1]); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(hdr[0])
+		case 1:
+			if err := c.read(hdr[:2]); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(hdr[0]) + uint32(hdr[1])<<8
+		}
+
+		cs := c.streams[csid]
+		if cs == nil {
+			if fmtType != 0 {
+				return nil, fmt.Errorf("chunk stream %d starts without a full header", csid)
+			}
+			cs = &chunkStream{}
+			c.streams[csid] = cs
+		}
+
+		headerLen := [4]int{11, 7, 3, 0}[fmtType]
+		if err := c.read(hdr[:headerLen]); err != nil {
+			return nil, err
+		}
+		var ts uint32
+		if headerLen >= 3 {
+			ts = uint32(hdr[0])<<16 | uint32(hdr[1])<<8 | uint32(hdr[2])
+			cs.extended = ts == 0xFFFFFF
+		}
+		if headerLen >= 7 {
+			cs.length = uint32(hdr[3])<<16 | uint32(hdr[4])<<8 | uint32(hdr[5])
+			cs.typ = hdr[6]
+		}
+		if headerLen == 11 {
+			cs.streamID = binary.LittleEndian.Uint32(hdr[7:11])
+		}
+		if cs.extended {
+			if err := c.read(hdr[:4]); err != nil {
+				return nil, err
+			}
+			ts = binary.BigEndian.Uint32(hdr[:4])
+		}
+
+		// Only a type 3 header continues a partial message; any other drops it
+		if cs.payload == nil || fmtType != 3 {
+			switch fmtType {
+			case 0:
+				cs.timestamp = ts
+				cs.delta = 0
+			case 1, 2:
+				cs.delta = ts
+				cs.timestamp += ts
+			case 3:
+				cs.timestamp += cs.delta
+			}
+			if cs.length > maxMessageSize {
+				return nil, fmt.Errorf("message of %d bytes is too large", cs.length)
+			}
+			cs.payload = make([]byte, 0, cs.length)
+		}
+
+		n := min(cs.length-uint32(len(cs.payload)), c.readChunkSize)
+		chunk := cs.payload[len(cs.payload) : len(cs.payload)+int(n)]
+		if err := c.read(chunk); err != nil {
+			return nil, err
+		}
+		cs.payload = cs.payload[:len(cs.payload)+int(n)]
+
+		if err := c.sendAck(); err != nil {
+			return nil, err
+		}
+		if uint32(len(cs.payload)) < cs.length {
+			continue
+		}
+
+		msg := &message{typ: cs.typ, streamID: cs.streamID, timestamp: cs.timestamp, payload: cs.payload}
+		cs.payload = nil
+
+		switch msg.typ {
+		case msgSetChunkSize:
+			if len(msg.payload) >= 4 {
+				size := binary.BigEndian.Uint32(msg.payload) & 0x7FFFFFFF
+				if size == 0 {
+					return nil, fmt.Errorf("invalid chunk size 0")
+				}
+				c.readChunkSize = size
+			}
+		case msgAbort:
+			if len(msg.payload) >= 4 {
+				if s := c.streams[binary.BigEndian.Uint32(msg.payload)]; s != nil {
+					s.payload = nil
+				}
+			}
+		case msgWindowAckSize:
+			if len(msg.payload) >= 4 {
+				c.ackWindow = binary.BigEndian.Uint32(msg.payload)
+			}
+		}
+		return msg, nil
+	}
+}
+
+// sendAck acknowledges the received bytes once a window has been read
+func (c *conn) sendAck() error {
+	if c.ackWindow == 0 || c.received-c.acked < c.ackWindow {
+		return nil
+	}
+	c.acked = c.received
+	return c.writeMessage(2, &message{typ: msgAcknowledgement, payload: be32(c.received)})
+}
+
+// writeMessage sends a message on chunk stream csid (2-63)
+func (c *conn) writeMessage(csid uint8, msg *message) error {
+	buf := make([]byte, 0, 12+len(msg.payload)+len(msg.payload)/int(c.writeChunkSize))
+	buf = append(buf, csid&0x3F,
+		byte(msg.timestamp>>16), byte(msg.timestamp>>8), byte(msg.timestamp),
+		byte(len(msg.payload)>>16), byte(len(msg.payload)>>8), byte(len(msg.payload)),
+		msg.typ)
+	buf = binary.LittleEndian.AppendUint32(buf, msg.streamID)
+
+	for p := msg.payload; ; {
+		n := min(len(p), int(c.writeChunkSize))
+		buf = append(buf, p[:n]...)
+		p = p[n:]
+		if len(p) == 0 {
+			break
+		}
+		buf = append(buf, 0xC0|csid&0x3F) // fmt 3 continuation
+	}
+	_, err := c.nc.Write(buf)
+	return err
+}
+
+func be32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
@@ -0,0 +1,415 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// basicHeader encodes a chunk basic header in its 1, 2 or 3 byte form
+func basicHeader(fmtType byte, csid uint32, form int) []byte {
+	switch form {
+	case 2:
+		return []byte{fmtType << 6, byte(csid - 64)}
+	case 3:
+		return []byte{fmtType<<6 | 1, byte(csid - 64), byte((csid - 64) >> 8)}
+	}
+	return []byte{fmtType<<6 | byte(csid)}
+}
+
+// chunkHeader encodes a chunk header; ts is the timestamp for type 0 and
+// the delta for types 1 and 2, sent extended when it doesn't fit 24 bits
+func chunkHeader(fmtType byte, csid uint32, ts uint32, length int, typ byte) []byte {
+	form := 1
+	if csid >= 320 {
+		form = 3
+	} else if csid >= 64 {
+		form = 2
+	}
+	b := basicHeader(fmtType, csid, form)
+	if fmtType == 3 {
+		return b
+	}
+	field := min(ts, 0xFFFFFF)
+	b = append(b, byte(field>>16), byte(field>>8), byte(field))
+	if fmtType <= 1 {
+		b = append(b, byte(length>>16), byte(length>>8), byte(length), typ)
+	}
+	if fmtType == 0 {
+		b = binary.LittleEndian.AppendUint32(b, 1)
+	}
+	if field == 0xFFFFFF {
+		b = binary.BigEndian.AppendUint32(b, ts)
+	}
+	return b
+}
+
+// chunked sends a message on csid in 128-byte chunks after a type 0 header
+func chunked(csid uint32, ts uint32, typ byte, payload []byte) []byte {
+	b := chunkHeader(0, csid, ts, len(payload), typ)
+	for p := payload; ; {
+		n := min(len(p), defaultChunkSize)
+		b = append(b, p[:n]...)
+		p = p[n:]
+		if len(p) == 0 {
+			return b
+		}
+		b = append(b, chunkHeader(3, csid, 0, 0, 0)...)
+		if ts >= 0xFFFFFF {
+			b = binary.BigEndian.AppendUint32(b, ts) // Repeated on continuations
+		}
+	}
+}
+
+func cat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func payload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func testConn(data []byte) *conn {
+	return &conn{
+		br:             bufio.NewReader(bytes.NewReader(data)),
+		readChunkSize:  defaultChunkSize,
+		writeChunkSize: defaultChunkSize,
+		streams:        make(map[uint32]*chunkStream),
+	}
+}
+
+// readAll reads messages until the input ends or an error occurs
+func readAll(c *conn) ([]*message, error) {
+	var msgs []*message
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	p300 := payload(300)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []message
+		wantErr bool // An error other than the end of input
+	}{
+		{
+			name: "single chunk",
+			data: cat(chunkHeader(0, 3, 1000, 5, msgVideo), payload(5)),
+			want: []message{{typ: msgVideo, streamID: 1, timestamp: 1000, payload: payload(5)}},
+		},
+		{
+			name: "continuation chunks",
+			data: chunked(4, 0, msgVideo, p300),
+			want: []message{{typ: msgVideo, streamID: 1, payload: p300}},
+		},
+		{
+			name: "interleaved chunk streams",
+			data: cat(
+				chunkHeader(0, 4, 10, 300, msgVideo), p300[:128],
+				chunkHeader(0, 5, 20, 3, msgAudio), []byte{7, 8, 9},
+				chunkHeader(3, 4, 0, 0, 0), p300[128:256],
+				chunkHeader(3, 4, 0, 0, 0), p300[256:],
+			),
+			want: []message{
+				{typ: msgAudio, streamID: 1, timestamp: 20, payload: []byte{7, 8, 9}},
+				{typ: msgVideo, streamID: 1, timestamp: 10, payload: p300},
+			},
+		},
+		{
+			name: "timestamp deltas",
+			data: cat(
+				chunkHeader(0, 4, 1000, 3, msgVideo), payload(3),
+				chunkHeader(1, 4, 40, 4, msgAudio), payload(4),
+				chunkHeader(2, 4, 20, 0, 0), payload(4),
+				chunkHeader(3, 4, 0, 0, 0), payload(4), // Repeats the last delta
+			),
+			want: []message{
+				{typ: msgVideo, streamID: 1, timestamp: 1000, payload: payload(3)},
+				{typ: msgAudio, streamID: 1, timestamp: 1040, payload: payload(4)},
+				{typ: msgAudio, streamID: 1, timestamp: 1060, payload: payload(4)},
+				{typ: msgAudio, streamID: 1, timestamp: 1080, payload: payload(4)},
+			},
+		},
+		{
+			name: "extended timestamp",
+			data: cat(chunked(4, 0x01000000, msgVideo, payload(200)), chunkHeader(1, 4, 0x01000000, 1, msgVideo), []byte{1}),
+			want: []message{
+				{typ: msgVideo, streamID: 1, timestamp: 0x01000000, payload: payload(200)},
+				{typ: msgVideo, streamID: 1, timestamp: 0x02000000, payload: []byte{1}},
+			},
+		},
+		{
+			name: "two and three byte chunk stream IDs",
+			data: cat(
+				chunkHeader(0, 64, 0, 200, msgVideo), p300[:128],
+				chunkHeader(0, 319, 5, 1, msgAudio), []byte{1},
+				chunkHeader(0, 65599, 6, 1, msgAudio), []byte{2},
+				basicHeader(3, 64, 3), p300[128:200], // csid 64 in the long form
+			),
+			want: []message{
+				{typ: msgAudio, streamID: 1, timestamp: 5, payload: []byte{1}},
+				{typ: msgAudio, streamID: 1, timestamp: 6, payload: []byte{2}},
+				{typ: msgVideo, streamID: 1, payload: p300[:200]},
+			},
+		},
+		{
+			name: "set chunk size",
+			data: cat(
+				chunkHeader(0, 2, 0, 4, msgSetChunkSize), be32(4096),
+				chunkHeader(0, 4, 0, 300, msgVideo), p300, // One chunk
+			),
+			want: []message{
+				{typ: msgSetChunkSize, streamID: 1, payload: be32(4096)},
+				{typ: msgVideo, streamID: 1, payload: p300},
+			},
+		},
+		{
+			name: "abort",
+			data: cat(
+				chunkHeader(0, 4, 0, 300, msgVideo), payload(128),
+				chunkHeader(0, 2, 0, 4, msgAbort), be32(4),
+				// The type 3 chunks now start a new message
+				chunkHeader(3, 4, 0, 0, 0), p300[:128],
+				chunkHeader(3, 4, 0, 0, 0), p300[128:256],
+				chunkHeader(3, 4, 0, 0, 0), p300[256:],
+			),
+			want: []message{
+				{typ: msgAbort, streamID: 1, payload: be32(4)},
+				{typ: msgVideo, streamID: 1, payload: p300},
+			},
+		},
+		{
+			name: "full header drops a partial message",
+			data: cat(
+				chunkHeader(0, 4, 0, 300, msgVideo), payload(128),
+				chunkHeader(0, 4, 0, 2, msgVideo), []byte{1, 2},
+			),
+			want: []message{{typ: msgVideo, streamID: 1, payload: []byte{1, 2}}},
+		},
+		{
+			name: "empty message",
+			data: chunkHeader(0, 3, 0, 0, msgDataAMF0),
+			want: []message{{typ: msgDataAMF0, streamID: 1, payload: []byte{}}},
+		},
+		{
+			name:    "new chunk stream without a full header",
+			data:    cat(chunkHeader(1, 4, 0, 1, msgVideo), []byte{1}),
+			wantErr: true,
+		},
+		{
+			name:    "continuation of an unknown chunk stream",
+			data:    cat(chunkHeader(3, 4, 0, 0, 0), []byte{1}),
+			wantErr: true,
+		},
+		{
+			name:    "oversized message",
+			data:    chunkHeader(0, 4, 0, maxMessageSize+1, msgVideo),
+			wantErr: true,
+		},
+		{
+			name:    "chunk size 0",
+			data:    cat(chunkHeader(0, 2, 0, 4, msgSetChunkSize), be32(0)),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAll(testConn(tt.data))
+			if (err != io.EOF) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d messages, want %d", len(got), len(tt.want))
+			}
+			for i, msg := range got {
+				want := tt.want[i]
+				if msg.typ != want.typ || msg.streamID != want.streamID || msg.timestamp != want.timestamp || !bytes.Equal(msg.payload, want.payload) {
+					t.Errorf("message %d: type %d stream %d at %d with %d bytes, want type %d stream %d at %d with %d bytes",
+						i, msg.typ, msg.streamID, msg.timestamp, len(msg.payload), want.typ, want.streamID, want.timestamp, len(want.payload))
+				}
+			}
+		})
+	}
+}
+
+func TestReadMessageSetChunkSize(t *testing.T) {
+	c := testConn(cat(chunkHeader(0, 2, 0, 4, msgSetChunkSize), be32(0x80001000)))
+	if _, err := c.readMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if c.readChunkSize != 0x1000 {
+		t.Errorf("chunk size %d, want the reserved bit ignored", c.readChunkSize)
+	}
+}
+
+// TestReadMessageTruncated cuts a valid stream at every length
+func TestReadMessageTruncated(t *testing.T) {
+	data := cat(
+		chunkHeader(0, 2, 0, 4, msgSetChunkSize), be32(100),
+		chunkHeader(0, 64, 0x01000000, 250, msgVideo), payload(100),
+		chunkHeader(0, 400, 7, 2, msgAudio), []byte{1, 2},
+		basicHeader(3, 64, 2), be32(0x01000000), payload(100),
+		basicHeader(3, 64, 2), be32(0x01000000), payload(50),
+	)
+	if msgs, err := readAll(testConn(data)); err != io.EOF || len(msgs) != 3 {
+		t.Fatalf("full stream: %d messages, error %v", len(msgs), err)
+	}
+	for n := range data {
+		msgs, err := readAll(testConn(data[:n]))
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%d bytes: error %v, want end of input", n, err)
+		}
+		if len(msgs) >= 3 {
+			t.Errorf("%d bytes: got all messages", n)
+		}
+	}
+}
+
+// TestReadMessageRandom checks that corrupted input doesn't panic the reader
+func TestReadMessageRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	valid := cat(
+		chunkHeader(0, 2, 0, 4, msgSetChunkSize), be32(100),
+		chunkHeader(0, 64, 0, 250, msgVideo), payload(100),
+		chunkHeader(0, 4, 0, 2, msgAbort), be32(64),
+		chunkHeader(1, 4, 5, 8, msgWindowAckSize), be32(1<<30), be32(0),
+		basicHeader(3, 64, 3), payload(100),
+	)
+	for i := 0; i < 1000; i++ {
+		b := append([]byte(nil), valid...)
+		for j := 0; j < 1+rng.Intn(8); j++ {
+			b[rng.Intn(len(b))] = byte(rng.Intn(256))
+		}
+		c := testConn(b)
+		c.nc = discardConn{}
+		readAll(c)
+	}
+}
+
+// discardConn swallows acknowledgements sent while reading
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+
+func TestAcknowledgement(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newConn(server)
+
+	acks := make(chan uint32, 10)
+	go func() {
+		defer close(acks)
+		cc := newConn(client)
+		for {
+			msg, err := cc.readMessage()
+			if err != nil {
+				return
+			}
+			if msg.typ == msgAcknowledgement && len(msg.payload) == 4 {
+				acks <- binary.BigEndian.Uint32(msg.payload)
+			}
+		}
+	}()
+	go client.Write(cat(
+		chunkHeader(0, 2, 0, 4, msgWindowAckSize), be32(256), // 16 bytes
+		chunked(4, 0, msgVideo, payload(600)), // 632 bytes in all
+	))
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.readMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.ackWindow != 256 {
+		t.Errorf("window %d, want 256", c.ackWindow)
+	}
+	server.Close()
+
+	var got []uint32
+	for ack := range acks {
+		got = append(got, ack)
+	}
+	// Sent after the first chunk that completes each window
+	if want := []uint32{285, 543}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("acknowledged %v, want %v", got, want)
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	w := newConn(server)
+	w.writeChunkSize = 100
+	want := &message{typ: msgCommandAMF0, streamID: 1, timestamp: 1234, payload: payload(250)}
+	go w.writeMessage(3, want)
+
+	r := newConn(client)
+	r.readChunkSize = 100
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	got, err := r.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.typ != want.typ || got.streamID != want.streamID || got.timestamp != want.timestamp || !bytes.Equal(got.payload, want.payload) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		c1      int // C1 bytes sent
+		wantErr bool
+	}{
+		{"valid", 3, handshakeSize, false},
+		{"unsupported version", 6, handshakeSize, true},
+		{"short C1", 3, handshakeSize - 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			c1 := payload(handshakeSize)
+			go func() {
+				defer client.Close()
+				client.Write(append([]byte{tt.version}, c1[:tt.c1]...))
+				if tt.c1 < handshakeSize {
+					return
+				}
+				s0s1s2 := make([]byte, 1+2*handshakeSize)
+				if _, err := io.ReadFull(client, s0s1s2); err != nil {
+					return
+				}
+				if s0s1s2[0] != 3 || !bytes.Equal(s0s1s2[1+handshakeSize:], c1) {
+					t.Error("S0/S2 don't echo the version and C1")
+				}
+				client.Write(s0s1s2[1 : 1+handshakeSize]) // C2 echoes S1
+			}()
+
+			server.SetDeadline(time.Now().Add(time.Second))
+			if err := newConn(server).handshake(); (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
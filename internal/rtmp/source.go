@@ -0,0 +1,417 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/video"
+)
+
+// Values sent to publishers in the connect response
+const (
+	windowAckSize = 2500000
+	chunkSize     = 4096
+	publishStream = 1
+)
+
+// FLV video tag fields
+const (
+	codecAVC          = 7
+	frameTypeKey      = 1
+	avcSequenceHeader = 0
+	avcNALU           = 1
+)
+
+// Config for an RTMP push listener
+type Config struct {
+	Address   string // TCP listen address, default ":1935"
+	StreamKey string // If set, publishers must use this stream name
+}
+
+// Source accepts an RTMP publish (e.g. from a camera's or encoder's "RTMP
+// push" setting) and repacketizes its H.264 video into RTP. Only one
+// publisher is active at a time; a new one replaces the previous. Audio and
+// metadata are ignored.
+type Source struct {
+	cfg     Config
+	rtpChan chan []byte
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	listener  net.Listener
+	conns     map[net.Conn]struct{}
+	publisher net.Conn
+	codec     string
+	stopped   bool
+}
+
+// NewSource creates an RTMP source; it listens once Connect is called
+func NewSource(cfg Config) (*Source, error) {
+	if cfg.Address == "" {
+		cfg.Address = ":1935"
+	}
+	return &Source{
+		cfg:     cfg,
+		rtpChan: make(chan []byte, 500),
+		stopCh:  make(chan struct{}),
+		conns:   make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Connect starts listening for publishers
+func (s *Source) Connect() error {
+	ln, err := net.Listen("tcp", s.cfg.Address)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	log.Printf("RTMP: Waiting for a camera to publish on %s", s.cfg.Address)
+	s.wg.Add(1)
+	go s.accept(ln)
+	return nil
+}
+
+func (s *Source) accept(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.stopCh:
+			default:
+				log.Printf("RTMP: Accept failed: %v", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.stopped {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.conns[nc] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer nc.Close()
+			if err := s.serve(nc); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("RTMP: Connection from %s closed: %v", nc.RemoteAddr(), err)
+			}
+			s.mu.Lock()
+			delete(s.conns, nc)
+			if s.publisher == nc {
+				s.publisher = nil
+				log.Printf("RTMP: Publisher disconnected")
+			}
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// session is the state of one publishing connection
+type session struct {
+	*conn
+	src        *Source
+	publishing bool
+	warned     bool
+	lengthSize int
+	sps, pps   [][]byte
+	packetizer *video.H264Packetizer
+	start      time.Time
+}
+
+// serve runs one client connection until it disconnects
+func (s *Source) serve(nc net.Conn) error {
+	nc.SetDeadline(time.Now().Add(10 * time.Second))
+	c := newConn(nc)
+	if err := c.handshake(); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+
+	sess := &session{conn: c, src: s}
+	for {
+		// Publishers send continuously; a stalled one is dropped
+		nc.SetDeadline(time.Now().Add(10 * time.Second))
+
+		msg, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg.typ {
+		case msgCommandAMF0, msgCommandAMF3:
+			payload := msg.payload
+			if msg.typ == msgCommandAMF3 && len(payload) > 0 {
+				payload = payload[1:] // AMF3 commands are AMF0 after a format byte
+			}
+			if err := sess.handleCommand(msg.streamID, payload); err != nil {
+				return err
+			}
+		case msgVideo:
+			if sess.publishing {
+				sess.handleVideo(msg.timestamp, msg.payload)
+			}
+		}
+	}
+}
+
+// handleCommand answers the NetConnection/NetStream commands of a publisher
+func (sess *session) handleCommand(streamID uint32, payload []byte) error {
+	values, err := amfDecode(payload)
+	if len(values) < 2 {
+		return fmt.Errorf("malformed command: %v", err)
+	}
+	name, _ := values[0].(string)
+	txID, _ := values[1].(float64)
+
+	switch name {
+	case "connect":
+		if err := sess.writeMessage(2, &message{typ: msgWindowAckSize, payload: be32(windowAckSize)}); err != nil {
+			return err
+		}
+		if err := sess.writeMessage(2, &message{typ: msgSetPeerBandwidth, payload: append(be32(windowAckSize), 2)}); err != nil {
+			return err
+		}
+		if err := sess.writeMessage(2, &message{typ: msgSetChunkSize, payload: be32(chunkSize)}); err != nil {
+			return err
+		}
+		sess.writeChunkSize = chunkSize
+		return sess.command(0, "_result", txID,
+			amfObj{"fmsVer": "FMS/3,0,1,123", "capabilities": 31},
+			amfObj{"level": "status", "code": "NetConnection.Connect.Success", "description": "Connection succeeded.", "objectEncoding": 0})
+
+	case "createStream":
+		return sess.command(0, "_result", txID, nil, publishStream)
+
+	case "releaseStream", "FCPublish", "FCUnpublish":
+		return sess.command(0, "_result", txID, nil)
+
+	case "publish":
+		key := ""
+		if len(values) > 3 {
+			key, _ = values[3].(string)
+		}
+		if sess.src.cfg.StreamKey != "" && key != sess.src.cfg.StreamKey {
+			sess.command(streamID, "onStatus", 0, nil,
+				amfObj{"level": "error", "code": "NetStream.Publish.BadName", "description": "Invalid stream key."})
+			return fmt.Errorf("invalid stream key %q", key)
+		}
+
+		sess.src.setPublisher(sess.nc)
+		sess.publishing = true
+		sess.packetizer = video.NewH264Packetizer()
+		log.Printf("RTMP: Camera at %s is publishing", sess.nc.RemoteAddr())
+		return sess.command(streamID, "onStatus", 0, nil,
+			amfObj{"level": "status", "code": "NetStream.Publish.Start", "description": "Publishing."})
+
+	case "deleteStream":
+		return fmt.Errorf("publisher ended the stream")
+	}
+	return nil
+}
+
+// command sends an AMF0 command message
+func (sess *session) command(streamID uint32, values ...any) error {
+	return sess.writeMessage(3, &message{typ: msgCommandAMF0, streamID: streamID, payload: amfEncode(values...)})
+}
+
+// handleVideo converts an FLV AVC video tag to an Annex-B access unit and
+// packetizes it
+func (sess *session) handleVideo(timestamp uint32, tag []byte) {
+	if len(tag) < 5 {
+		return
+	}
+	if tag[0]&0x80 != 0 || tag[0]&0x0F != codecAVC {
+		// Enhanced RTMP (HEVC, AV1) and legacy codecs aren't supported
+		if !sess.warned {
+			log.Printf("RTMP: Publisher's video codec is not supported, only H.264")
+			sess.warned = true
+		}
+		return
+	}
+	keyframe := tag[0]>>4 == frameTypeKey
+	data := tag[5:]
+
+	switch tag[1] {
+	case avcSequenceHeader:
+		if err := sess.parseDecoderConfig(data); err != nil {
+			log.Printf("RTMP: %v", err)
+			return
+		}
+		sess.src.setCodec(video.CodecH264)
+	case avcNALU:
+		if sess.lengthSize == 0 {
+			return // No sequence header yet
+		}
+		au := sess.annexB(data, keyframe)
+		if len(au) == 0 {
+			return
+		}
+		// The message timestamp is the decode time, which only moves forward
+		if sess.start.IsZero() {
+			sess.start = time.Now().Add(-time.Duration(timestamp) * time.Millisecond)
+		}
+		t := sess.start.Add(time.Duration(timestamp) * time.Millisecond)
+		for _, packet := range sess.packetizer.Packetize(au, t) {
+			sess.src.send(packet)
+		}
+	}
+}
+
+// parseDecoderConfig reads the SPS, PPS and NALU length size from an
+// AVCDecoderConfigurationRecord
+func (sess *session) parseDecoderConfig(b []byte) error {
+	if len(b) < 6 {
+		return fmt.Errorf("short AVC decoder configuration")
+	}
+	lengthSize := int(b[4]&0x03) + 1
+	var sps, pps [][]byte
+
+	pos := 6
+	readSets := func(count int) ([][]byte, error) {
+		var sets [][]byte
+		for i := 0; i < count; i++ {
+			if pos+2 > len(b) {
+				return nil, fmt.Errorf("truncated AVC decoder configuration")
+			}
+			n := int(binary.BigEndian.Uint16(b[pos:]))
+			pos += 2
+			if pos+n > len(b) {
+				return nil, fmt.Errorf("truncated AVC decoder configuration")
+			}
+			sets = append(sets, append([]byte(nil), b[pos:pos+n]...))
+			pos += n
+		}
+		return sets, nil
+	}
+
+	sps, err := readSets(int(b[5] & 0x1F))
+	if err != nil {
+		return err
+	}
+	if pos >= len(b) {
+		return fmt.Errorf("truncated AVC decoder configuration")
+	}
+	count := int(b[pos])
+	pos++
+	if pps, err = readSets(count); err != nil {
+		return err
+	}
+
+	sess.lengthSize, sess.sps, sess.pps = lengthSize, sps, pps
+	return nil
+}
+
+// annexB converts length-prefixed NAL units to an Annex-B access unit. SPS
+// and PPS from the sequence header are prepended to keyframes that lack
+// them, so viewers joining mid-stream can start decoding.
+func (sess *session) annexB(data []byte, keyframe bool) []byte {
+	startCode := []byte{0, 0, 0, 1}
+	var au []byte
+	hasSPS := false
+
+	for len(data) >= sess.lengthSize {
+		n := 0
+		for _, b := range data[:sess.lengthSize] {
+			n = n<<8 | int(b)
+		}
+		data = data[sess.lengthSize:]
+		if n == 0 || n > len(data) {
+			break
+		}
+		nalu := data[:n]
+		data = data[n:]
+
+		if nalu[0]&0x1F == 7 {
+			hasSPS = true
+		}
+		au = append(au, startCode...)
+		au = append(au, nalu...)
+	}
+
+	if keyframe && !hasSPS && len(au) > 0 {
+		var params []byte
+		for _, set := range append(append([][]byte(nil), sess.sps...), sess.pps...) {
+			params = append(params, startCode...)
+			params = append(params, set...)
+		}
+		au = append(params, au...)
+	}
+	return au
+}
+
+// setPublisher makes nc the active publisher, disconnecting any previous one
+func (s *Source) setPublisher(nc net.Conn) {
+	s.mu.Lock()
+	previous := s.publisher
+	s.publisher = nc
+	s.mu.Unlock()
+
+	if previous != nil && previous != nc {
+		log.Printf("RTMP: New publisher from %s replaces the previous one", nc.RemoteAddr())
+		previous.Close()
+	}
+}
+
+func (s *Source) setCodec(codec string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codec = codec
+}
+
+// send queues an RTP packet, dropping it if the channel is full
+func (s *Source) send(packet []byte) {
+	select {
+	case s.rtpChan <- packet:
+	case <-s.stopCh:
+	default:
+	}
+}
+
+// Codec returns the publisher's codec, or "" before one connects
+func (s *Source) Codec() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codec
+}
+
+// RTPChannel returns the channel for receiving RTP packets
+func (s *Source) RTPChannel() <-chan []byte {
+	return s.rtpChan
+}
+
+// Close stops listening and disconnects the publisher
+func (s *Source) Close() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	ln := s.listener
+	for nc := range s.conns {
+		nc.Close()
+	}
+	s.mu.Unlock()
+
+	close(s.stopCh)
+	if ln != nil {
+		ln.Close()
+	}
+	s.wg.Wait()
+	close(s.rtpChan)
+	return nil
+}
@@ -1,7 +1,9 @@
 package rtsp
 
 import (
+	"fmt"
 	"log"
+	"net/url"
 	"sync"
 	"time"
 
@@ -10,78 +12,160 @@ import (
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
 	"github.com/pion/rtp"
+
+	"ptz-remote/internal/video"
+)
+
+// Transports for Config.Transport
+const (
+	TransportTCP       = "tcp"       // RTP interleaved in the RTSP connection
+	TransportUDP       = "udp"       // RTP over unicast UDP
+	TransportMulticast = "multicast" // RTP over UDP multicast
+	TransportAuto      = "auto"      // UDP, falling back to TCP
 )
 
+// Config for an RTSP client
+type Config struct {
+	URL          string
+	Transport    string        // TransportTCP (default), TransportUDP, TransportMulticast or TransportAuto
+	ReadTimeout  time.Duration // Default 10s
+	WriteTimeout time.Duration // Default 10s
+	UserAgent    string        // Sent in every request, if set
+	Username     string        // Overrides credentials in the URL
+	Password     string
+}
+
 // Client handles RTSP connection and RTP streaming using gortsplib
 type Client struct {
-	url     string
+	cfg     Config
+	url     *base.URL
 	rtpChan chan []byte
 	stopCh  chan struct{}
 
-	mu      sync.Mutex
-	client  *gortsplib.Client
-	stopped bool
+	mu         sync.Mutex
+	client     *gortsplib.Client
+	codec      string
+	stopped    bool
+	session    uint64         // Incremented by Connect and Disconnect; stops stale reconnects
+	connecting sync.WaitGroup // Handshakes in progress, which may still send on rtpChan
 }
 
 // NewClient creates a new RTSP client
-func NewClient(rtspURL string) (*Client, error) {
-	// Validate URL by parsing it
-	_, err := base.ParseURL(rtspURL)
+func NewClient(cfg Config) (*Client, error) {
+	u, err := base.ParseURL(cfg.URL)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.Username != "" {
+		u.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+
+	if cfg.Transport == "" {
+		cfg.Transport = TransportTCP
+	}
+	switch cfg.Transport {
+	case TransportTCP, TransportUDP, TransportMulticast, TransportAuto:
+	default:
+		return nil, fmt.Errorf("unsupported RTSP transport: %s", cfg.Transport)
+	}
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = 10 * time.Second
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
 
 	return &Client{
-		url:     rtspURL,
+		cfg:     cfg,
+		url:     u,
 		rtpChan: make(chan []byte, 500),
 		stopCh:  make(chan struct{}),
 	}, nil
 }
 
-// Connect establishes the RTSP connection and starts streaming
+// Connect establishes the RTSP connection and starts streaming. It can be
+// called again after Disconnect.
 func (c *Client) Connect() error {
-	if err := c.connect(); err != nil {
-		return err
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return fmt.Errorf("client closed")
 	}
-	return nil
+	if c.client != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.session++
+	session := c.session
+	c.mu.Unlock()
+
+	return c.connect(session)
 }
 
-func (c *Client) connect() error {
+// connect runs the RTSP handshake without holding c.mu, so Codec and
+// Disconnect don't wait on an unreachable camera, then publishes the client
+// if the session is still wanted
+func (c *Client) connect(session uint64) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	// Disconnect, Close or another Connect since this session started
+	if c.stopped || c.session != session {
+		c.mu.Unlock()
+		return fmt.Errorf("session ended")
+	}
+	c.connecting.Add(1)
+	c.mu.Unlock()
+	defer c.connecting.Done()
 
+	client, codec, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.stopped || c.session != session {
+		c.mu.Unlock()
+		client.Close()
+		return fmt.Errorf("session ended")
+	}
+	c.client = client
+	c.codec = codec
+	c.mu.Unlock()
+	log.Printf("RTSP: Connected and playing (%s)", c.cfg.Transport)
+
+	// Start reconnection monitor
+	go c.monitorConnection(client, session)
+
+	return nil
+}
+
+// dial connects to the camera and starts playing its video track
+func (c *Client) dial() (*gortsplib.Client, string, error) {
 	client := &gortsplib.Client{
-		// Use TCP transport (interleaved)
-		Transport: func() *gortsplib.Transport {
-			t := gortsplib.TransportTCP
-			return &t
-		}(),
-		// Connection timeout
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Transport:    transport(c.cfg.Transport),
+		ReadTimeout:  c.cfg.ReadTimeout,
+		WriteTimeout: c.cfg.WriteTimeout,
+		UserAgent:    c.cfg.UserAgent,
 		// Callback when connection is closed
 		OnDecodeError: func(err error) {
 			log.Printf("RTSP: Decode error: %v", err)
 		},
-	}
-
-	// Parse URL
-	u, err := base.ParseURL(c.url)
-	if err != nil {
-		return err
+		OnTransportSwitch: func(err error) {
+			log.Printf("RTSP: %v", err)
+		},
 	}
 
 	// Connect to server
-	err = client.Start(u.Scheme, u.Host)
+	u := c.url
+	err := client.Start(u.Scheme, u.Host)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
 	// Get session description
 	desc, _, err := client.Describe(u)
 	if err != nil {
 		client.Close()
-		return err
+		return nil, "", err
 	}
 
 	// Find H264 or H265 video format
@@ -115,34 +199,29 @@ func (c *Client) connect() error {
 
 	if videoFormat == nil {
 		client.Close()
-		return err
+		return nil, "", fmt.Errorf("no video track in %s", RedactURL(c.cfg.URL))
 	}
 
 	// Setup the video track
 	_, err = client.Setup(desc.BaseURL, videoMedia, 0, 0)
 	if err != nil {
 		client.Close()
-		return err
+		return nil, "", err
 	}
 
 	// Set callback to receive RTP packets
 	client.OnPacketRTPAny(func(media *description.Media, forma format.Format, pkt *rtp.Packet) {
-		// Serialize RTP packet to bytes
-		buf, err := pkt.Marshal()
+		// Serialize RTP packet into a pooled buffer
+		packet, err := video.MarshalPacket(pkt)
 		if err != nil {
 			return
 		}
 
-		// Copy the packet data
-		packet := make([]byte, len(buf))
-		copy(packet, buf)
-
+		// The server drains the channel into its ring buffer, so waiting
+		// here only holds up the camera's connection briefly
 		select {
 		case c.rtpChan <- packet:
 		case <-c.stopCh:
-			return
-		default:
-			// Drop packet if channel full
 		}
 	})
 
@@ -150,36 +229,36 @@ func (c *Client) connect() error {
 	_, err = client.Play(nil)
 	if err != nil {
 		client.Close()
-		return err
+		return nil, "", err
 	}
 
-	c.client = client
-	log.Printf("RTSP: Connected and playing")
-
-	// Start reconnection monitor
-	go c.monitorConnection()
-
-	return nil
+	switch videoFormat.(type) {
+	case *format.H264:
+		return client, video.CodecH264, nil
+	case *format.H265:
+		return client, video.CodecH265, nil
+	}
+	return client, videoFormat.Codec(), nil
 }
 
-// monitorConnection watches for disconnection and reconnects
-func (c *Client) monitorConnection() {
+// active reports whether the session is still wanted
+func (c *Client) active(session uint64) bool {
 	c.mu.Lock()
-	client := c.client
-	c.mu.Unlock()
-
-	if client == nil {
-		return
-	}
+	defer c.mu.Unlock()
+	return !c.stopped && c.session == session
+}
 
+// monitorConnection watches for disconnection and reconnects
+func (c *Client) monitorConnection(client *gortsplib.Client, session uint64) {
 	// Wait for client to close
 	err := client.Wait()
 
-	select {
-	case <-c.stopCh:
+	if !c.active(session) {
 		return
-	default:
 	}
+	c.mu.Lock()
+	c.client = nil
+	c.mu.Unlock()
 
 	if err != nil {
 		log.Printf("RTSP: Connection lost: %v", err)
@@ -187,23 +266,18 @@ func (c *Client) monitorConnection() {
 
 	// Reconnect with exponential backoff
 	for attempt := 1; ; attempt++ {
-		select {
-		case <-c.stopCh:
-			return
-		default:
-		}
-
 		delay := min(time.Duration(1<<uint(attempt-1))*time.Second, 30*time.Second)
 		log.Printf("RTSP: Reconnect attempt %d in %v", attempt, delay)
-		time.Sleep(delay)
-
 		select {
 		case <-c.stopCh:
 			return
-		default:
+		case <-time.After(delay):
 		}
 
-		if err := c.connect(); err != nil {
+		if !c.active(session) {
+			return
+		}
+		if err := c.connect(session); err != nil {
 			log.Printf("RTSP: Reconnect failed: %v", err)
 			continue
 		}
@@ -213,11 +287,60 @@ func (c *Client) monitorConnection() {
 	}
 }
 
+// transport maps a Config transport to gortsplib's; nil lets gortsplib try
+// UDP first and switch to TCP
+func transport(name string) *gortsplib.Transport {
+	var t gortsplib.Transport
+	switch name {
+	case TransportUDP:
+		t = gortsplib.TransportUDP
+	case TransportMulticast:
+		t = gortsplib.TransportUDPMulticast
+	case TransportTCP:
+		t = gortsplib.TransportTCP
+	default:
+		return nil
+	}
+	return &t
+}
+
+// RedactURL removes the password from an RTSP URL for display
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// Codec returns the codec of the video track, or "" before Connect
+func (c *Client) Codec() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.codec
+}
+
 // RTPChannel returns the channel for receiving RTP packets
 func (c *Client) RTPChannel() <-chan []byte {
 	return c.rtpChan
 }
 
+// Disconnect ends the RTSP session, and any reconnect attempts, but keeps
+// the RTP channel open so that Connect can resume streaming
+func (c *Client) Disconnect() error {
+	c.mu.Lock()
+	c.session++
+	client := c.client
+	c.client = nil
+	c.mu.Unlock()
+
+	if client != nil {
+		client.Close()
+		log.Printf("RTSP: Disconnected")
+	}
+	return nil
+}
+
 // Close closes the RTSP connection
 func (c *Client) Close() error {
 	c.mu.Lock()
@@ -230,10 +353,12 @@ func (c *Client) Close() error {
 	c.mu.Unlock()
 
 	close(c.stopCh)
-	close(c.rtpChan)
-
 	if client != nil {
 		client.Close()
 	}
+	// After every client, whose callback sends on the channel, has stopped;
+	// a handshake in progress closes its client when it finds c.stopped
+	c.connecting.Wait()
+	close(c.rtpChan)
 	return nil
 }
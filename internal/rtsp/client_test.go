@@ -0,0 +1,64 @@
+package rtsp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestConnectDoesNotBlock checks that Codec and Disconnect return while the
+// handshake waits on a camera that never answers
+func TestConnectDoesNotBlock(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // Accepts, then never answers
+		}
+	}()
+
+	c, err := NewClient(Config{URL: "rtsp://" + ln.Addr().String() + "/stream", ReadTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan error, 1)
+	go func() { connected <- c.Connect() }()
+	time.Sleep(100 * time.Millisecond) // Into the handshake
+
+	returns := func(name string, f func()) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			f()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(200 * time.Millisecond):
+			t.Errorf("%s blocked during the handshake", name)
+		}
+	}
+	returns("Codec", func() {
+		if codec := c.Codec(); codec != "" {
+			t.Errorf("codec %q before connecting", codec)
+		}
+	})
+	returns("Disconnect", func() { c.Disconnect() })
+
+	select {
+	case err := <-connected:
+		if err == nil {
+			t.Error("connected to a camera that never answered")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Connect didn't time out")
+	}
+	c.Close()
+}
@@ -0,0 +1,190 @@
+package rtsp
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+
+	"ptz-remote/internal/video"
+)
+
+// ServerConfig for an RTSP server that cameras publish (RECORD) to
+type ServerConfig struct {
+	Address string // TCP listen address, default ":8554"
+}
+
+// Server is a video source for cameras that push their stream with
+// ANNOUNCE/RECORD instead of serving it. Only one publisher is active at a
+// time; a new one replaces the previous. Readers (DESCRIBE/PLAY) are refused.
+type Server struct {
+	cfg     ServerConfig
+	rtpChan chan []byte
+	stopCh  chan struct{}
+
+	mu        sync.Mutex
+	server    *gortsplib.Server
+	publisher *gortsplib.ServerSession
+	media     *description.Media
+	format    format.Format
+	codec     string
+	stopped   bool
+}
+
+// NewServer creates an RTSP record server; it listens once Connect is called
+func NewServer(cfg ServerConfig) (*Server, error) {
+	if cfg.Address == "" {
+		cfg.Address = ":8554"
+	}
+	return &Server{
+		cfg:     cfg,
+		rtpChan: make(chan []byte, 500),
+		stopCh:  make(chan struct{}),
+	}, nil
+}
+
+// Connect starts listening for publishers. The stream is interleaved over
+// TCP, since no UDP ports are opened.
+func (s *Server) Connect() error {
+	server := &gortsplib.Server{
+		Handler:     s,
+		RTSPAddress: s.cfg.Address,
+	}
+	if err := server.Start(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+
+	log.Printf("RTSP: Waiting for a camera to publish on %s", s.cfg.Address)
+	return nil
+}
+
+// OnAnnounce accepts a publisher whose description has an H.264 or H.265 track
+func (s *Server) OnAnnounce(ctx *gortsplib.ServerHandlerOnAnnounceCtx) (*base.Response, error) {
+	medi, forma, codec := findVideo(ctx.Description)
+	if medi == nil {
+		return &base.Response{StatusCode: base.StatusBadRequest},
+			fmt.Errorf("no H.264 or H.265 track in announced stream")
+	}
+
+	s.mu.Lock()
+	previous := s.publisher
+	s.publisher = ctx.Session
+	s.media = medi
+	s.format = forma
+	s.codec = codec
+	s.mu.Unlock()
+
+	if previous != nil && previous != ctx.Session {
+		log.Printf("RTSP: New publisher from %s replaces the previous one", ctx.Conn.NetConn().RemoteAddr())
+		previous.Close()
+	}
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+// OnSetup accepts the publisher's SETUP requests
+func (s *Server) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	s.mu.Lock()
+	publisher := s.publisher
+	s.mu.Unlock()
+
+	if ctx.Session != publisher {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil, nil
+	}
+	return &base.Response{StatusCode: base.StatusOK}, nil, nil
+}
+
+// OnRecord starts forwarding the publisher's video RTP packets
+func (s *Server) OnRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.Response, error) {
+	s.mu.Lock()
+	medi, forma := s.media, s.format
+	publisher := s.publisher
+	s.mu.Unlock()
+
+	if ctx.Session != publisher || medi == nil {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil
+	}
+
+	ctx.Session.OnPacketRTP(medi, forma, func(pkt *rtp.Packet) {
+		buf, err := video.MarshalPacket(pkt)
+		if err != nil {
+			return
+		}
+
+		select {
+		case s.rtpChan <- buf:
+		case <-s.stopCh:
+		default:
+			// Drop packet if channel full
+		}
+	})
+
+	log.Printf("RTSP: Camera at %s is publishing (%s)", ctx.Conn.NetConn().RemoteAddr(), s.Codec())
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+// OnSessionClose forgets the publisher when its session ends
+func (s *Server) OnSessionClose(ctx *gortsplib.ServerHandlerOnSessionCloseCtx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ctx.Session == s.publisher {
+		s.publisher = nil
+		s.media, s.format = nil, nil
+		log.Printf("RTSP: Publisher disconnected: %v", ctx.Error)
+	}
+}
+
+// findVideo returns the first H.264 or H.265 format of a description
+func findVideo(desc *description.Session) (*description.Media, format.Format, string) {
+	for _, media := range desc.Medias {
+		for _, forma := range media.Formats {
+			switch forma.(type) {
+			case *format.H264:
+				return media, forma, video.CodecH264
+			case *format.H265:
+				return media, forma, video.CodecH265
+			}
+		}
+	}
+	return nil, nil, ""
+}
+
+// Codec returns the codec of the current or last publisher, or "" before one connects
+func (s *Server) Codec() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codec
+}
+
+// RTPChannel returns the channel for receiving RTP packets
+func (s *Server) RTPChannel() <-chan []byte {
+	return s.rtpChan
+}
+
+// Close stops the server and disconnects the publisher
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	server := s.server
+	s.mu.Unlock()
+
+	close(s.stopCh)
+	if server != nil {
+		server.Close()
+	}
+	close(s.rtpChan)
+	return nil
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package serial
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"ptz-remote/internal/serial/serialtest"
+)
+
+func readN(t *testing.T, f *os.File, n int) []byte {
+	t.Helper()
+	f.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, n)
+	for got := 0; got < n; {
+		k, err := f.Read(buf[got:])
+		if err != nil {
+			t.Fatalf("read after %d of %d bytes: %v", got, n, err)
+		}
+		got += k
+	}
+	return buf
+}
+
+func TestOpenRaw(t *testing.T) {
+	master, path := serialtest.OpenPTY(t)
+	port, err := Open(path, 9600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// Bytes a cooked terminal would translate or act on: CR, LF, ^C, ^S,
+	// ^Q, DEL, and the VISCA terminator
+	data := []byte{0x81, 0x0D, 0x0A, 0x03, 0x13, 0x11, 0x7F, 0x00, 0xFF}
+
+	if _, err := master.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := readN(t, port, len(data)); !bytes.Equal(got, data) {
+		t.Errorf("port read % X, want % X", got, data)
+	}
+
+	if _, err := port.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := readN(t, master, len(data)); !bytes.Equal(got, data) {
+		t.Errorf("device read % X, want % X", got, data)
+	}
+}
+
+func TestOpenReadDeadline(t *testing.T) {
+	_, path := serialtest.OpenPTY(t)
+	port, err := Open(path, 9600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	port.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := port.Read(make([]byte, 1)); !os.IsTimeout(err) {
+		t.Errorf("read error %v, want a timeout", err)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	_, path := serialtest.OpenPTY(t)
+	tests := []struct {
+		name   string
+		device string
+		baud   int
+		want   string
+	}{
+		{"unsupported baud", path, 12345, "unsupported baud rate"},
+		{"not a tty", "/dev/null", 9600, "not a serial device"},
+		{"missing", "/dev/does-not-exist", 9600, "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, err := Open(tt.device, tt.baud)
+			if err == nil {
+				port.Close()
+				t.Fatal("no error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+// Package serialtest provides pseudo-terminal stand-ins for serial ports in tests.
+package serialtest
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenPTY opens a pseudo-terminal pair, returning the master side (the
+// device end) and the slave's path, which stands in for a serial port.
+// The test is skipped when the system has no pty support.
+func OpenPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Skipf("no pty support: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	rc, err := m.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	var ioctlErr error
+	rc.Control(func(fd uintptr) {
+		if ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ioctlErr == nil {
+			n, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+		}
+	})
+	if ioctlErr != nil {
+		t.Skipf("no pty support: %v", ioctlErr)
+	}
+	return m, fmt.Sprintf("/dev/pts/%d", n)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+
+	"ptz-remote/internal/protocol"
+)
+
+// handleDataChannelMessage handles a message from the client's PTZ data
+// channel. It carries the same JSON messages as the WebSocket, but only
+// ptz_command and ptz_stop.
+func (c *Client) handleDataChannelMessage(data []byte) {
+	var msg protocol.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+
+	switch msg.Type {
+	case protocol.TypePTZCommand:
+		var payload protocol.PTZCommandPayload
+		if err := msg.ParsePayload(&payload); err != nil {
+			return
+		}
+		c.handlePTZCommand(payload)
+
+	case protocol.TypePTZStop:
+		var payload protocol.PTZStopPayload
+		msg.ParsePayload(&payload)
+		c.handlePTZStop(payload)
+
+	default:
+		log.Printf("Ignoring %s message on the PTZ data channel", msg.Type)
+	}
+}
+
+func (c *Client) handlePTZStop(req protocol.PTZStopPayload) {
+	if c.server.ptzCtrl == nil || !c.freshPTZ(req.Seq) {
+		return
+	}
+	if err := c.server.ptzCtrl.Stop(); err != nil {
+		log.Printf("Failed to stop PTZ: %v", err)
+	}
+}
+
+// freshPTZ reports whether a PTZ message is newer than the last one
+// applied. The data channel is unordered, and a stop is sent on both paths,
+// so a message may arrive late or twice. Messages without seq always apply.
+func (c *Client) freshPTZ(seq uint32) bool {
+	if seq == 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq <= c.ptzSeq {
+		return false
+	}
+	c.ptzSeq = seq
+	return true
+}
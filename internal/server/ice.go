@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"ptz-remote/internal/protocol"
+	"ptz-remote/internal/turn"
+	"ptz-remote/internal/webrtc"
+)
+
+// startICENetwork opens the UDP (and TCP) port shared by all sessions, if
+// configured. Without it each session listens on its own UDP port.
+func (s *Server) startICENetwork() {
+	if s.cfg.ICEUDPPort == 0 {
+		if s.cfg.ICETCPPort != 0 {
+			log.Printf("Warning: ICE-TCP needs a shared UDP port (-ice-udp-port), not enabled")
+		}
+		return
+	}
+	n, err := webrtc.NewNetwork(s.cfg.ICEUDPPort, s.cfg.ICETCPPort)
+	if err != nil {
+		log.Printf("Warning: Failed to open ICE ports, using a port per session: %v", err)
+		return
+	}
+	s.iceNet = n
+}
+
+// startTURN starts the embedded TURN server if configured. Its relay
+// address is -turn-ip, or the first ICE IP.
+func (s *Server) startTURN() {
+	if s.cfg.TURNListen == "" {
+		return
+	}
+	publicIP := s.cfg.TURNPublicIP
+	if publicIP == "" && s.cfg.ICEIPs != "" {
+		publicIP = strings.TrimSpace(strings.Split(s.cfg.ICEIPs, ",")[0])
+	}
+	if publicIP == "" {
+		log.Printf("Warning: TURN server needs a public IP (-turn-ip or -ice-ips), not started")
+		return
+	}
+	t, err := turn.NewServer(turn.Config{
+		Address:  s.cfg.TURNListen,
+		PublicIP: publicIP,
+		Secret:   s.cfg.TURNSecret,
+		TTL:      s.cfg.TURNTTL,
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to start TURN server: %v", err)
+		return
+	}
+	s.turn = t
+}
+
+// iceServers returns the configured STUN/TURN servers. The credentials
+// apply to the TURN URLs.
+func (s *Server) iceServers() []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	for _, url := range s.cfg.ICEServers {
+		server := webrtc.ICEServer{URLs: []string{url}}
+		if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+			server.Username, server.Credential = s.cfg.ICEUsername, s.cfg.ICECredential
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// webrtcConfig returns the configuration for a new session. The server
+// doesn't use the embedded TURN server; clients relay to it.
+func (s *Server) webrtcConfig() webrtc.Config {
+	return webrtc.Config{
+		ICEServers: s.iceServers(),
+		StaticIPs:  s.cfg.ICEIPs,
+		Network:    s.iceNet,
+		PortMin:    s.cfg.ICEPortMin,
+		PortMax:    s.cfg.ICEPortMax,
+	}
+}
+
+// clientICEServers returns the ICE servers for clients: the configured
+// ones, then the embedded TURN server with new credentials
+func (s *Server) clientICEServers() []protocol.ICEServer {
+	var servers []protocol.ICEServer
+	for _, server := range s.iceServers() {
+		servers = append(servers, protocol.ICEServer(server))
+	}
+	if s.turn != nil {
+		username, password := s.turn.Credentials()
+		servers = append(servers, protocol.ICEServer{
+			URLs:       s.turn.URLs(),
+			Username:   username,
+			Credential: password,
+		})
+	}
+	return servers
+}
+
+// setICELinks adds the ICE servers to a WHEP response as Link headers
+// (RFC 9725 section 4.6)
+func (s *Server) setICELinks(h http.Header) {
+	for _, server := range s.clientICEServers() {
+		for _, url := range server.URLs {
+			link := fmt.Sprintf("<%s>; rel=\"ice-server\"", url)
+			if server.Username != "" {
+				link += fmt.Sprintf("; username=%q; credential=%q; credential-type=\"password\"",
+					server.Username, server.Credential)
+			}
+			h.Add("Link", link)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const mjpegBoundary = "mjpegframe"
+
+// mjpegBroadcaster fans JPEG frames out to HTTP multipart subscribers
+type mjpegBroadcaster struct {
+	mu      sync.Mutex
+	running bool
+	subs    map[chan []byte]bool
+}
+
+// run distributes frames until the channel is closed
+func (b *mjpegBroadcaster) run(frames <-chan []byte) {
+	b.mu.Lock()
+	b.running = true
+	b.mu.Unlock()
+
+	for frame := range frames {
+		b.mu.Lock()
+		for sub := range b.subs {
+			// Non-blocking send; slow viewers skip frames
+			select {
+			case sub <- frame:
+			default:
+			}
+		}
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	b.running = false
+	for sub := range b.subs {
+		close(sub)
+	}
+	b.subs = nil
+	b.mu.Unlock()
+}
+
+// subscribe registers a frame channel, or returns nil without an MJPEG source
+func (b *mjpegBroadcaster) subscribe() chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running {
+		return nil
+	}
+	if b.subs == nil {
+		b.subs = make(map[chan []byte]bool)
+	}
+	sub := make(chan []byte, 2)
+	b.subs[sub] = true
+	return sub
+}
+
+func (b *mjpegBroadcaster) unsubscribe(sub chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub)
+	}
+}
+
+// handleMJPEG streams MJPEG video sources as multipart/x-mixed-replace,
+// which browsers display directly in an <img>
+func (s *Server) handleMJPEG(w http.ResponseWriter, r *http.Request) {
+	sub := s.mjpeg.subscribe()
+	if sub == nil {
+		http.Error(w, "No MJPEG video source", http.StatusNotFound)
+		return
+	}
+	defer s.mjpeg.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-sub:
+			if !ok {
+				return
+			}
+			_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frame))
+			if err == nil {
+				_, err = w.Write(frame)
+			}
+			if err == nil {
+				_, err = w.Write([]byte("\r\n"))
+			}
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
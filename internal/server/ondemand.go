@@ -0,0 +1,146 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/protocol"
+	"ptz-remote/internal/video"
+)
+
+// onDemandRetry is the delay between attempts to start the video while
+// viewers are waiting
+const onDemandRetry = 5 * time.Second
+
+// onDemand connects the RTSP streams when the first viewer arrives and
+// disconnects them once there have been no viewers for the idle timeout
+type onDemand struct {
+	server      *Server
+	idleTimeout time.Duration
+	wake        chan struct{}
+	stop        chan struct{}
+
+	mu        sync.Mutex
+	viewers   int
+	idleSince time.Time
+	state     string // protocol.VideoState*
+}
+
+func newOnDemand(s *Server, idleTimeout time.Duration) *onDemand {
+	return &onDemand{
+		server:      s,
+		idleTimeout: idleTimeout,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		state:       protocol.VideoStateIdle,
+	}
+}
+
+// addViewer counts a WebRTC session receiving video
+func (d *onDemand) addViewer() {
+	d.mu.Lock()
+	d.viewers++
+	d.mu.Unlock()
+	d.signal()
+}
+
+// removeViewer is called when a viewer's session ends
+func (d *onDemand) removeViewer() {
+	d.mu.Lock()
+	d.viewers--
+	if d.viewers == 0 {
+		d.idleSince = time.Now()
+	}
+	d.mu.Unlock()
+	d.signal()
+}
+
+func (d *onDemand) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *onDemand) videoState() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// setState records the state and sends the new status to all clients
+func (d *onDemand) setState(state string) {
+	d.mu.Lock()
+	changed := d.state != state
+	d.state = state
+	d.mu.Unlock()
+	if changed {
+		d.server.broadcastMessage(protocol.TypeStatus, d.server.status())
+	}
+}
+
+// run starts and stops the streams as viewers come and go, until Stop
+func (d *onDemand) run() {
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-d.wake:
+		case <-timer:
+		}
+		timer = nil
+
+		d.mu.Lock()
+		viewers, idleFor, state := d.viewers, time.Since(d.idleSince), d.state
+		d.mu.Unlock()
+
+		switch {
+		case viewers > 0 && state != protocol.VideoStateStreaming:
+			if state == protocol.VideoStateIdle {
+				log.Printf("Starting RTSP video for %d viewer(s)", viewers)
+			}
+			d.setState(protocol.VideoStateStarting)
+			if err := d.connect(); err != nil {
+				log.Printf("Warning: Failed to start RTSP video: %v", err)
+				timer = time.After(onDemandRetry)
+				continue
+			}
+			d.setState(protocol.VideoStateStreaming)
+
+		case viewers == 0 && state != protocol.VideoStateIdle:
+			if wait := d.idleTimeout - idleFor; wait > 0 {
+				timer = time.After(wait)
+				continue
+			}
+			log.Printf("No viewers for %v, stopping RTSP video", d.idleTimeout)
+			d.disconnect()
+			d.setState(protocol.VideoStateIdle)
+		}
+	}
+}
+
+// connect connects the main stream, then the sub streams. A sub stream
+// that fails is logged; its viewers stay on the stream they have.
+func (d *onDemand) connect() error {
+	for i, st := range d.server.streams {
+		if err := st.source.Connect(); err != nil {
+			if i == 0 {
+				return err
+			}
+			log.Printf("Warning: Failed to connect to RTSP stream %s: %v", st.name, err)
+			continue
+		}
+		log.Printf("Connected to RTSP stream %s (%s)", st.name, st.source.Codec())
+	}
+	return nil
+}
+
+func (d *onDemand) disconnect() {
+	for _, st := range d.server.streams {
+		if dc, ok := st.source.(video.Disconnecter); ok {
+			dc.Disconnect()
+		}
+	}
+}
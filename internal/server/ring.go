@@ -0,0 +1,110 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"ptz-remote/internal/video"
+)
+
+// ringSize is the number of RTP packets buffered per stream, a few seconds
+// of video at typical bitrates
+const ringSize = 2048
+
+// rtpRing is a stream's shared packet buffer. broadcastRTP writes each
+// packet once; every client reads at its own cursor. A client that falls
+// more than ringSize packets behind skips ahead to a keyframe, so it loses
+// whole frames rather than random packets.
+type rtpRing struct {
+	mu      sync.RWMutex
+	slots   [ringSize]ringSlot
+	head    uint64 // Position of the next write
+	marker  bool   // The previous packet ended an access unit
+	skipped atomic.Int64
+}
+
+type ringSlot struct {
+	data     []byte
+	keyframe bool      // First packet of a keyframe access unit
+	received time.Time // When the packet came from the source
+}
+
+// ringCursor is a reader's position in a ring
+type ringCursor struct {
+	pos     uint64
+	waitKey bool // Fell behind with no keyframe buffered; skip until one arrives
+}
+
+// write copies a packet into the ring, reusing the slot's buffer
+func (r *rtpRing) write(packet []byte, received time.Time) {
+	var hdr rtp.Header
+	n, err := hdr.Unmarshal(packet)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	slot := &r.slots[r.head%ringSize]
+	slot.data = append(slot.data[:0], packet...)
+	slot.keyframe = (r.marker || r.head == 0) && video.IsH264Keyframe(packet[n:])
+	slot.received = received
+	r.marker = hdr.Marker
+	r.head++
+	r.mu.Unlock()
+}
+
+// cursor returns a cursor at the next packet to be written
+func (r *rtpRing) cursor() ringCursor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return ringCursor{pos: r.head}
+}
+
+// ringPacket is a packet read from a ring
+type ringPacket struct {
+	data     []byte
+	keyframe bool
+	received time.Time
+}
+
+// read appends the packet at the cursor to buf and advances the cursor. ok is
+// false when the reader has caught up. skipped counts the packets passed over
+// because the reader fell behind.
+func (r *rtpRing) read(c *ringCursor, buf []byte) (packet ringPacket, skipped int, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.head-c.pos > ringSize {
+		// Overwritten; resume at the oldest buffered keyframe
+		oldest := r.head - ringSize
+		skipped = int(oldest - c.pos)
+		c.pos, c.waitKey = r.head, true
+		for pos := oldest; pos < r.head; pos++ {
+			if r.slots[pos%ringSize].keyframe {
+				skipped += int(pos - oldest)
+				c.pos, c.waitKey = pos, false
+				break
+			}
+		}
+		if c.waitKey {
+			skipped += ringSize
+		}
+	}
+
+	for c.pos < r.head {
+		slot := &r.slots[c.pos%ringSize]
+		c.pos++
+		if c.waitKey && !slot.keyframe {
+			skipped++
+			continue
+		}
+		c.waitKey = false
+		r.skipped.Add(int64(skipped))
+		return ringPacket{append(buf, slot.data...), slot.keyframe, slot.received}, skipped, true
+	}
+	r.skipped.Add(int64(skipped))
+	return ringPacket{}, skipped, false
+}
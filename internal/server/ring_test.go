@@ -0,0 +1,158 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// testPacket returns a one-packet frame whose payload identifies seq; IDR
+// slices make keyframes
+func testPacket(t *testing.T, seq uint16, keyframe bool) []byte {
+	t.Helper()
+	nal := byte(0x41) // Non-IDR slice
+	if keyframe {
+		nal = 0x65
+	}
+	b, err := (&rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: seq, Marker: true},
+		Payload: []byte{nal, 0x88},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func seqOf(t *testing.T, b []byte) uint16 {
+	t.Helper()
+	var p rtp.Packet
+	if err := p.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	return p.SequenceNumber
+}
+
+func TestRingRead(t *testing.T) {
+	var r rtpRing
+	c := r.cursor()
+	for i := 0; i < 3; i++ {
+		r.write(testPacket(t, uint16(i), i == 0), time.Now())
+	}
+
+	for i := 0; i < 3; i++ {
+		p, skipped, ok := r.read(&c, nil)
+		if !ok || skipped != 0 {
+			t.Fatalf("read %d: ok=%v skipped=%d", i, ok, skipped)
+		}
+		if seq := seqOf(t, p.data); seq != uint16(i) {
+			t.Errorf("read %d: seq %d", i, seq)
+		}
+		if p.keyframe != (i == 0) {
+			t.Errorf("read %d: keyframe=%v", i, p.keyframe)
+		}
+	}
+	if _, _, ok := r.read(&c, nil); ok {
+		t.Error("read past the head")
+	}
+}
+
+func TestRingOverrun(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    uint64 // Initial ring position
+		written  int    // Packets written past the reader's cursor
+		keyEvery int    // Keyframe interval in packets; 0 for only the first
+		wantSeq  int    // Packet read next, -1 for none
+		skipped  int
+	}{
+		{"not overrun", 0, 100, 0, 0, 0},
+		{"exactly full", 0, ringSize, 0, 0, 0},
+		{"resumes at oldest keyframe", 0, ringSize + 250, 100, 300, 300},
+		{"keyframe at the oldest slot", 0, ringSize + 200, 100, 200, 200},
+		{"no keyframe buffered", 0, ringSize + 10, 0, -1, ringSize + 10},
+		{"position counter wraps", math.MaxUint64 - 99, ringSize + 250, 100, 300, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &rtpRing{head: tt.start, marker: true}
+			c := r.cursor()
+			for i := 0; i < tt.written; i++ {
+				key := i == 0 || (tt.keyEvery > 0 && i%tt.keyEvery == 0)
+				r.write(testPacket(t, uint16(i), key), time.Now())
+			}
+
+			p, skipped, ok := r.read(&c, nil)
+			if skipped != tt.skipped {
+				t.Errorf("skipped %d, want %d", skipped, tt.skipped)
+			}
+			if tt.wantSeq < 0 {
+				if ok {
+					t.Fatalf("read seq %d, want nothing until a keyframe", seqOf(t, p.data))
+				}
+			} else {
+				if !ok {
+					t.Fatal("nothing read")
+				}
+				if seq := seqOf(t, p.data); seq != uint16(tt.wantSeq) {
+					t.Errorf("read seq %d, want %d", seq, tt.wantSeq)
+				}
+			}
+			if got := r.skipped.Load(); got != int64(tt.skipped) {
+				t.Errorf("ring counted %d skipped, want %d", got, tt.skipped)
+			}
+
+			// The reader then follows the ring packet by packet
+			if ok {
+				r.write(testPacket(t, 0xFFFF, false), time.Now())
+				for {
+					p, skipped, ok = r.read(&c, nil)
+					if !ok {
+						t.Fatal("lost the last packet")
+					}
+					if skipped != 0 {
+						t.Fatalf("skipped %d after catching up", skipped)
+					}
+					if seqOf(t, p.data) == 0xFFFF {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRingWaitsForKeyframe(t *testing.T) {
+	var r rtpRing
+	c := r.cursor()
+	r.write(testPacket(t, 0, true), time.Now())
+	for i := 1; i <= ringSize; i++ {
+		r.write(testPacket(t, uint16(i), false), time.Now())
+	}
+	if _, skipped, ok := r.read(&c, nil); ok || skipped != ringSize+1 {
+		t.Fatalf("ok=%v skipped=%d, want the whole ring skipped", ok, skipped)
+	}
+
+	// Delta frames are passed over until the next keyframe
+	r.write(testPacket(t, 5000, false), time.Now())
+	if _, skipped, ok := r.read(&c, nil); ok || skipped != 1 {
+		t.Fatalf("ok=%v skipped=%d, want the delta frame skipped", ok, skipped)
+	}
+	r.write(testPacket(t, 5001, true), time.Now())
+	p, skipped, ok := r.read(&c, nil)
+	if !ok || skipped != 0 || !p.keyframe || seqOf(t, p.data) != 5001 {
+		t.Fatalf("ok=%v skipped=%d keyframe=%v, want the keyframe", ok, skipped, p.keyframe)
+	}
+}
+
+func TestRingMalformedPacket(t *testing.T) {
+	var r rtpRing
+	c := r.cursor()
+	r.write([]byte{0x80, 0x60}, time.Now()) // Shorter than an RTP header
+	r.write(nil, time.Now())
+	if _, _, ok := r.read(&c, nil); ok {
+		t.Error("malformed packet was buffered")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,47 +16,130 @@ import (
 	"github.com/gorilla/websocket"
 	pwebrtc "github.com/pion/webrtc/v3"
 
+	"ptz-remote/internal/httpcgi"
+	"ptz-remote/internal/onvif"
 	"ptz-remote/internal/panasonic"
+	"ptz-remote/internal/pelco"
+	"ptz-remote/internal/probe"
 	"ptz-remote/internal/protocol"
 	"ptz-remote/internal/ptz"
+	"ptz-remote/internal/rtmp"
 	"ptz-remote/internal/rtsp"
+	"ptz-remote/internal/srt"
+	"ptz-remote/internal/testpattern"
+	"ptz-remote/internal/turn"
+	"ptz-remote/internal/uvc"
+	"ptz-remote/internal/v4l2"
+	"ptz-remote/internal/video"
 	"ptz-remote/internal/visca"
 	"ptz-remote/internal/webrtc"
 )
 
+// powerWatchInterval is how often the controller's power state is checked
+// for changes to send to clients
+const powerWatchInterval = 2 * time.Second
+
 // Config for the server
 type Config struct {
-	ListenAddr       string
-	RTSPURL          string
-	VISCAAddress     string
-	VISCAProtocol    string // "udp" or "tcp"
-	PanasonicAddress string // Panasonic camera IP address
-	ICEIPs           string // Comma-separated list of static server IPs
+	ListenAddr         string
+	RTSPURL            string
+	RTSPSubURLs        []string      // Lower quality streams of the same camera, best first
+	RTSPTransport      string        // "tcp", "udp", "multicast" or "auto"
+	RTSPTimeout        time.Duration // RTSP read/write timeout
+	RTSPUserAgent      string
+	RTSPUser           string // RTSP credentials, instead of in the URL
+	RTSPPass           string
+	RTSPOnDemand       bool          // Connect RTSP only while clients are watching
+	RTSPIdleTimeout    time.Duration // On-demand: disconnect after this long without clients
+	VideoDevice        string        // V4L2 device node for a USB camera (instead of RTSP)
+	VideoFormat        string        // V4L2 capture format: "h264" or "mjpeg"
+	VideoWidth         int           // V4L2 capture width
+	VideoHeight        int           // V4L2 capture height
+	VideoFPS           int           // V4L2 capture frame rate
+	SRTListen          string        // UDP address to accept an SRT caller on
+	RTMPListen         string        // TCP address to accept an RTMP publish on
+	RTMPStreamKey      string        // Required RTMP stream name, if set
+	RTSPListen         string        // TCP address to accept an RTSP publish (RECORD) on
+	TestPattern        bool          // Serve a timestamped test pattern instead of a camera
+	VISCAAddress       string
+	VISCAProtocol      string // "udp", "tcp" or "serial"
+	VISCABaudRate      int    // Serial baud rate
+	VISCACameraAddress int    // Camera address on the VISCA bus (1-7)
+	PanasonicAddress   string // Panasonic camera IP address
+	PanasonicUser      string // Panasonic username (Basic/Digest auth)
+	PanasonicPass      string // Panasonic password
+	PanasonicHTTPS     bool   // Use HTTPS for Panasonic CGI
+	PanasonicCACert    string // PEM CA certificate for Panasonic HTTPS
+	PanasonicInsecure  bool   // Skip TLS verification for Panasonic HTTPS
+	PanasonicEventPort int    // TCP port for Panasonic update notifications (0 = disabled)
+	ONVIFAddress       string // ONVIF camera host[:port] or device service URL
+	ONVIFUser          string // ONVIF username (WS-UsernameToken)
+	ONVIFPass          string // ONVIF password
+	PelcoAddress       string // Pelco serial device or TCP serial server host:port
+	PelcoProtocol      string // "serial" or "tcp"
+	PelcoVariant       string // "d" or "p"
+	PelcoBaudRate      int    // Pelco serial baud rate
+	PelcoCameraAddress int    // Pelco receiver address (1-255)
+	CGIAddress         string // HTTP CGI camera address (host or host:port)
+	CGIVendor          string // HTTP CGI command map name, e.g. "ptzoptics"
+	CGIVendorsFile     string // JSON file with additional HTTP CGI command maps
+	CGIUser            string // HTTP CGI username (Basic/Digest auth)
+	CGIPass            string // HTTP CGI password
+	CGIHTTPS           bool   // Use HTTPS for HTTP CGI
+	CGICACert          string // PEM CA certificate for HTTP CGI HTTPS
+	CGIInsecure        bool   // Skip TLS verification for HTTP CGI HTTPS
+	UVCDevice          string // V4L2 device node for UVC pan/tilt/zoom controls
+	CameraHost         string // Auto-detect the control protocol of this camera
+	CameraUser         string // Username for auto-detected Panasonic/ONVIF cameras
+	CameraPass         string // Password for auto-detected Panasonic/ONVIF cameras
+	ICEIPs             string // Comma-separated list of static server IPs
+	ICEUDPPort         int    // Single UDP port for all WebRTC sessions (0 = a port per session)
+	ICETCPPort         int    // ICE-TCP port, with ICEUDPPort (0 = disabled)
+	ICEPortMin         uint16 // UDP port range for sessions without ICEUDPPort (0 = any)
+	ICEPortMax         uint16
+	ICEServers         []string // STUN/TURN server URLs, for the server and clients
+	ICEUsername        string   // Credentials for the TURN URLs in ICEServers
+	ICECredential      string
+	TURNListen         string        // Address for the embedded TURN server (UDP and TCP)
+	TURNPublicIP       string        // Relay address for the embedded TURN server
+	TURNSecret         string        // Shared secret for TURN credentials; random if empty
+	TURNTTL            time.Duration // TURN credential lifetime
 }
 
 // Server is the main PTZ remote server
 type Server struct {
 	cfg        Config
 	clients    map[*Client]bool
+	viewers    map[*viewer]bool // WebRTC sessions receiving video, guarded by clientsMu
 	clientsMu  sync.RWMutex
-	rtspClient *rtsp.Client
+	video      video.Source   // The main stream's source
+	streams    []*videoStream // Main stream first, then RTSP sub streams
+	videoProto string         // "rtsp", "v4l2", "srt", "rtmp", "rtsp-record" or "test"
+	demand     *onDemand      // Set for on-demand RTSP
+	whep       whepSessions
+	turn       *turn.Server    // Embedded TURN server, if enabled
+	iceNet     *webrtc.Network // Shared ICE ports, if configured
+	mjpeg      mjpegBroadcaster
 	ptzCtrl    ptz.Controller
+	camera     *protocol.CameraInfo // Set when the camera was auto-detected
 	upgrader   websocket.Upgrader
 	staticFS   fs.FS
 	httpServer *http.Server
 	shutdown   atomic.Bool
+	stopCh     chan struct{} // Closed by Stop
 }
 
 // Client represents a connected WebSocket client
 type Client struct {
-	conn    *websocket.Conn
-	server  *Server
-	webrtc  *webrtc.Session
-	send    chan []byte
-	rtpChan chan []byte // Per-client RTP channel
-	stopRTP chan struct{}
-	mu      sync.Mutex
-	closed  bool
+	conn   *websocket.Conn
+	server *Server
+	webrtc *webrtc.Session
+	viewer *viewer // Set once the WebRTC session is offered
+	send   chan []byte
+	mu     sync.Mutex
+	closed bool
+	ptzSeq uint32                // Seq of the latest PTZ message applied
+	events []protocol.StateEvent // WebRTC state changes for the next stats
 }
 
 // New creates a new server instance
@@ -67,9 +151,12 @@ func New(cfg Config, staticFS embed.FS) (*Server, error) {
 	}
 
 	s := &Server{
-		cfg:      cfg,
-		clients:  make(map[*Client]bool),
-		staticFS: webFS,
+		cfg:        cfg,
+		clients:    make(map[*Client]bool),
+		viewers:    make(map[*viewer]bool),
+		staticFS:   webFS,
+		videoProto: "rtsp",
+		stopCh:     make(chan struct{}),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -84,28 +171,90 @@ func New(cfg Config, staticFS embed.FS) (*Server, error) {
 
 // Start starts the server
 func (s *Server) Start() error {
-	// Connect to RTSP if configured
+	// Connect to the video source if configured: RTSP or V4L2, a listener
+	// for cameras that push with SRT, RTMP or RTSP RECORD, or the test pattern
+	var src video.Source
+	var err error
 	if s.cfg.RTSPURL != "" {
-		client, err := rtsp.NewClient(s.cfg.RTSPURL)
+		s.videoProto = "rtsp"
+		src, err = rtsp.NewClient(s.rtspConfig(s.cfg.RTSPURL))
+	} else if s.cfg.SRTListen != "" {
+		s.videoProto = "srt"
+		src, err = srt.NewSource(srt.Config{Address: s.cfg.SRTListen})
+	} else if s.cfg.RTMPListen != "" {
+		s.videoProto = "rtmp"
+		src, err = rtmp.NewSource(rtmp.Config{Address: s.cfg.RTMPListen, StreamKey: s.cfg.RTMPStreamKey})
+	} else if s.cfg.RTSPListen != "" {
+		s.videoProto = "rtsp-record"
+		src, err = rtsp.NewServer(rtsp.ServerConfig{Address: s.cfg.RTSPListen})
+	} else if s.cfg.VideoDevice != "" {
+		s.videoProto = "v4l2"
+		src, err = v4l2.NewSource(v4l2.Config{
+			Device: s.cfg.VideoDevice,
+			Format: s.cfg.VideoFormat,
+			Width:  s.cfg.VideoWidth,
+			Height: s.cfg.VideoHeight,
+			FPS:    s.cfg.VideoFPS,
+		})
+	} else if s.cfg.TestPattern {
+		s.videoProto = "test"
+		src, err = testpattern.NewSource(testpattern.Config{FPS: s.cfg.VideoFPS})
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to create %s video source: %v", s.videoProto, err)
+	} else if src != nil {
+		// On demand, RTSP connects when the first client arrives
+		onDemand := s.videoProto == "rtsp" && s.cfg.RTSPOnDemand
+		if !onDemand {
+			err = src.Connect()
+		}
 		if err != nil {
-			log.Printf("Warning: Failed to create RTSP client: %v", err)
+			log.Printf("Warning: Failed to connect to %s video source: %v", s.videoProto, err)
 		} else {
-			if err := client.Connect(); err != nil {
-				log.Printf("Warning: Failed to connect to RTSP: %v", err)
+			s.video = src
+			s.streams = []*videoStream{{name: "main", source: src}}
+			if onDemand {
+				log.Printf("RTSP video on demand, stopped after %v without clients", s.cfg.RTSPIdleTimeout)
+			} else if codec := src.Codec(); codec != "" {
+				log.Printf("Connected to %s video source (%s)", s.videoProto, codec)
 			} else {
-				s.rtspClient = client
-				log.Printf("Connected to RTSP: %s", s.cfg.RTSPURL)
-				// Start broadcasting RTP packets to all clients
-				go s.broadcastRTP()
+				log.Printf("Listening for %s video", s.videoProto)
+			}
+			if s.videoProto == "rtsp" {
+				s.addSubStreams(!onDemand)
+			}
+			if onDemand {
+				s.demand = newOnDemand(s, s.cfg.RTSPIdleTimeout)
+				go s.demand.run()
+			}
+			// Start broadcasting RTP packets (or MJPEG frames) to all clients
+			for i := range s.streams {
+				go s.broadcastRTP(i)
+			}
+			if fs, ok := src.(video.FrameSource); ok {
+				go s.mjpeg.run(fs.Frames())
 			}
 		}
 	}
 
-	// Connect to PTZ controller if configured (VISCA or Panasonic)
+	// Detect the camera's control protocol unless one was given explicitly
+	if s.cfg.CameraHost != "" && !s.controllerConfigured() {
+		s.autodetect()
+	}
+
+	// A USB camera is controlled through its own UVC controls unless
+	// another controller was configured
+	if s.cfg.UVCDevice == "" && s.cfg.VideoDevice != "" && !s.controllerConfigured() {
+		s.cfg.UVCDevice = s.cfg.VideoDevice
+	}
+
+	// Connect to PTZ controller if configured (VISCA, Panasonic, ONVIF, Pelco, HTTP CGI or UVC)
 	if s.cfg.VISCAAddress != "" {
 		ctrl, err := visca.NewController(visca.Config{
-			Address:  s.cfg.VISCAAddress,
-			Protocol: s.cfg.VISCAProtocol,
+			Address:       s.cfg.VISCAAddress,
+			Protocol:      s.cfg.VISCAProtocol,
+			BaudRate:      s.cfg.VISCABaudRate,
+			CameraAddress: s.cfg.VISCACameraAddress,
 		})
 		if err != nil {
 			log.Printf("Warning: Failed to create VISCA controller: %v", err)
@@ -115,19 +264,98 @@ func (s *Server) Start() error {
 		}
 	} else if s.cfg.PanasonicAddress != "" {
 		ctrl, err := panasonic.NewController(panasonic.Config{
-			Address: s.cfg.PanasonicAddress,
+			Address:            s.cfg.PanasonicAddress,
+			Username:           s.cfg.PanasonicUser,
+			Password:           s.cfg.PanasonicPass,
+			HTTPS:              s.cfg.PanasonicHTTPS,
+			CACertFile:         s.cfg.PanasonicCACert,
+			InsecureSkipVerify: s.cfg.PanasonicInsecure,
+			EventPort:          s.cfg.PanasonicEventPort,
 		})
 		if err != nil {
 			log.Printf("Warning: Failed to create Panasonic controller: %v", err)
 		} else {
 			s.ptzCtrl = ctrl
 			log.Printf("Connected to Panasonic: %s", s.cfg.PanasonicAddress)
+			if ctrl.Events() != nil {
+				go s.forwardPanasonicEvents(ctrl.Events())
+			}
+		}
+	} else if s.cfg.ONVIFAddress != "" {
+		ctrl, err := onvif.NewController(onvif.Config{
+			Address:  s.cfg.ONVIFAddress,
+			Username: s.cfg.ONVIFUser,
+			Password: s.cfg.ONVIFPass,
+		})
+		if err != nil {
+			log.Printf("Warning: Failed to create ONVIF controller: %v", err)
+		} else {
+			s.ptzCtrl = ctrl
+			log.Printf("Connected to ONVIF: %s", s.cfg.ONVIFAddress)
+		}
+	} else if s.cfg.PelcoAddress != "" {
+		ctrl, err := pelco.NewController(pelco.Config{
+			Address:       s.cfg.PelcoAddress,
+			Protocol:      s.cfg.PelcoProtocol,
+			Variant:       s.cfg.PelcoVariant,
+			BaudRate:      s.cfg.PelcoBaudRate,
+			CameraAddress: s.cfg.PelcoCameraAddress,
+		})
+		if err != nil {
+			log.Printf("Warning: Failed to create Pelco controller: %v", err)
+		} else {
+			s.ptzCtrl = ctrl
+			log.Printf("Connected to Pelco-%s: %s", strings.ToUpper(s.cfg.PelcoVariant), s.cfg.PelcoAddress)
+		}
+	} else if s.cfg.CGIAddress != "" {
+		if s.cfg.CGIVendorsFile != "" {
+			if err := httpcgi.LoadVendors(s.cfg.CGIVendorsFile); err != nil {
+				log.Printf("Warning: Failed to load HTTP CGI vendors: %v", err)
+			}
+		}
+		ctrl, err := httpcgi.NewController(httpcgi.Config{
+			Address:            s.cfg.CGIAddress,
+			Vendor:             s.cfg.CGIVendor,
+			Username:           s.cfg.CGIUser,
+			Password:           s.cfg.CGIPass,
+			HTTPS:              s.cfg.CGIHTTPS,
+			CACertFile:         s.cfg.CGICACert,
+			InsecureSkipVerify: s.cfg.CGIInsecure,
+		})
+		if err != nil {
+			log.Printf("Warning: Failed to create HTTP CGI controller: %v", err)
+		} else {
+			s.ptzCtrl = ctrl
+			log.Printf("Connected to %s HTTP CGI: %s", s.cfg.CGIVendor, s.cfg.CGIAddress)
+		}
+	} else if s.cfg.UVCDevice != "" {
+		ctrl, err := uvc.NewController(uvc.Config{Device: s.cfg.UVCDevice})
+		if err != nil {
+			log.Printf("Warning: Failed to create UVC controller: %v", err)
+		} else {
+			s.ptzCtrl = ctrl
+			log.Printf("Connected to UVC controls: %s", s.cfg.UVCDevice)
 		}
 	}
 
+	if s.camera != nil && s.ptzCtrl != nil {
+		s.camera.Features = probe.Features(s.ptzCtrl.Capabilities())
+	}
+
+	if p, ok := s.ptzCtrl.(ptz.Power); ok {
+		go s.watchPower(p)
+	}
+
+	s.startICENetwork()
+	s.startTURN()
+
 	// Set up HTTP routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/video.mjpeg", s.handleMJPEG)
+	mux.HandleFunc("POST /whep", s.handleWHEPOffer)
+	mux.HandleFunc("PATCH /whep/{id}", s.handleWHEPPatch)
+	mux.HandleFunc("DELETE /whep/{id}", s.handleWHEPDelete)
 	mux.Handle("/", http.FileServer(http.FS(s.staticFS)))
 
 	s.httpServer = &http.Server{
@@ -139,21 +367,109 @@ func (s *Server) Start() error {
 	return s.httpServer.ListenAndServe()
 }
 
-// broadcastRTP reads from RTSP and sends to all connected clients
-func (s *Server) broadcastRTP() {
-	rtpChan := s.rtspClient.RTPChannel()
-
-	for packet := range rtpChan {
-		s.clientsMu.RLock()
-		for client := range s.clients {
-			// Non-blocking send to each client's RTP channel
-			select {
-			case client.rtpChan <- packet:
-			default:
-				// Client's buffer full, drop packet for this client
+// addSubStreams adds the RTSP sub streams with the main stream's settings,
+// connecting them unless the video is on demand. A sub stream that fails is
+// left out.
+func (s *Server) addSubStreams(connect bool) {
+	for i, subURL := range s.cfg.RTSPSubURLs {
+		name := "sub"
+		if i > 0 {
+			name = fmt.Sprintf("sub%d", i+1)
+		}
+		src, err := rtsp.NewClient(s.rtspConfig(subURL))
+		if err == nil && connect {
+			err = src.Connect()
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to connect to RTSP stream %s: %v", name, err)
+			continue
+		}
+		s.streams = append(s.streams, &videoStream{name: name, source: src})
+		if connect {
+			log.Printf("Connected to RTSP stream %s (%s)", name, src.Codec())
+		}
+	}
+}
+
+// rtspConfig returns the RTSP client settings for a stream URL
+func (s *Server) rtspConfig(url string) rtsp.Config {
+	return rtsp.Config{
+		URL:          url,
+		Transport:    s.cfg.RTSPTransport,
+		ReadTimeout:  s.cfg.RTSPTimeout,
+		WriteTimeout: s.cfg.RTSPTimeout,
+		UserAgent:    s.cfg.RTSPUserAgent,
+		Username:     s.cfg.RTSPUser,
+		Password:     s.cfg.RTSPPass,
+	}
+}
+
+// forwardPanasonicEvents relays camera notifications to all connected clients
+func (s *Server) forwardPanasonicEvents(events <-chan panasonic.Event) {
+	for ev := range events {
+		var payload protocol.CameraEventPayload
+		switch ev := ev.(type) {
+		case panasonic.TallyEvent:
+			payload = protocol.CameraEventPayload{
+				Event: protocol.EventTally,
+				Data:  protocol.TallyEventData{Color: ev.Color, On: ev.On},
+			}
+		case panasonic.PowerEvent:
+			payload = protocol.CameraEventPayload{
+				Event: protocol.EventPower,
+				Data:  protocol.PowerEventData{State: ev.State},
+			}
+		case panasonic.PresetCompleteEvent:
+			payload = protocol.CameraEventPayload{
+				Event: protocol.EventPresetComplete,
+				Data:  protocol.PresetCompleteEventData{PresetNumber: ev.Preset},
 			}
+		case panasonic.PositionEvent:
+			payload = protocol.CameraEventPayload{
+				Event: protocol.EventPosition,
+				Data:  protocol.PositionEventData{Pan: ev.Pan, Tilt: ev.Tilt},
+			}
+		case panasonic.LensEvent:
+			payload = protocol.CameraEventPayload{
+				Event: protocol.EventLens,
+				Data:  protocol.LensEventData{Zoom: ev.Zoom, Focus: ev.Focus, Iris: ev.Iris},
+			}
+		default:
+			continue
+		}
+		s.broadcastMessage(protocol.TypeCameraEvent, payload)
+		if payload.Event == protocol.EventPower {
+			s.broadcastMessage(protocol.TypeStatus, s.status())
 		}
-		s.clientsMu.RUnlock()
+	}
+}
+
+// watchPower sends every client a status update when the controller's
+// power state changes, e.g. from its periodic inquiry
+func (s *Server) watchPower(p ptz.Power) {
+	ticker := time.NewTicker(powerWatchInterval)
+	defer ticker.Stop()
+
+	last := p.PowerState()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+		if state := p.PowerState(); state != last {
+			last = state
+			s.broadcastMessage(protocol.TypeStatus, s.status())
+		}
+	}
+}
+
+// broadcastMessage sends a message to all connected clients
+func (s *Server) broadcastMessage(msgType string, payload any) {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	for client := range s.clients {
+		client.sendMessage(msgType, payload)
 	}
 }
 
@@ -161,6 +477,7 @@ func (s *Server) broadcastRTP() {
 func (s *Server) Stop() {
 	// Mark as shutting down to reject new connections
 	s.shutdown.Store(true)
+	close(s.stopCh)
 
 	// Shutdown HTTP server first (stops accepting new connections)
 	if s.httpServer != nil {
@@ -169,20 +486,35 @@ func (s *Server) Stop() {
 		s.httpServer.Shutdown(ctx)
 	}
 
-	// Close all existing clients
+	// Close all existing clients; Close takes clientsMu to remove the
+	// client's viewer, so it must not be held here
 	s.clientsMu.Lock()
+	clients := make([]*Client, 0, len(s.clients))
 	for client := range s.clients {
-		client.Close()
+		clients = append(clients, client)
 	}
 	s.clientsMu.Unlock()
+	for _, client := range clients {
+		client.Close()
+	}
+	s.whep.closeAll(s)
 
-	// Close RTSP (this also unblocks broadcastRTP)
-	if s.rtspClient != nil {
-		s.rtspClient.Close()
+	// Close the video sources (this also unblocks broadcastRTP)
+	if s.demand != nil {
+		close(s.demand.stop)
+	}
+	for _, st := range s.streams {
+		st.source.Close()
 	}
 	if s.ptzCtrl != nil {
 		s.ptzCtrl.Close()
 	}
+	if s.turn != nil {
+		s.turn.Close()
+	}
+	if s.iceNet != nil {
+		s.iceNet.Close()
+	}
 }
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -199,11 +531,9 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		conn:    conn,
-		server:  s,
-		send:    make(chan []byte, 256),
-		rtpChan: make(chan []byte, 500),
-		stopRTP: make(chan struct{}),
+		conn:   conn,
+		server: s,
+		send:   make(chan []byte, 256),
 	}
 
 	s.clientsMu.Lock()
@@ -225,12 +555,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 func (c *Client) initWebRTC() error {
 	// Create WebRTC session
-	wcfg := webrtc.DefaultConfig()
-	if c.server.cfg.ICEIPs != "" {
-		wcfg.StaticIPs = c.server.cfg.ICEIPs
-	}
-
-	session, err := webrtc.NewSession(wcfg, func(candidate *pwebrtc.ICECandidate) {
+	session, err := webrtc.NewSession(c.server.webrtcConfig(), func(candidate *pwebrtc.ICECandidate) {
 		// Send ICE candidate to client
 		payload := protocol.ICECandidatePayload{
 			Candidate:     candidate.ToJSON().Candidate,
@@ -242,65 +567,129 @@ func (c *Client) initWebRTC() error {
 	if err != nil {
 		return err
 	}
-	c.webrtc = session
+
+	// readPump is already running, so the client may have closed meanwhile
+	c.mu.Lock()
+	closed := c.closed
+	if !closed {
+		c.webrtc = session
+	}
+	c.mu.Unlock()
+	if closed {
+		session.Close()
+		return nil
+	}
+	session.OnStateChange(c.handleStateChange)
+	session.OnOffer(func(sdp string) {
+		c.sendMessage(protocol.TypeOffer, protocol.SDPPayload{SDP: sdp})
+	})
 
 	// Add video track
 	if err := session.AddH264Track(); err != nil {
 		return err
 	}
 
+	// PTZ commands also arrive over a data channel
+	if err := session.AddDataChannel(protocol.PTZChannelLabel, c.handleDataChannelMessage); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
 	// Create and send offer
-	offer, err := session.CreateOffer()
-	if err != nil {
+	if err := session.Negotiate(false); err != nil {
 		return err
 	}
 
-	c.sendMessage(protocol.TypeOffer, protocol.SDPPayload{SDP: offer})
+	c.startVideo(session)
+	return nil
+}
 
-	// Start forwarding RTP from client's channel to WebRTC
-	if c.server.rtspClient != nil {
-		go c.forwardRTP()
-	}
+func (c *Client) sendStatus() {
+	c.sendMessage(protocol.TypeStatus, c.server.status())
+}
 
-	return nil
+// controllerConfigured reports whether a network or serial PTZ controller was configured
+func (s *Server) controllerConfigured() bool {
+	return s.cfg.VISCAAddress != "" || s.cfg.PanasonicAddress != "" || s.cfg.ONVIFAddress != "" ||
+		s.cfg.PelcoAddress != "" || s.cfg.CGIAddress != ""
 }
 
-func (c *Client) forwardRTP() {
-	track := c.webrtc.GetVideoTrack()
-	if track == nil {
+// autodetect probes cfg.CameraHost and fills in the config of the
+// controller that matches it
+func (s *Server) autodetect() {
+	log.Printf("Probing camera %s...", s.cfg.CameraHost)
+	r, err := probe.Probe(probe.Config{
+		Host:     s.cfg.CameraHost,
+		Username: s.cfg.CameraUser,
+		Password: s.cfg.CameraPass,
+	})
+	if err != nil {
+		log.Printf("Warning: Camera auto-detection failed: %v", err)
 		return
 	}
-
-	for {
-		select {
-		case <-c.stopRTP:
-			return
-		case packet, ok := <-c.rtpChan:
-			if !ok {
-				return
-			}
-			if _, err := track.Write(packet); err != nil {
-				// Client disconnected or track closed
-				return
-			}
-		}
+	log.Printf("Detected %s %s (%s), using %s", r.Manufacturer, r.Model, strings.Join(r.Detected, ", "), r.Protocol)
+
+	switch r.Protocol {
+	case "visca":
+		s.cfg.VISCAAddress = r.Address
+		s.cfg.VISCAProtocol = r.Transport
+	case "panasonic":
+		s.cfg.PanasonicAddress = r.Address
+		s.cfg.PanasonicUser = s.cfg.CameraUser
+		s.cfg.PanasonicPass = s.cfg.CameraPass
+	case "onvif":
+		s.cfg.ONVIFAddress = r.Address
+		s.cfg.ONVIFUser = s.cfg.CameraUser
+		s.cfg.ONVIFPass = s.cfg.CameraPass
+	}
+
+	s.camera = &protocol.CameraInfo{
+		Manufacturer: r.Manufacturer,
+		Model:        r.Model,
+		Firmware:     r.Firmware,
+		Features:     []string{}, // Filled in once the controller is connected
+		Detected:     r.Detected,
 	}
 }
 
-func (c *Client) sendStatus() {
+// status builds the current status payload
+func (s *Server) status() protocol.StatusPayload {
 	controlProtocol := ""
-	if c.server.cfg.VISCAAddress != "" {
+	if s.cfg.VISCAAddress != "" {
 		controlProtocol = "visca"
-	} else if c.server.cfg.PanasonicAddress != "" {
+	} else if s.cfg.PanasonicAddress != "" {
 		controlProtocol = "panasonic"
+	} else if s.cfg.ONVIFAddress != "" {
+		controlProtocol = "onvif"
+	} else if s.cfg.PelcoAddress != "" {
+		controlProtocol = "pelco"
+	} else if s.cfg.CGIAddress != "" {
+		controlProtocol = "cgi"
+	} else if s.cfg.UVCDevice != "" {
+		controlProtocol = "uvc"
 	}
 	status := protocol.StatusPayload{
-		CameraConnected: c.server.rtspClient != nil,
-		RTSPURL:         c.server.cfg.RTSPURL,
+		CameraConnected: s.video != nil,
+		RTSPURL:         rtsp.RedactURL(s.cfg.RTSPURL),
 		ControlProtocol: controlProtocol,
-		VideoProtocol:   "rtsp",
+		VideoProtocol:   s.videoProto,
+		Camera:          s.camera,
+		ICEServers:      s.clientICEServers(),
+	}
+	if s.video != nil {
+		status.VideoCodec = s.video.Codec()
+		status.Streams = s.streamInfo()
+	}
+	if s.demand != nil {
+		status.VideoState = s.demand.videoState()
+	}
+	if s.ptzCtrl != nil {
+		caps := protocol.Capabilities(s.ptzCtrl.Capabilities())
+		status.Capabilities = &caps
+	}
+	if p, ok := s.ptzCtrl.(ptz.Power); ok {
+		status.Power = p.PowerState()
 	}
-	c.sendMessage(protocol.TypeStatus, status)
+	return status
 }
 
 func (c *Client) sendMessage(msgType string, payload any) {
@@ -316,6 +705,12 @@ func (c *Client) sendMessage(msgType string, payload any) {
 		return
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+
 	select {
 	case c.send <- data:
 	default:
@@ -377,19 +772,40 @@ func (c *Client) handleMessage(data []byte) {
 		if err := msg.ParsePayload(&payload); err != nil {
 			return
 		}
-		if c.webrtc != nil {
-			if err := c.webrtc.SetAnswer(payload.SDP); err != nil {
+		if session := c.session(); session != nil {
+			if err := session.SetAnswer(payload.SDP); err != nil {
 				log.Printf("Failed to set answer: %v", err)
 			}
 		}
 
+	case protocol.TypeOffer:
+		var payload protocol.SDPPayload
+		if err := msg.ParsePayload(&payload); err != nil {
+			return
+		}
+		c.handleOffer(payload.SDP)
+
+	case protocol.TypeRenegotiate:
+		var payload protocol.RenegotiatePayload
+		if err := msg.ParsePayload(&payload); err != nil {
+			return
+		}
+		c.handleRenegotiate(payload)
+
+	case protocol.TypeICERestart:
+		if session := c.session(); session != nil {
+			if err := session.Negotiate(true); err != nil {
+				log.Printf("Failed to restart ICE: %v", err)
+			}
+		}
+
 	case protocol.TypeICECandidate:
 		var payload protocol.ICECandidatePayload
 		if err := msg.ParsePayload(&payload); err != nil {
 			return
 		}
-		if c.webrtc != nil {
-			if err := c.webrtc.AddICECandidate(payload.Candidate, payload.SDPMid, payload.SDPMLineIndex); err != nil {
+		if session := c.session(); session != nil {
+			if err := session.AddICECandidate(payload.Candidate, payload.SDPMid, payload.SDPMLineIndex); err != nil {
 				log.Printf("Failed to add ICE candidate: %v", err)
 			}
 		}
@@ -402,11 +818,9 @@ func (c *Client) handleMessage(data []byte) {
 		c.handlePTZCommand(payload)
 
 	case protocol.TypePTZStop:
-		if c.server.ptzCtrl != nil {
-			if err := c.server.ptzCtrl.Stop(); err != nil {
-				log.Printf("Failed to stop PTZ: %v", err)
-			}
-		}
+		var payload protocol.PTZStopPayload
+		msg.ParsePayload(&payload)
+		c.handlePTZStop(payload)
 
 	case protocol.TypePTZPreset:
 		var payload protocol.PTZPresetPayload
@@ -415,13 +829,34 @@ func (c *Client) handleMessage(data []byte) {
 		}
 		c.handlePTZPreset(payload)
 
+	case protocol.TypePower:
+		var payload protocol.PowerPayload
+		if err := msg.ParsePayload(&payload); err != nil {
+			return
+		}
+		c.handlePower(payload)
+
+	case protocol.TypeTally:
+		var payload protocol.TallyPayload
+		if err := msg.ParsePayload(&payload); err != nil {
+			return
+		}
+		c.handleTally(payload)
+
+	case protocol.TypeSelectStream:
+		var payload protocol.SelectStreamPayload
+		if err := msg.ParsePayload(&payload); err != nil {
+			return
+		}
+		c.handleSelectStream(payload)
+
 	default:
 		log.Printf("Unknown message type: %s", msg.Type)
 	}
 }
 
 func (c *Client) handlePTZCommand(cmd protocol.PTZCommandPayload) {
-	if c.server.ptzCtrl == nil {
+	if c.server.ptzCtrl == nil || !c.freshPTZ(cmd.Seq) {
 		return
 	}
 
@@ -441,6 +876,20 @@ func (c *Client) handlePTZPreset(preset protocol.PTZPresetPayload) {
 		return
 	}
 
+	// Validate against the controller's range before sending anything
+	caps := c.server.ptzCtrl.Capabilities()
+	if err := caps.CheckPreset(preset.PresetNumber); err != nil {
+		code := protocol.ErrInvalidMessage
+		if !caps.Presets {
+			code = protocol.ErrUnsupported
+		}
+		c.sendMessage(protocol.TypeError, protocol.ErrorPayload{
+			Code:    code,
+			Message: err.Error(),
+		})
+		return
+	}
+
 	switch preset.Action {
 	case "recall":
 		if err := c.server.ptzCtrl.RecallPreset(preset.PresetNumber); err != nil {
@@ -453,6 +902,48 @@ func (c *Client) handlePTZPreset(preset protocol.PTZPresetPayload) {
 	}
 }
 
+func (c *Client) handlePower(req protocol.PowerPayload) {
+	p, ok := c.server.ptzCtrl.(ptz.Power)
+	if !ok {
+		c.sendMessage(protocol.TypeError, protocol.ErrorPayload{
+			Code:    protocol.ErrUnsupported,
+			Message: "Power control not supported by this camera",
+		})
+		return
+	}
+
+	if err := p.SetPower(req.On); err != nil {
+		log.Printf("Failed to set power: %v", err)
+		c.sendMessage(protocol.TypeError, protocol.ErrorPayload{
+			Code:    protocol.ErrCameraCommand,
+			Message: fmt.Sprintf("Failed to set power: %v", err),
+		})
+		return
+	}
+
+	// Let every client know about the new power state
+	c.server.broadcastMessage(protocol.TypeStatus, c.server.status())
+}
+
+func (c *Client) handleTally(req protocol.TallyPayload) {
+	t, ok := c.server.ptzCtrl.(ptz.Tally)
+	if !ok {
+		c.sendMessage(protocol.TypeError, protocol.ErrorPayload{
+			Code:    protocol.ErrUnsupported,
+			Message: "Tally control not supported by this camera",
+		})
+		return
+	}
+
+	if err := t.SetTally(req.Color, req.On); err != nil {
+		log.Printf("Failed to set tally: %v", err)
+		c.sendMessage(protocol.TypeError, protocol.ErrorPayload{
+			Code:    protocol.ErrCameraCommand,
+			Message: fmt.Sprintf("Failed to set tally: %v", err),
+		})
+	}
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
@@ -482,23 +973,37 @@ func (c *Client) writePump() {
 	}
 }
 
-// Close closes the client connection
-func (c *Client) Close() {
+// session returns the client's WebRTC session, or nil before it is set up
+// and once the client is closed
+func (c *Client) session() *webrtc.Session {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.webrtc
+}
 
+// Close closes the client connection
+func (c *Client) Close() {
+	c.mu.Lock()
 	if c.closed {
+		c.mu.Unlock()
 		return
 	}
 	c.closed = true
 
-	// Stop RTP forwarding
-	close(c.stopRTP)
+	close(c.send)
+
+	session := c.webrtc
+	c.webrtc = nil
+	v := c.viewer
+	c.mu.Unlock()
 
-	if c.webrtc != nil {
-		c.webrtc.Close()
-		c.webrtc = nil
+	// Stop RTP forwarding
+	if v != nil {
+		c.server.removeViewer(v)
 	}
 
-	close(c.send)
+	// Closed outside the lock: peer connection callbacks may call sendMessage
+	if session != nil {
+		session.Close()
+	}
 }
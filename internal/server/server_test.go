@@ -0,0 +1,42 @@
+package server
+
+import (
+	"embed"
+	"testing"
+	"time"
+)
+
+func TestStopClosesClients(t *testing.T) {
+	s, err := New(Config{}, embed.FS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var clients []*Client
+	for i := 0; i < 3; i++ {
+		c := &Client{server: s, send: make(chan []byte, 1), viewer: newViewer(s, "test", nil)}
+		s.addViewer(c.viewer)
+		s.clients[c] = true
+		clients = append(clients, c)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop deadlocked closing clients")
+	}
+
+	for _, c := range clients {
+		if !c.closed {
+			t.Error("client not closed")
+		}
+	}
+	if len(s.viewers) != 0 {
+		t.Errorf("%d viewers left after Stop", len(s.viewers))
+	}
+}
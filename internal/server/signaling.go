@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"log"
+
+	"ptz-remote/internal/protocol"
+	"ptz-remote/internal/webrtc"
+)
+
+// startVideo starts forwarding video to the client's session
+func (c *Client) startVideo(session *webrtc.Session) {
+	v := newViewer(c.server, c.conn.RemoteAddr().String(), session)
+	v.onSwitch = c.sendStream
+	c.server.addViewer(v)
+
+	c.mu.Lock()
+	started := !c.closed && c.viewer == nil
+	if started {
+		c.viewer = v
+	}
+	c.mu.Unlock()
+	if !started {
+		c.server.removeViewer(v)
+		return
+	}
+	go c.reportStats(v)
+}
+
+// stopVideo stops forwarding video to the client
+func (c *Client) stopVideo() {
+	c.mu.Lock()
+	v := c.viewer
+	c.viewer = nil
+	c.mu.Unlock()
+	if v != nil {
+		c.server.removeViewer(v)
+	}
+}
+
+// handleOffer answers an offer from the client. The server is the impolite
+// peer: an offer that crosses the server's own is ignored, and the client
+// rolls back to answer the server's.
+func (c *Client) handleOffer(sdp string) {
+	session := c.session()
+	if session == nil {
+		return
+	}
+	answer, err := session.AcceptOffer(sdp)
+	if errors.Is(err, webrtc.ErrOfferCollision) {
+		log.Printf("Ignoring client offer: %v", err)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to accept offer: %v", err)
+		c.sendMessage(protocol.TypeError, protocol.ErrorPayload{
+			Code:    protocol.ErrInvalidMessage,
+			Message: "Invalid offer: " + err.Error(),
+		})
+		return
+	}
+	c.sendMessage(protocol.TypeAnswer, protocol.SDPPayload{SDP: answer})
+}
+
+// handleRenegotiate adds or removes the video track if asked, then sends a
+// new offer
+func (c *Client) handleRenegotiate(req protocol.RenegotiatePayload) {
+	session := c.session()
+	if session == nil {
+		return
+	}
+
+	if req.Video != nil {
+		var err error
+		if *req.Video {
+			if err = session.AddH264Track(); err == nil {
+				c.startVideo(session)
+			}
+		} else {
+			c.stopVideo()
+			err = session.RemoveVideoTrack()
+		}
+		if err != nil {
+			log.Printf("Failed to change video track: %v", err)
+		}
+	}
+
+	if err := session.Negotiate(false); err != nil {
+		log.Printf("Failed to renegotiate: %v", err)
+	}
+}
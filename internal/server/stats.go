@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/protocol"
+	"ptz-remote/internal/webrtc"
+)
+
+// statsInterval is how often clients get their stats
+const statsInterval = 2 * time.Second
+
+// Connection quality warning thresholds
+const (
+	lossWarning = 0.05 // Fraction of packets lost
+	rttWarning  = 300 * time.Millisecond
+)
+
+// maxEvents bounds the state changes kept for the next stats message
+const maxEvents = 32
+
+// delayStats accumulates a viewer's source-to-WebRTC delay between reports
+type delayStats struct {
+	mu    sync.Mutex
+	total time.Duration
+	count int
+	max   time.Duration
+}
+
+func (d *delayStats) add(delay time.Duration) {
+	d.mu.Lock()
+	d.total += delay
+	d.count++
+	d.max = max(d.max, delay)
+	d.mu.Unlock()
+}
+
+// take returns the average and maximum since the previous call
+func (d *delayStats) take() (avg, peak time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.count > 0 {
+		avg = d.total / time.Duration(d.count)
+	}
+	peak = d.max
+	d.total, d.count, d.max = 0, 0, 0
+	return avg, peak
+}
+
+// handleStateChange logs the client's connection and ICE state changes and
+// keeps them for the next stats message. A failed connection doesn't
+// recover, so the client is closed rather than left forwarding into a dead
+// track; the browser reconnects with a new session.
+func (c *Client) handleStateChange(e webrtc.StateChange) {
+	log.Printf("Client %s WebRTC %s state: %s", c.conn.RemoteAddr(), e.Kind, e.State)
+
+	c.mu.Lock()
+	if len(c.events) == maxEvents {
+		c.events = c.events[1:]
+	}
+	c.events = append(c.events, protocol.StateEvent{
+		Time:  e.Time.UnixMilli(),
+		Kind:  e.Kind,
+		State: e.State,
+	})
+	c.mu.Unlock()
+
+	if e.Kind == webrtc.StateConnection && e.State == "failed" {
+		log.Printf("Warning: Client %s WebRTC connection failed, closing", c.conn.RemoteAddr())
+		c.Close()
+	}
+}
+
+// reportStats sends the client its stats every statsInterval while the
+// viewer is active, and logs when the connection quality crosses the
+// warning thresholds
+func (c *Client) reportStats(v *viewer) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+		}
+
+		avg, peak := v.delay.take()
+		st := v.session.Stats()
+		warnings := qualityWarnings(st)
+		if len(warnings) > 0 && !warned {
+			log.Printf("Warning: Client %s connection quality: %v", v.name, warnings)
+		} else if len(warnings) == 0 && warned {
+			log.Printf("Client %s connection quality recovered", v.name)
+		}
+		warned = len(warnings) > 0
+
+		c.mu.Lock()
+		events := c.events
+		c.events = nil
+		c.mu.Unlock()
+
+		c.sendMessage(protocol.TypeStats, protocol.StatsPayload{
+			ServerDelayMs:    millis(avg),
+			ServerDelayMaxMs: millis(peak),
+			RTTMs:            millis(st.RTT),
+			JitterMs:         millis(st.Jitter),
+			PacketsSent:      st.PacketsSent,
+			PacketsLost:      st.PacketsLost,
+			FractionLost:     st.FractionLost,
+			ConnectionState:  st.ConnectionState,
+			ICEState:         st.ICEState,
+			LocalCandidate:   st.LocalCandidate,
+			RemoteCandidate:  st.RemoteCandidate,
+			BytesSent:        st.BytesSent,
+			Events:           events,
+			Warnings:         warnings,
+		})
+	}
+}
+
+// qualityWarnings describes the stats over the warning thresholds
+func qualityWarnings(st webrtc.Stats) []string {
+	var warnings []string
+	if st.FractionLost >= lossWarning {
+		warnings = append(warnings, fmt.Sprintf("packet loss %.1f%%", st.FractionLost*100))
+	}
+	if st.RTT >= rttWarning {
+		warnings = append(warnings, fmt.Sprintf("round-trip time %v", st.RTT.Round(time.Millisecond)))
+	}
+	return warnings
+}
+
+// millis converts a duration to milliseconds, to 0.1ms
+func millis(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*10) / 10
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"ptz-remote/internal/protocol"
+	"ptz-remote/internal/video"
+	"ptz-remote/internal/webrtc"
+)
+
+// Stream selection parameters
+const (
+	bitrateWindow    = 2 * time.Second  // Stream bitrate measurement interval
+	adaptInterval    = time.Second      // How often a client's estimate is checked
+	adaptHeadroom    = 0.85             // Share of the estimate a stream may use
+	downgradeAfter   = 3                // Intervals over budget before switching down
+	settleTime       = 5 * time.Second  // No decisions while the estimate adjusts to a switch
+	probeInterval    = 20 * time.Second // Time on a lower stream before trying the next better one
+	maxProbeInterval = 5 * time.Minute  // Probe backoff limit after failed attempts
+	switchTimeout    = 10 * time.Second // Give up waiting for a keyframe on the new stream
+	switchTSGap      = 3000             // RTP timestamp step inserted at a switch (one frame at 30fps)
+)
+
+// videoStream is one of the camera's streams, e.g. main or sub
+type videoStream struct {
+	name    string
+	source  video.Source
+	ring    rtpRing
+	bitrate atomic.Int64 // Measured, bits per second
+}
+
+// viewer is a WebRTC session fed from the stream rings: a WebSocket
+// client's, or one created over WHEP
+type viewer struct {
+	server   *Server
+	name     string // Remote address, for logs
+	session  *webrtc.Session
+	wakeRTP  chan struct{} // Signals new packets in the rings the viewer reads
+	stop     chan struct{}
+	onSwitch func() // Called when the forwarded stream changes, if set
+
+	stream     atomic.Int32 // Index of the stream being forwarded
+	pending    atomic.Int32 // Stream to switch to at its next keyframe, -1 if none
+	pendingAt  atomic.Int64 // When pending was set, Unix nanoseconds
+	autoStream atomic.Bool  // Select the stream by bandwidth estimate
+	delay      delayStats   // Source receive to WebRTC write
+}
+
+func newViewer(s *Server, name string, session *webrtc.Session) *viewer {
+	v := &viewer{
+		server:  s,
+		name:    name,
+		session: session,
+		wakeRTP: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	v.pending.Store(-1)
+	v.autoStream.Store(true)
+	return v
+}
+
+// addViewer starts forwarding video to the viewer
+func (s *Server) addViewer(v *viewer) {
+	s.clientsMu.Lock()
+	s.viewers[v] = true
+	s.clientsMu.Unlock()
+
+	if s.demand != nil {
+		s.demand.addViewer()
+	}
+	if s.video != nil {
+		go v.forwardRTP()
+		if len(s.streams) > 1 {
+			go v.adaptStream()
+		}
+	}
+}
+
+// removeViewer stops forwarding video to the viewer. Calls for a viewer
+// that was already removed are ignored.
+func (s *Server) removeViewer(v *viewer) {
+	s.clientsMu.Lock()
+	found := s.viewers[v]
+	delete(s.viewers, v)
+	s.clientsMu.Unlock()
+	if !found {
+		return
+	}
+
+	close(v.stop)
+	if s.demand != nil {
+		s.demand.removeViewer()
+	}
+}
+
+// streamInfo lists the streams for status
+func (s *Server) streamInfo() []protocol.StreamInfo {
+	var info []protocol.StreamInfo
+	for _, st := range s.streams {
+		info = append(info, protocol.StreamInfo{
+			Name:    st.name,
+			Bitrate: int(st.bitrate.Load()),
+			Skipped: st.ring.skipped.Load(),
+		})
+	}
+	return info
+}
+
+// broadcastRTP reads from one stream's source, measures its bitrate, writes
+// its packets to the stream's ring and wakes the viewers watching it or
+// switching to it
+func (s *Server) broadcastRTP(index int) {
+	st := s.streams[index]
+	var bytes int64
+	windowStart := time.Now()
+
+	for packet := range st.source.RTPChannel() {
+		received := time.Now()
+		bytes += int64(len(packet))
+		if elapsed := time.Since(windowStart); elapsed >= bitrateWindow {
+			rate := bytes * 8 * int64(time.Second) / int64(elapsed)
+			if prev := st.bitrate.Load(); prev > 0 {
+				rate = (prev + rate) / 2 // Smooth over keyframe bursts
+			}
+			st.bitrate.Store(rate)
+			bytes, windowStart = 0, time.Now()
+		}
+
+		st.ring.write(packet, received)
+		video.ReleasePacket(packet)
+
+		s.clientsMu.RLock()
+		for v := range s.viewers {
+			if !v.wantsStream(index) {
+				continue
+			}
+			// A pending wakeup already covers this packet
+			select {
+			case v.wakeRTP <- struct{}{}:
+			default:
+			}
+		}
+		s.clientsMu.RUnlock()
+	}
+}
+
+// wantsStream reports whether the viewer forwards, or is about to switch
+// to, the stream
+func (v *viewer) wantsStream(index int) bool {
+	return int(v.stream.Load()) == index || int(v.pending.Load()) == index
+}
+
+// switchStream asks forwardRTP to move to another stream at its next keyframe
+func (v *viewer) switchStream(index int) {
+	if int(v.stream.Load()) == index {
+		v.pending.Store(-1)
+		return
+	}
+	v.pendingAt.Store(time.Now().UnixNano())
+	v.pending.Store(int32(index))
+}
+
+// sendStream tells the client which stream it receives
+func (c *Client) sendStream() {
+	c.mu.Lock()
+	v := c.viewer
+	c.mu.Unlock()
+	if v == nil {
+		return
+	}
+	c.sendMessage(protocol.TypeStream, protocol.StreamPayload{
+		Stream:           c.server.streams[v.stream.Load()].name,
+		Auto:             v.autoStream.Load(),
+		EstimatedBitrate: v.session.EstimatedBitrate(),
+	})
+}
+
+// handleSelectStream pins the client to a stream, or returns it to
+// bandwidth-based selection with "auto"
+func (c *Client) handleSelectStream(req protocol.SelectStreamPayload) {
+	c.mu.Lock()
+	v := c.viewer
+	c.mu.Unlock()
+	if v == nil {
+		c.sendMessage(protocol.TypeError, protocol.ErrorPayload{
+			Code:    protocol.ErrInvalidMessage,
+			Message: "No video session",
+		})
+		return
+	}
+
+	if req.Stream == "auto" {
+		v.autoStream.Store(true)
+		c.sendStream()
+		return
+	}
+	for i, st := range c.server.streams {
+		if st.name == req.Stream {
+			v.autoStream.Store(false)
+			v.switchStream(i)
+			c.sendStream()
+			return
+		}
+	}
+	c.sendMessage(protocol.TypeError, protocol.ErrorPayload{
+		Code:    protocol.ErrInvalidMessage,
+		Message: "Unknown stream: " + req.Stream,
+	})
+}
+
+// adaptStream switches the viewer between streams while it is in auto
+// mode. Streams are ordered best first. It moves down when the current
+// stream's bitrate exceeds the client's bandwidth estimate for a few
+// intervals, and up when the better stream fits. Since the estimate only
+// grows a little beyond what is being sent, it also probes the better
+// stream periodically, backing off while probes fail.
+func (v *viewer) adaptStream() {
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+
+	streams := v.server.streams
+	over := 0
+	probeEvery := probeInterval
+	probing := false // The last switch was a probe upward
+	lastSwitch := time.Now()
+
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// Expire a switch that never found a keyframe
+		if p := v.pending.Load(); p >= 0 && time.Since(time.Unix(0, v.pendingAt.Load())) > switchTimeout {
+			v.pending.CompareAndSwap(p, -1)
+			log.Printf("Stream %s had no keyframe within %v, not switching", streams[p].name, switchTimeout)
+		}
+		if !v.autoStream.Load() || v.pending.Load() >= 0 || time.Since(lastSwitch) < settleTime {
+			continue
+		}
+		estimate := v.session.EstimatedBitrate()
+		if estimate == 0 {
+			continue
+		}
+		budget := int64(float64(estimate) * adaptHeadroom)
+		current := int(v.stream.Load())
+
+		if rate := streams[current].bitrate.Load(); rate > budget && current < len(streams)-1 {
+			if over++; over < downgradeAfter {
+				continue
+			}
+			over = 0
+			if probing {
+				probeEvery = min(probeEvery*2, maxProbeInterval)
+				probing = false
+			}
+			// The best lower stream that fits, or the lowest
+			target := len(streams) - 1
+			for i := current + 1; i < len(streams); i++ {
+				if streams[i].bitrate.Load() <= budget {
+					target = i
+					break
+				}
+			}
+			log.Printf("Client %s estimate %d kbps, switching to stream %s", v.name, estimate/1000, streams[target].name)
+			v.switchStream(target)
+			lastSwitch = time.Now()
+			continue
+		}
+		over = 0
+
+		if probing && time.Since(lastSwitch) >= probeInterval {
+			probing = false
+			probeEvery = probeInterval // The probe held up
+		}
+		if current == 0 {
+			continue
+		}
+		better := streams[current-1].bitrate.Load()
+		if fits := better > 0 && better <= budget; fits || time.Since(lastSwitch) >= probeEvery {
+			probing = !fits
+			log.Printf("Client %s estimate %d kbps, trying stream %s", v.name, estimate/1000, streams[current-1].name)
+			v.switchStream(current - 1)
+			lastSwitch = time.Now()
+		}
+	}
+}
+
+// rtpRewriter keeps a client's sequence numbers and timestamps continuous
+// when the packets change stream or the source reconnects (new SSRC)
+type rtpRewriter struct {
+	started   bool
+	resync    bool
+	ssrc      uint32 // Incoming SSRC
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16 // Outgoing
+	lastTS    uint32
+}
+
+func (r *rtpRewriter) rewrite(p *rtp.Packet) {
+	if r.started && (r.resync || p.SSRC != r.ssrc) {
+		r.seqOffset = r.lastSeq + 1 - p.SequenceNumber
+		r.tsOffset = r.lastTS + switchTSGap - p.Timestamp
+	}
+	r.started, r.resync, r.ssrc = true, false, p.SSRC
+
+	p.SequenceNumber += r.seqOffset
+	p.Timestamp += r.tsOffset
+	r.lastSeq, r.lastTS = p.SequenceNumber, p.Timestamp
+}
+
+// forwardRTP writes the viewer's stream to its WebRTC track, reading the
+// stream's ring at the viewer's own pace. A pending stream is read alongside
+// and taken over at its first keyframe.
+func (v *viewer) forwardRTP() {
+	track := v.session.GetVideoTrack()
+	if track == nil {
+		return
+	}
+
+	streams := v.server.streams
+	current := int(v.stream.Load())
+	cursor := streams[current].ring.cursor()
+	pending := -1
+	var pendingCursor ringCursor
+
+	var rw rtpRewriter
+	var pkt rtp.Packet
+	var buf []byte
+	for {
+		// Look for a keyframe on the stream being switched to
+		if p := int(v.pending.Load()); p >= 0 && p != current {
+			if p != pending {
+				pending, pendingCursor = p, streams[p].ring.cursor()
+			}
+			for {
+				packet, _, ok := streams[p].ring.read(&pendingCursor, buf[:0])
+				if !ok {
+					break
+				}
+				buf = packet.data
+				if packet.keyframe {
+					// Resume at the keyframe on the new stream
+					current, cursor = p, pendingCursor
+					cursor.pos--
+					v.stream.Store(int32(p))
+					v.pending.CompareAndSwap(int32(p), -1)
+					rw.resync = true
+					if v.onSwitch != nil {
+						v.onSwitch()
+					}
+					break
+				}
+			}
+		} else {
+			pending = -1
+		}
+
+		for {
+			packet, skipped, ok := streams[current].ring.read(&cursor, buf[:0])
+			if skipped > 0 {
+				log.Printf("Client %s fell behind on stream %s, skipped %d packets to a keyframe",
+					v.name, streams[current].name, skipped)
+			}
+			if !ok {
+				break
+			}
+			buf = packet.data
+			if err := pkt.Unmarshal(buf); err != nil {
+				continue
+			}
+			rw.rewrite(&pkt)
+			if err := track.WriteRTP(&pkt); err != nil {
+				// Client disconnected or track closed
+				return
+			}
+			v.delay.add(time.Since(packet.received))
+		}
+
+		select {
+		case <-v.stop:
+			return
+		case <-v.wakeRTP:
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ptz-remote/internal/webrtc"
+)
+
+// maxSDPSize limits WHEP request bodies
+const maxSDPSize = 64 << 10
+
+// whepSessions tracks the WebRTC sessions created over WHEP, by resource ID
+type whepSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*viewer
+}
+
+func (w *whepSessions) add(id string, v *viewer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sessions == nil {
+		w.sessions = make(map[string]*viewer)
+	}
+	w.sessions[id] = v
+}
+
+func (w *whepSessions) get(id string) *viewer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sessions[id]
+}
+
+// remove forgets a session, returning it unless it was already removed
+func (w *whepSessions) remove(id string) *viewer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v := w.sessions[id]
+	delete(w.sessions, id)
+	return v
+}
+
+// closeAll closes every session, at shutdown
+func (w *whepSessions) closeAll(s *Server) {
+	w.mu.Lock()
+	ids := make([]string, 0, len(w.sessions))
+	for id := range w.sessions {
+		ids = append(ids, id)
+	}
+	w.mu.Unlock()
+	for _, id := range ids {
+		s.closeWHEP(id)
+	}
+}
+
+// handleWHEPOffer creates a session from the client's SDP offer and answers
+// with the resource URL in Location (WHEP, RFC 9725)
+func (s *Server) handleWHEPOffer(w http.ResponseWriter, r *http.Request) {
+	if s.shutdown.Load() {
+		http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if !hasContentType(r, "application/sdp") {
+		http.Error(w, "Expected application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	if s.video == nil {
+		http.Error(w, "No video source", http.StatusServiceUnavailable)
+		return
+	}
+	offer, err := io.ReadAll(io.LimitReader(r.Body, maxSDPSize))
+	if err != nil {
+		http.Error(w, "Failed to read offer", http.StatusBadRequest)
+		return
+	}
+
+	// The answer waits for gathering, so candidates aren't trickled
+	session, err := webrtc.NewSession(s.webrtcConfig(), nil)
+	if err != nil {
+		log.Printf("WHEP: Failed to create session: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+	session.OnStateChange(func(e webrtc.StateChange) {
+		log.Printf("WHEP: Session %s %s state: %s", id[:8], e.Kind, e.State)
+	})
+	if err := session.AddH264Track(); err != nil {
+		session.Close()
+		log.Printf("WHEP: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	answer, err := session.Answer(string(offer))
+	if err != nil {
+		session.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v := newViewer(s, r.RemoteAddr, session)
+	s.whep.add(id, v)
+	s.addViewer(v)
+	session.OnDisconnect(func() { s.closeWHEP(id) })
+	log.Printf("WHEP: Session %s started for %s", id[:8], r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whep/"+id)
+	s.setICELinks(w.Header())
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+// handleWHEPPatch adds trickled ICE candidates from an SDP fragment
+func (s *Server) handleWHEPPatch(w http.ResponseWriter, r *http.Request) {
+	v := s.whep.get(r.PathValue("id"))
+	if v == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !hasContentType(r, "application/trickle-ice-sdpfrag") {
+		http.Error(w, "Expected application/trickle-ice-sdpfrag", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Candidates apply to the preceding m= line's mid
+	mid, index := "", -1
+	scanner := bufio.NewScanner(io.LimitReader(r.Body, maxSDPSize))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "m="):
+			index++
+			mid = strconv.Itoa(index)
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case strings.HasPrefix(line, "a=candidate:"):
+			if err := v.session.AddICECandidate(strings.TrimPrefix(line, "a="), mid, uint16(max(index, 0))); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWHEPDelete ends a session
+func (s *Server) handleWHEPDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.closeWHEP(r.PathValue("id")) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// closeWHEP stops a session's video and closes it; false if there is no
+// such session
+func (s *Server) closeWHEP(id string) bool {
+	v := s.whep.remove(id)
+	if v == nil {
+		return false
+	}
+	s.removeViewer(v)
+	v.session.Close()
+	log.Printf("WHEP: Session %s ended", id[:8])
+	return true
+}
+
+// hasContentType reports whether the request body has the media type
+func hasContentType(r *http.Request, mediaType string) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == mediaType
+}
@@ -0,0 +1,201 @@
+package srt
+
+import "log"
+
+const tsPacketSize = 188
+
+// PMT stream types
+const (
+	streamTypeH264 = 0x1B
+	streamTypeH265 = 0x24
+)
+
+// tsDemuxer extracts H.264 access units from an MPEG-TS stream, as carried
+// over SRT. It follows the first program of the PAT and the first H.264
+// stream of its PMT; PSI sections are expected to fit in one packet.
+type tsDemuxer struct {
+	onAccessUnit func(au []byte, dts int64) // dts in 90 kHz units
+
+	pmtPID   int
+	videoPID int
+	warned   bool
+
+	nextCC int
+	pes    []byte
+}
+
+func newTSDemuxer(onAccessUnit func(au []byte, dts int64)) *tsDemuxer {
+	return &tsDemuxer{onAccessUnit: onAccessUnit, pmtPID: -1, videoPID: -1, nextCC: -1}
+}
+
+// write feeds whole TS packets; a trailing partial packet is ignored
+func (d *tsDemuxer) write(data []byte) {
+	for len(data) >= tsPacketSize {
+		if data[0] != 0x47 {
+			// Lost sync; skip to the next sync byte
+			data = data[1:]
+			continue
+		}
+		d.packet(data[:tsPacketSize])
+		data = data[tsPacketSize:]
+	}
+}
+
+// reset drops partial state after packets were lost
+func (d *tsDemuxer) reset() {
+	d.pes = nil
+	d.nextCC = -1
+}
+
+func (d *tsDemuxer) packet(pkt []byte) {
+	pusi := pkt[1]&0x40 != 0
+	pid := int(pkt[1]&0x1F)<<8 | int(pkt[2])
+	afc := pkt[3] >> 4 & 0x3
+	cc := int(pkt[3] & 0x0F)
+
+	if afc&0x1 == 0 {
+		return // No payload
+	}
+	payload := pkt[4:]
+	if afc&0x2 != 0 {
+		n := int(payload[0]) + 1
+		if n > len(payload) {
+			return
+		}
+		payload = payload[n:]
+	}
+
+	switch {
+	case pid == 0:
+		if pusi {
+			d.parsePAT(section(payload))
+		}
+	case pid == d.pmtPID:
+		if pusi {
+			d.parsePMT(section(payload))
+		}
+	case pid == d.videoPID:
+		if d.nextCC >= 0 && cc != d.nextCC {
+			d.pes = nil // Discontinuity; wait for the next PES
+		}
+		d.nextCC = (cc + 1) & 0x0F
+
+		if pusi {
+			d.flush()
+			d.pes = append([]byte(nil), payload...)
+		} else if d.pes != nil {
+			d.pes = append(d.pes, payload...)
+		}
+
+		// Bounded PES packets can be emitted as soon as they are complete
+		if len(d.pes) >= 6 {
+			if n := int(d.pes[4])<<8 | int(d.pes[5]); n > 0 && len(d.pes) >= 6+n {
+				d.pes = d.pes[:6+n]
+				d.flush()
+			}
+		}
+	}
+}
+
+// section returns the PSI section following the pointer field, without its CRC
+func section(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) {
+		return nil
+	}
+	b := payload[start:]
+	end := 3 + (int(b[1]&0x0F)<<8 | int(b[2])) - 4
+	if end < 8 || end > len(b) {
+		return nil
+	}
+	return b[:end]
+}
+
+func (d *tsDemuxer) parsePAT(b []byte) {
+	if len(b) < 8 || b[0] != 0x00 {
+		return
+	}
+	for entries := b[8:]; len(entries) >= 4; entries = entries[4:] {
+		program := int(entries[0])<<8 | int(entries[1])
+		if program != 0 {
+			d.pmtPID = int(entries[2]&0x1F)<<8 | int(entries[3])
+			return
+		}
+	}
+}
+
+func (d *tsDemuxer) parsePMT(b []byte) {
+	if len(b) < 12 || b[0] != 0x02 {
+		return
+	}
+	infoLen := int(b[10]&0x0F)<<8 | int(b[11])
+	if 12+infoLen > len(b) {
+		return
+	}
+
+	hasH265 := false
+	for es := b[12+infoLen:]; len(es) >= 5; {
+		streamType := es[0]
+		pid := int(es[1]&0x1F)<<8 | int(es[2])
+		esInfoLen := int(es[3]&0x0F)<<8 | int(es[4])
+
+		switch streamType {
+		case streamTypeH264:
+			if d.videoPID != pid {
+				d.videoPID = pid
+				d.reset()
+			}
+			return
+		case streamTypeH265:
+			hasH265 = true
+		}
+		if 5+esInfoLen > len(es) {
+			break
+		}
+		es = es[5+esInfoLen:]
+	}
+
+	if !d.warned {
+		if hasH265 {
+			log.Printf("SRT: H.265 streams are not supported, only H.264")
+		} else {
+			log.Printf("SRT: No H.264 video in the MPEG-TS stream")
+		}
+		d.warned = true
+	}
+}
+
+// flush emits the buffered PES packet's payload as an access unit
+func (d *tsDemuxer) flush() {
+	pes := d.pes
+	d.pes = nil
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return
+	}
+
+	flags := pes[7]
+	hdrLen := int(pes[8])
+	if 9+hdrLen > len(pes) {
+		return
+	}
+
+	var ts int64 = -1
+	switch {
+	case flags&0x40 != 0 && hdrLen >= 10:
+		ts = timestamp(pes[14:19]) // DTS
+	case flags&0x80 != 0 && hdrLen >= 5:
+		ts = timestamp(pes[9:14]) // PTS
+	}
+
+	if au := pes[9+hdrLen:]; len(au) > 0 {
+		d.onAccessUnit(au, ts)
+	}
+}
+
+// timestamp decodes a 33-bit PES PTS or DTS
+func timestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
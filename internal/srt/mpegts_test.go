@@ -0,0 +1,254 @@
+package srt
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+const (
+	testPMTPID   = 0x100
+	testVideoPID = 0x101
+)
+
+// tsPacket builds a TS packet, padding a short payload with adaptation
+// field stuffing
+func tsPacket(pid int, pusi bool, cc int, payload []byte) []byte {
+	pkt := []byte{0x47, byte(pid >> 8 & 0x1F), byte(pid), byte(0x10 | cc&0x0F)}
+	if pusi {
+		pkt[1] |= 0x40
+	}
+	if n := tsPacketSize - 4 - len(payload); n > 0 {
+		pkt[3] |= 0x20
+		af := make([]byte, n)
+		af[0] = byte(n - 1)
+		for i := 2; i < n; i++ {
+			af[i] = 0xFF
+		}
+		pkt = append(pkt, af...)
+	}
+	return append(pkt, payload...)
+}
+
+// psi wraps a section in a pointer field and appends a dummy CRC
+func psi(tableID byte, body []byte) []byte {
+	n := 5 + len(body) + 4
+	sec := []byte{0, tableID, 0xB0 | byte(n>>8), byte(n), 0, 1, 0xC1, 0, 0}
+	sec = append(sec, body...)
+	return append(sec, 0, 0, 0, 0)
+}
+
+func pat() []byte {
+	return tsPacket(0, true, 0, psi(0x00, []byte{0, 1, 0xE0 | testPMTPID>>8, testPMTPID & 0xFF}))
+}
+
+func pmt(streams ...byte) []byte {
+	body := []byte{0xE0 | testVideoPID>>8, testVideoPID & 0xFF, 0xF0, 0}
+	for i, typ := range streams {
+		pid := testVideoPID + i
+		body = append(body, typ, 0xE0|byte(pid>>8), byte(pid), 0xF0, 0)
+	}
+	return tsPacket(testPMTPID, true, 0, psi(0x02, body))
+}
+
+// pts encodes a 33-bit PES timestamp with the given 4-bit prefix
+func pts(prefix byte, ts int64) []byte {
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0E | 1,
+		byte(ts >> 22),
+		byte(ts>>14) | 1,
+		byte(ts >> 7),
+		byte(ts<<1) | 1,
+	}
+}
+
+// pes builds a PES packet with a PTS and optionally a DTS; bounded sets
+// PES_packet_length
+func pes(au []byte, ptsVal, dtsVal int64, bounded bool) []byte {
+	hdr := pts(2, ptsVal)
+	flags := byte(0x80)
+	if dtsVal >= 0 {
+		hdr = append(pts(3, ptsVal), pts(1, dtsVal)...)
+		flags = 0xC0
+	}
+	b := []byte{0, 0, 1, 0xE0, 0, 0, 0x80, flags, byte(len(hdr))}
+	b = append(b, hdr...)
+	b = append(b, au...)
+	if bounded {
+		n := len(b) - 6
+		b[4], b[5] = byte(n>>8), byte(n)
+	}
+	return b
+}
+
+// split cuts a PES packet into TS packets on the video PID
+func split(p []byte, cc int) [][]byte {
+	var pkts [][]byte
+	for first := true; len(p) > 0 || first; first = false {
+		n := min(len(p), tsPacketSize-4)
+		pkts = append(pkts, tsPacket(testVideoPID, first, cc, p[:n]))
+		p = p[n:]
+		cc++
+	}
+	return pkts
+}
+
+type accessUnit struct {
+	data []byte
+	dts  int64
+}
+
+func demux(chunks ...[]byte) []accessUnit {
+	var got []accessUnit
+	d := newTSDemuxer(func(au []byte, dts int64) {
+		got = append(got, accessUnit{append([]byte(nil), au...), dts})
+	})
+	for _, c := range chunks {
+		d.write(c)
+	}
+	return got
+}
+
+func join(pkts ...[][]byte) []byte {
+	var b []byte
+	for _, p := range pkts {
+		b = append(b, bytes.Join(p, nil)...)
+	}
+	return b
+}
+
+func TestTSDemux(t *testing.T) {
+	au1 := []byte{0, 0, 0, 1, 0x65, 1, 2, 3}
+	au2 := bytes.Repeat([]byte{0, 0, 0, 1, 0x41, 9}, 100) // Spans several TS packets
+	psiPkts := [][]byte{pat(), pmt(streamTypeH264)}
+
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   []accessUnit
+	}{
+		{
+			name:   "bounded PES with DTS",
+			chunks: [][]byte{join(psiPkts, split(pes(au1, 9000, 6000, true), 0))},
+			want:   []accessUnit{{au1, 6000}},
+		},
+		{
+			name:   "PTS only",
+			chunks: [][]byte{join(psiPkts, split(pes(au1, 1<<32+5, -1, true), 0))},
+			want:   []accessUnit{{au1, 1<<32 + 5}},
+		},
+		{
+			name:   "unbounded PES ends at the next one",
+			chunks: [][]byte{join(psiPkts, split(pes(au2, 3000, -1, false), 0), split(pes(au1, 6000, -1, false), 4))},
+			want:   []accessUnit{{au2, 3000}},
+		},
+		{
+			name:   "one packet per write",
+			chunks: splitEvery(join(psiPkts, split(pes(au2, 3000, -1, true), 0)), tsPacketSize),
+			want:   []accessUnit{{au2, 3000}},
+		},
+		{
+			name:   "garbage before sync",
+			chunks: [][]byte{append([]byte{1, 2, 3}, join(psiPkts, split(pes(au1, 3000, -1, true), 0))...)},
+			want:   []accessUnit{{au1, 3000}},
+		},
+		{
+			name: "continuity error drops the PES",
+			chunks: [][]byte{join(psiPkts, dropPacket(split(pes(au2, 3000, -1, false), 0), 1),
+				split(pes(au1, 6000, -1, true), 4))},
+			want: []accessUnit{{au1, 6000}},
+		},
+		{
+			name:   "video before PSI",
+			chunks: [][]byte{join(split(pes(au1, 3000, -1, true), 0), psiPkts, split(pes(au1, 6000, -1, true), 1))},
+			want:   []accessUnit{{au1, 6000}},
+		},
+		{
+			name:   "H.265 only",
+			chunks: [][]byte{join([][]byte{pat(), pmt(streamTypeH265)}, split(pes(au1, 3000, -1, true), 0))},
+		},
+		{
+			name:   "H.264 after another stream",
+			chunks: [][]byte{join([][]byte{pat(), pmt(0x0F, streamTypeH264)}, split(pes(au1, 3000, -1, true), 0))},
+		},
+		{
+			name:   "trailing partial packet",
+			chunks: [][]byte{join(psiPkts, split(pes(au1, 3000, -1, true), 0))[:2*tsPacketSize+100]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := demux(tt.chunks...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d access units, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i].data, tt.want[i].data) || got[i].dts != tt.want[i].dts {
+					t.Errorf("access unit %d: % X at %d, want % X at %d", i, got[i].data, got[i].dts, tt.want[i].data, tt.want[i].dts)
+				}
+			}
+		})
+	}
+}
+
+func splitEvery(b []byte, n int) [][]byte {
+	var chunks [][]byte
+	for len(b) > n {
+		chunks = append(chunks, b[:n])
+		b = b[n:]
+	}
+	return append(chunks, b)
+}
+
+func dropPacket(pkts [][]byte, i int) [][]byte {
+	return append(append([][]byte(nil), pkts[:i]...), pkts[i+1:]...)
+}
+
+// TestTSDemuxMalformed feeds headers that claim more data than there is
+func TestTSDemuxMalformed(t *testing.T) {
+	psiPkts := [][]byte{pat(), pmt(streamTypeH264)}
+	video := func(pusi bool, payload []byte) []byte { return tsPacket(testVideoPID, pusi, 0, payload) }
+
+	tests := []struct {
+		name string
+		pkts [][]byte
+	}{
+		{"adaptation field longer than the packet", [][]byte{{0x47, 0x41, 0x01, 0x30, 0xFF}}},
+		{"adaptation field only", [][]byte{{0x47, 0x41, 0x01, 0x20, 183}}},
+		{"PAT pointer past the end", [][]byte{tsPacket(0, true, 0, []byte{0xFF})}},
+		{"PAT section length past the end", [][]byte{tsPacket(0, true, 0, []byte{0, 0, 0xBF, 0xFF})}},
+		{"PAT section too short", [][]byte{tsPacket(0, true, 0, []byte{0, 0, 0xB0, 0x04, 0, 0, 0, 0})}},
+		{"PMT program info past the end", [][]byte{pat(), tsPacket(testPMTPID, true, 0, psi(0x02, []byte{0xE1, 0, 0xFF, 0xFF}))}},
+		{"PMT ES info past the end", [][]byte{pat(), tsPacket(testPMTPID, true, 0, psi(0x02, []byte{0xE1, 0, 0xF0, 0, 0x0F, 0xE1, 0x01, 0xFF, 0xFF}))}},
+		{"PES too short", append(psiPkts, video(true, []byte{0, 0, 1}), video(true, nil))},
+		{"PES header length past the end", append(psiPkts, video(true, []byte{0, 0, 1, 0xE0, 0, 0, 0x80, 0xC0, 0xFF, 1}), video(true, nil))},
+		{"PTS flag without room", append(psiPkts, video(true, []byte{0, 0, 1, 0xE0, 0, 0, 0x80, 0x80, 0x02, 1, 2, 3}), video(true, nil))},
+		{"DTS flag without room", append(psiPkts, video(true, append([]byte{0, 0, 1, 0xE0, 0, 0, 0x80, 0xC0, 0x05}, pts(2, 1)...)), video(true, nil))},
+		{"PES length past the payload", append(psiPkts, video(true, []byte{0, 0, 1, 0xE0, 0xFF, 0xFF, 0x80, 0, 0}))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, p := range tt.pkts {
+				if len(p) < tsPacketSize {
+					p = append(p, make([]byte, tsPacketSize-len(p))...)
+				}
+				demux(p)
+			}
+			demux(join(tt.pkts))
+		})
+	}
+}
+
+// TestTSDemuxRandom checks that arbitrary input doesn't panic the demuxer
+func TestTSDemuxRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	valid := join([][]byte{pat(), pmt(streamTypeH264)}, split(pes(bytes.Repeat([]byte{0x41}, 1000), 3000, 6000, false), 0))
+	d := newTSDemuxer(func([]byte, int64) {})
+	for i := 0; i < 2000; i++ {
+		b := append([]byte(nil), valid...)
+		for j := 0; j < 1+rng.Intn(20); j++ {
+			b[rng.Intn(len(b))] = byte(rng.Intn(256))
+		}
+		d.write(b[:rng.Intn(len(b)+1)])
+	}
+}
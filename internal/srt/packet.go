@@ -0,0 +1,178 @@
+package srt
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const headerSize = 16
+
+// Control packet types
+const (
+	ctrlHandshake = 0
+	ctrlKeepalive = 1
+	ctrlACK       = 2
+	ctrlNAK       = 3
+	ctrlShutdown  = 5
+	ctrlACKACK    = 6
+)
+
+// Handshake types, versions and extension flags (HSv5)
+const (
+	hsInduction  = 1
+	hsConclusion = 0xFFFFFFFF
+	hsRejectBase = 1000 // Rejections are sent as 1000 + reason
+	rejRogue     = 4    // Malformed handshake
+	rejUnsecure  = 11   // Caller wants encryption, which isn't supported
+
+	hsVersion5 = 5
+	hsMagic    = 0x4A17 // Extension field of the listener's induction response
+
+	extFlagHSREQ = 0x1
+	extFlagKMREQ = 0x2
+
+	extHSREQ = 1
+	extHSRSP = 2
+	extKMREQ = 3
+)
+
+// SRT version and flags sent in the handshake response extension
+const (
+	srtVersion = 0x00010500
+
+	flagTSBPDSND  = 0x01
+	flagTSBPDRCV  = 0x02
+	flagCRYPT     = 0x04
+	flagTLPKTDROP = 0x08
+	flagREXMIT    = 0x20
+)
+
+const seqMask = 0x7FFFFFFF
+
+// seqDiff returns a-b for 31-bit sequence numbers, accounting for wraparound
+func seqDiff(a, b uint32) int32 {
+	return int32((a-b)<<1) >> 1
+}
+
+func seqAdd(a uint32, n int32) uint32 {
+	return (a + uint32(n)) & seqMask
+}
+
+// packet is a decoded SRT packet header with its payload
+type packet struct {
+	control bool
+
+	// Data packets
+	seq       uint32
+	encrypted bool
+
+	// Control packets
+	typ     uint16
+	subtype uint16
+	info    uint32 // Type-specific information
+
+	timestamp uint32
+	destID    uint32
+	payload   []byte
+}
+
+func parsePacket(b []byte) (*packet, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("short packet")
+	}
+	p := &packet{
+		timestamp: binary.BigEndian.Uint32(b[8:12]),
+		destID:    binary.BigEndian.Uint32(b[12:16]),
+		payload:   b[headerSize:],
+	}
+	w0 := binary.BigEndian.Uint32(b[0:4])
+	w1 := binary.BigEndian.Uint32(b[4:8])
+	if w0&0x80000000 != 0 {
+		p.control = true
+		p.typ = uint16(w0>>16) & 0x7FFF
+		p.subtype = uint16(w0)
+		p.info = w1
+	} else {
+		p.seq = w0
+		p.encrypted = (w1>>27)&0x3 != 0
+	}
+	return p, nil
+}
+
+// controlPacket builds a control packet
+func controlPacket(typ uint16, info, timestamp, destID uint32, cif []byte) []byte {
+	b := make([]byte, headerSize, headerSize+len(cif))
+	binary.BigEndian.PutUint32(b[0:4], 0x80000000|uint32(typ)<<16)
+	binary.BigEndian.PutUint32(b[4:8], info)
+	binary.BigEndian.PutUint32(b[8:12], timestamp)
+	binary.BigEndian.PutUint32(b[12:16], destID)
+	return append(b, cif...)
+}
+
+// handshake is the handshake control information field
+type handshake struct {
+	version    uint32
+	encryption uint16
+	extension  uint16
+	initialSeq uint32
+	mtu        uint32
+	flowWindow uint32
+	typ        uint32
+	socketID   uint32
+	cookie     uint32
+	peerIP     [16]byte
+	extensions map[uint16][]byte // Extension type to content
+}
+
+const handshakeSize = 48
+
+func parseHandshake(b []byte) (*handshake, error) {
+	if len(b) < handshakeSize {
+		return nil, fmt.Errorf("short handshake")
+	}
+	hs := &handshake{
+		version:    binary.BigEndian.Uint32(b[0:4]),
+		encryption: binary.BigEndian.Uint16(b[4:6]),
+		extension:  binary.BigEndian.Uint16(b[6:8]),
+		initialSeq: binary.BigEndian.Uint32(b[8:12]),
+		mtu:        binary.BigEndian.Uint32(b[12:16]),
+		flowWindow: binary.BigEndian.Uint32(b[16:20]),
+		typ:        binary.BigEndian.Uint32(b[20:24]),
+		socketID:   binary.BigEndian.Uint32(b[24:28]),
+		cookie:     binary.BigEndian.Uint32(b[28:32]),
+		extensions: make(map[uint16][]byte),
+	}
+	copy(hs.peerIP[:], b[32:48])
+
+	for ext := b[handshakeSize:]; len(ext) >= 4; {
+		typ := binary.BigEndian.Uint16(ext[0:2])
+		n := int(binary.BigEndian.Uint16(ext[2:4])) * 4
+		if 4+n > len(ext) {
+			return nil, fmt.Errorf("truncated handshake extension %d", typ)
+		}
+		hs.extensions[typ] = ext[4 : 4+n]
+		ext = ext[4+n:]
+	}
+	return hs, nil
+}
+
+func (hs *handshake) marshal() []byte {
+	b := make([]byte, handshakeSize)
+	binary.BigEndian.PutUint32(b[0:4], hs.version)
+	binary.BigEndian.PutUint16(b[4:6], hs.encryption)
+	binary.BigEndian.PutUint16(b[6:8], hs.extension)
+	binary.BigEndian.PutUint32(b[8:12], hs.initialSeq)
+	binary.BigEndian.PutUint32(b[12:16], hs.mtu)
+	binary.BigEndian.PutUint32(b[16:20], hs.flowWindow)
+	binary.BigEndian.PutUint32(b[20:24], hs.typ)
+	binary.BigEndian.PutUint32(b[24:28], hs.socketID)
+	binary.BigEndian.PutUint32(b[28:32], hs.cookie)
+	copy(b[32:48], hs.peerIP[:])
+
+	for typ, content := range hs.extensions {
+		b = binary.BigEndian.AppendUint16(b, typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(content)/4))
+		b = append(b, content...)
+	}
+	return b
+}
@@ -0,0 +1,291 @@
+package srt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParsePacket(t *testing.T) {
+	data := make([]byte, headerSize+2)
+	binary.BigEndian.PutUint32(data[0:4], 1234)
+	binary.BigEndian.PutUint32(data[4:8], 0xC0000000|1<<27) // Single packet, encrypted with the even key
+	binary.BigEndian.PutUint32(data[12:16], 77)
+
+	tests := []struct {
+		name    string
+		b       []byte
+		wantErr bool
+		check   func(t *testing.T, p *packet)
+	}{
+		{"empty", nil, true, nil},
+		{"short header", make([]byte, headerSize-1), true, nil},
+		{"data", data, false, func(t *testing.T, p *packet) {
+			if p.control || p.seq != 1234 || !p.encrypted || p.destID != 77 || len(p.payload) != 2 {
+				t.Errorf("got %+v", p)
+			}
+		}},
+		{"control", controlPacket(ctrlACK, 9, 100, 77, []byte{1, 2, 3, 4}), false, func(t *testing.T, p *packet) {
+			if !p.control || p.typ != ctrlACK || p.info != 9 || p.timestamp != 100 || p.destID != 77 || len(p.payload) != 4 {
+				t.Errorf("got %+v", p)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parsePacket(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, p)
+			}
+		})
+	}
+}
+
+func TestParseHandshake(t *testing.T) {
+	valid := (&handshake{
+		version:    hsVersion5,
+		initialSeq: 42,
+		typ:        hsConclusion,
+		socketID:   7,
+		extensions: map[uint16][]byte{extHSREQ: make([]byte, 12)},
+	}).marshal()
+
+	tests := []struct {
+		name    string
+		b       []byte
+		wantErr bool
+	}{
+		{"empty", nil, true},
+		{"short", make([]byte, handshakeSize-1), true},
+		{"no extensions", make([]byte, handshakeSize), false},
+		{"extension", valid, false},
+		{"truncated extension", valid[:len(valid)-4], true},
+		{"extension length past the end", append(make([]byte, handshakeSize), 0, 1, 0xFF, 0xFF), true},
+		{"trailing bytes shorter than an extension header", append(make([]byte, handshakeSize), 0, 1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs, err := parseHandshake(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.name == "extension" && (hs.initialSeq != 42 || hs.socketID != 7 || len(hs.extensions[extHSREQ]) != 12) {
+				t.Errorf("round trip got %+v", hs)
+			}
+		})
+	}
+}
+
+func TestSeqArithmetic(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		diff int32
+	}{
+		{5, 3, 2},
+		{3, 5, -2},
+		{0, seqMask, 1}, // Wraps
+		{seqMask, 0, -1},
+	}
+	for _, tt := range tests {
+		if d := seqDiff(tt.a, tt.b); d != tt.diff {
+			t.Errorf("seqDiff(%d, %d) = %d, want %d", tt.a, tt.b, d, tt.diff)
+		}
+	}
+	if s := seqAdd(seqMask, 1); s != 0 {
+		t.Errorf("seqAdd wrapped to %d, want 0", s)
+	}
+}
+
+// caller is the camera's side of an SRT connection to a listening Source
+type caller struct {
+	t  *testing.T
+	pc net.PacketConn
+	to net.Addr
+}
+
+func newCaller(t *testing.T) (*Source, *caller) {
+	t.Helper()
+	s, err := NewSource(Config{Address: "127.0.0.1:0", Latency: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return s, &caller{t: t, pc: pc, to: s.pc.LocalAddr()}
+}
+
+func (c *caller) send(b []byte) {
+	c.t.Helper()
+	if _, err := c.pc.WriteTo(b, c.to); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// handshakeReply waits for the listener's next handshake, or nil if none
+// arrives
+func (c *caller) handshakeReply() *handshake {
+	c.t.Helper()
+	buf := make([]byte, mtu)
+	for {
+		c.pc.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		n, _, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			return nil
+		}
+		p, err := parsePacket(buf[:n])
+		if err != nil || !p.control || p.typ != ctrlHandshake {
+			continue // Keepalives and ACKs
+		}
+		hs, err := parseHandshake(p.payload)
+		if err != nil {
+			c.t.Fatalf("bad handshake reply: %v", err)
+		}
+		return hs
+	}
+}
+
+// hsreq builds an HSREQ extension with the caller's send delay
+func hsreq(delay uint16) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b[0:4], srtVersion)
+	binary.BigEndian.PutUint32(b[8:12], uint32(delay)<<16|uint32(delay))
+	return b
+}
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		cookie     func(induction uint32) uint32
+		extension  uint16
+		extensions map[uint16][]byte
+		wantType   uint32 // 0 for no reply
+	}{
+		{
+			name:       "accepted",
+			cookie:     func(c uint32) uint32 { return c },
+			extension:  extFlagHSREQ,
+			extensions: map[uint16][]byte{extHSREQ: hsreq(500)},
+			wantType:   hsConclusion,
+		},
+		{
+			name:       "wrong cookie",
+			cookie:     func(c uint32) uint32 { return c + 1 },
+			extension:  extFlagHSREQ,
+			extensions: map[uint16][]byte{extHSREQ: hsreq(120)},
+		},
+		{
+			name:       "encrypted",
+			cookie:     func(c uint32) uint32 { return c },
+			extension:  extFlagHSREQ | extFlagKMREQ,
+			extensions: map[uint16][]byte{extHSREQ: hsreq(120), extKMREQ: make([]byte, 16)},
+			wantType:   hsRejectBase + rejUnsecure,
+		},
+		{
+			name:     "no HSREQ",
+			cookie:   func(c uint32) uint32 { return c },
+			wantType: hsRejectBase + rejRogue,
+		},
+		{
+			name:       "short HSREQ",
+			cookie:     func(c uint32) uint32 { return c },
+			extension:  extFlagHSREQ,
+			extensions: map[uint16][]byte{extHSREQ: make([]byte, 8)},
+			wantType:   hsRejectBase + rejRogue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c := newCaller(t)
+
+			c.send(controlPacket(ctrlHandshake, 0, 0, 0, (&handshake{
+				version: 4, typ: hsInduction, socketID: 99, initialSeq: 1000, mtu: mtu,
+			}).marshal()))
+			induction := c.handshakeReply()
+			if induction == nil {
+				t.Fatal("no induction response")
+			}
+			if induction.version != hsVersion5 || induction.extension != hsMagic || induction.cookie == 0 {
+				t.Fatalf("induction response %+v", induction)
+			}
+
+			c.send(controlPacket(ctrlHandshake, 0, 0, 0, (&handshake{
+				version: hsVersion5, typ: hsConclusion, socketID: 99, initialSeq: 1000, mtu: mtu,
+				cookie: tt.cookie(induction.cookie), extension: tt.extension, extensions: tt.extensions,
+			}).marshal()))
+			resp := c.handshakeReply()
+			if tt.wantType == 0 {
+				if resp != nil {
+					t.Fatalf("got reply %+v, want none", resp)
+				}
+				return
+			}
+			if resp == nil {
+				t.Fatal("no conclusion response")
+			}
+			if resp.typ != tt.wantType {
+				t.Fatalf("response type %d, want %d", resp.typ, tt.wantType)
+			}
+			if tt.wantType != hsConclusion {
+				return
+			}
+
+			rsp := resp.extensions[extHSRSP]
+			if len(rsp) != 12 || resp.socketID == 0 {
+				t.Fatalf("conclusion %+v", resp)
+			}
+			// The larger of the two latencies wins
+			if delay := binary.BigEndian.Uint32(rsp[8:12]) >> 16; delay != 500 {
+				t.Errorf("receive delay %dms, want 500", delay)
+			}
+
+			// A repeated conclusion is answered with the same response
+			c.send(controlPacket(ctrlHandshake, 0, 0, 0, (&handshake{
+				version: hsVersion5, typ: hsConclusion, socketID: 99, initialSeq: 1000, mtu: mtu,
+				cookie: induction.cookie, extension: tt.extension, extensions: tt.extensions,
+			}).marshal()))
+			if again := c.handshakeReply(); again == nil || again.socketID != resp.socketID {
+				t.Errorf("repeated conclusion answered with %+v", again)
+			}
+		})
+	}
+}
+
+func TestHandshakeMalformed(t *testing.T) {
+	s, c := newCaller(t)
+	for _, b := range [][]byte{
+		{0x80},
+		controlPacket(ctrlHandshake, 0, 0, 0, nil),
+		controlPacket(ctrlHandshake, 0, 0, 0, make([]byte, handshakeSize-1)),
+		controlPacket(ctrlHandshake, 0, 0, 0, append(make([]byte, handshakeSize), 0, 1, 0, 9)),
+		controlPacket(ctrlShutdown, 0, 0, 0, nil),
+		bytes.Repeat([]byte{0xFF}, 64),
+	} {
+		c.send(b)
+	}
+	if hs := c.handshakeReply(); hs != nil {
+		t.Errorf("malformed handshake answered with %+v", hs)
+	}
+
+	// The listener is still running
+	c.send(controlPacket(ctrlHandshake, 0, 0, 0, (&handshake{version: 4, typ: hsInduction, socketID: 1}).marshal()))
+	if hs := c.handshakeReply(); hs == nil {
+		t.Error("no induction response after malformed packets")
+	}
+	s.Close()
+	if s.peer != nil {
+		t.Error("malformed packets created a connection")
+	}
+}
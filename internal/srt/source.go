@@ -0,0 +1,448 @@
+package srt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/video"
+)
+
+const (
+	mtu           = 1500
+	flowWindow    = 8192
+	ackInterval   = 10 * time.Millisecond
+	keepalive     = time.Second
+	peerTimeout   = 5 * time.Second
+	maxBuffered   = 8192 // Out-of-order packets held while waiting for a retransmission
+	clockRate     = 90000
+	maxTSJump     = 10 * clockRate // Larger DTS jumps are treated as a discontinuity
+	initialRTT    = 100000         // Microseconds, reported in ACKs
+	initialRTTVar = 50000
+)
+
+// Config for an SRT listener
+type Config struct {
+	Address string        // UDP listen address, default ":9000"
+	Latency time.Duration // Receiver latency, default 120ms
+}
+
+// Source accepts an SRT caller (e.g. a camera's "SRT push" setting) in
+// listener mode, demuxes the MPEG-TS it sends and repacketizes the H.264
+// video into RTP. Only live mode without encryption is supported. Packets
+// are delivered in order as soon as they arrive; a lost packet that isn't
+// retransmitted within the latency is skipped. A new caller replaces the
+// current one.
+type Source struct {
+	cfg     Config
+	rtpChan chan []byte
+	stopCh  chan struct{}
+	done    chan struct{}
+	secret  [16]byte // Keys the handshake cookies
+
+	mu      sync.Mutex
+	pc      net.PacketConn
+	codec   string
+	stopped bool
+
+	peer *peer // Only touched by the receive loop
+}
+
+// peer is the state of the connected caller
+type peer struct {
+	addr     net.Addr
+	socketID uint32 // Caller's socket ID
+	ourID    uint32
+	start    time.Time
+	response []byte // Conclusion response, resent if the caller repeats its request
+
+	next     uint32            // Next sequence number to deliver
+	highest  uint32            // Highest sequence number received
+	buffered map[uint32][]byte // Received packets after a gap
+	gapSince time.Time
+
+	ackNumber uint32
+	acked     uint32 // Sequence number in the last ACK
+	lastRecv  time.Time
+	lastSent  time.Time
+
+	demux      *tsDemuxer
+	packetizer *video.H264Packetizer
+	clock      time.Time
+	lastDTS    int64
+}
+
+// NewSource creates an SRT source; it listens once Connect is called
+func NewSource(cfg Config) (*Source, error) {
+	if cfg.Address == "" {
+		cfg.Address = ":9000"
+	}
+	if cfg.Latency == 0 {
+		cfg.Latency = 120 * time.Millisecond
+	}
+	s := &Source{
+		cfg:     cfg,
+		rtpChan: make(chan []byte, 500),
+		stopCh:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	rand.Read(s.secret[:])
+	return s, nil
+}
+
+// Connect starts listening for a caller
+func (s *Source) Connect() error {
+	pc, err := net.ListenPacket("udp", s.cfg.Address)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.pc = pc
+	s.mu.Unlock()
+
+	log.Printf("SRT: Waiting for a camera to call on %s", s.cfg.Address)
+	go s.receive(pc)
+	return nil
+}
+
+// receive handles incoming packets and, between them, sends ACKs and
+// keepalives and drops a silent caller
+func (s *Source) receive(pc net.PacketConn) {
+	defer close(s.done)
+
+	buf := make([]byte, mtu)
+	lastTick := time.Now()
+	for {
+		select {
+		case <-s.stopCh:
+			if s.peer != nil {
+				s.sendControl(pc, s.peer, ctrlShutdown, 0, nil)
+			}
+			return
+		default:
+		}
+
+		pc.SetReadDeadline(time.Now().Add(ackInterval))
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Timeout() {
+				log.Printf("SRT: Receive failed: %v", err)
+				return
+			}
+		} else if p, err := parsePacket(buf[:n]); err == nil {
+			s.handle(pc, addr, p)
+		}
+
+		if now := time.Now(); now.Sub(lastTick) >= ackInterval {
+			lastTick = now
+			s.tick(pc, now)
+		}
+	}
+}
+
+func (s *Source) handle(pc net.PacketConn, addr net.Addr, p *packet) {
+	if p.control && p.typ == ctrlHandshake {
+		s.handshake(pc, addr, p)
+		return
+	}
+
+	c := s.peer
+	if c == nil || addr.String() != c.addr.String() || p.destID != c.ourID {
+		return
+	}
+	c.lastRecv = time.Now()
+
+	if !p.control {
+		if p.encrypted {
+			return
+		}
+		s.receiveData(pc, c, p.seq, p.payload)
+		return
+	}
+
+	switch p.typ {
+	case ctrlShutdown:
+		log.Printf("SRT: Caller %s disconnected", c.addr)
+		s.peer = nil
+	case ctrlKeepalive, ctrlACKACK:
+		// Only refresh lastRecv
+	}
+}
+
+// handshake answers the caller's induction and conclusion requests
+func (s *Source) handshake(pc net.PacketConn, addr net.Addr, p *packet) {
+	hs, err := parseHandshake(p.payload)
+	if err != nil {
+		return
+	}
+
+	switch hs.typ {
+	case hsInduction:
+		resp := &handshake{
+			version:    hsVersion5,
+			extension:  hsMagic,
+			initialSeq: hs.initialSeq,
+			mtu:        mtu,
+			flowWindow: flowWindow,
+			typ:        hsInduction,
+			cookie:     s.cookie(addr, time.Now()),
+		}
+		pc.WriteTo(controlPacket(ctrlHandshake, 0, 0, hs.socketID, resp.marshal()), addr)
+
+	case hsConclusion:
+		if c := s.peer; c != nil && c.addr.String() == addr.String() && c.socketID == hs.socketID {
+			pc.WriteTo(c.response, addr) // Our response was lost
+			return
+		}
+		now := time.Now()
+		if hs.version < hsVersion5 ||
+			(hs.cookie != s.cookie(addr, now) && hs.cookie != s.cookie(addr, now.Add(-time.Minute))) {
+			return
+		}
+
+		resp := &handshake{
+			version:    hsVersion5,
+			initialSeq: hs.initialSeq,
+			mtu:        min(hs.mtu, mtu),
+			flowWindow: flowWindow,
+			typ:        hsConclusion,
+			socketID:   randomID(),
+			cookie:     hs.cookie,
+			extensions: make(map[uint16][]byte),
+		}
+
+		_, kmreq := hs.extensions[extKMREQ]
+		hsreq, ok := hs.extensions[extHSREQ]
+		if kmreq || hs.extension&extFlagKMREQ != 0 || !ok || len(hsreq) < 12 {
+			reason, code := "no SRT handshake extension", uint32(rejRogue)
+			if kmreq || hs.extension&extFlagKMREQ != 0 {
+				reason, code = "encryption is not supported; remove the passphrase", rejUnsecure
+			}
+			log.Printf("SRT: Rejected caller %s: %s", addr, reason)
+			resp.typ = hsRejectBase + code
+			resp.extensions = nil
+			pc.WriteTo(controlPacket(ctrlHandshake, 0, 0, hs.socketID, resp.marshal()), addr)
+			return
+		}
+
+		// Agree on the larger latency; the caller's sender delay is ours to receive
+		delays := binary.BigEndian.Uint32(hsreq[8:12])
+		recvDelay := max(uint32(s.cfg.Latency.Milliseconds()), delays&0xFFFF)
+		sendDelay := delays >> 16
+
+		rsp := make([]byte, 12)
+		binary.BigEndian.PutUint32(rsp[0:4], srtVersion)
+		binary.BigEndian.PutUint32(rsp[4:8], flagTSBPDSND|flagTSBPDRCV|flagCRYPT|flagTLPKTDROP|flagREXMIT)
+		binary.BigEndian.PutUint32(rsp[8:12], recvDelay<<16|sendDelay)
+		resp.extension = extFlagHSREQ
+		resp.extensions[extHSRSP] = rsp
+
+		c := &peer{
+			addr:     addr,
+			socketID: hs.socketID,
+			ourID:    resp.socketID,
+			start:    now,
+			next:     hs.initialSeq,
+			highest:  seqAdd(hs.initialSeq, -1),
+			acked:    hs.initialSeq,
+			buffered: make(map[uint32][]byte),
+			lastRecv: now,
+			lastSent: now,
+		}
+		c.packetizer = video.NewH264Packetizer()
+		c.demux = newTSDemuxer(func(au []byte, dts int64) { s.accessUnit(c, au, dts) })
+		c.response = controlPacket(ctrlHandshake, 0, 0, hs.socketID, resp.marshal())
+		pc.WriteTo(c.response, addr)
+
+		if s.peer != nil {
+			log.Printf("SRT: New caller from %s replaces the previous one", addr)
+			s.sendControl(pc, s.peer, ctrlShutdown, 0, nil)
+		} else {
+			log.Printf("SRT: Camera at %s connected (latency %dms)", addr, recvDelay)
+		}
+		s.peer = c
+	}
+}
+
+// cookie derives the SYN cookie for a caller from its address and the minute
+func (s *Source) cookie(addr net.Addr, t time.Time) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(s.secret[:])
+	h.Write([]byte(addr.String()))
+	binary.Write(h, binary.BigEndian, t.Unix()/60)
+	return h.Sum32()
+}
+
+// receiveData buffers a data packet, reports gaps and delivers what is in order
+func (s *Source) receiveData(pc net.PacketConn, c *peer, seq uint32, payload []byte) {
+	if seqDiff(seq, c.next) < 0 {
+		return // Duplicate or already skipped
+	}
+	if _, ok := c.buffered[seq]; ok {
+		return
+	}
+
+	if d := seqDiff(seq, c.highest); d > 1 {
+		// Report the missing range so the caller retransmits it
+		first, last := seqAdd(c.highest, 1), seqAdd(seq, -1)
+		var loss []byte
+		if first == last {
+			loss = binary.BigEndian.AppendUint32(loss, first)
+		} else {
+			loss = binary.BigEndian.AppendUint32(loss, first|0x80000000)
+			loss = binary.BigEndian.AppendUint32(loss, last)
+		}
+		s.sendControl(pc, c, ctrlNAK, 0, loss)
+	}
+	if seqDiff(seq, c.highest) > 0 {
+		c.highest = seq
+	}
+
+	c.buffered[seq] = append([]byte(nil), payload...)
+	s.deliver(c)
+	if len(c.buffered) > 0 && c.gapSince.IsZero() {
+		c.gapSince = time.Now()
+	}
+}
+
+// deliver passes buffered packets to the demuxer in sequence order
+func (s *Source) deliver(c *peer) {
+	for {
+		data, ok := c.buffered[c.next]
+		if !ok {
+			return
+		}
+		delete(c.buffered, c.next)
+		c.next = seqAdd(c.next, 1)
+		c.gapSince = time.Time{}
+		c.demux.write(data)
+	}
+}
+
+// skipGap gives up on the missing packets before the oldest buffered one
+func (s *Source) skipGap(c *peer) {
+	oldest := c.highest
+	for seq := range c.buffered {
+		if seqDiff(seq, oldest) < 0 {
+			oldest = seq
+		}
+	}
+	c.next = oldest
+	c.demux.reset()
+	s.deliver(c)
+	if len(c.buffered) > 0 {
+		c.gapSince = time.Now()
+	}
+}
+
+// tick runs the periodic work: ACKs, dropping late packets, keepalives and
+// the peer timeout
+func (s *Source) tick(pc net.PacketConn, now time.Time) {
+	c := s.peer
+	if c == nil {
+		return
+	}
+	if now.Sub(c.lastRecv) > peerTimeout {
+		log.Printf("SRT: Caller %s timed out", c.addr)
+		s.peer = nil
+		return
+	}
+
+	if len(c.buffered) > 0 && (now.Sub(c.gapSince) > s.cfg.Latency || len(c.buffered) > maxBuffered) {
+		s.skipGap(c)
+	}
+
+	if c.next != c.acked {
+		c.acked = c.next
+		c.ackNumber++
+		ack := make([]byte, 28)
+		binary.BigEndian.PutUint32(ack[0:4], c.next)
+		binary.BigEndian.PutUint32(ack[4:8], initialRTT)
+		binary.BigEndian.PutUint32(ack[8:12], initialRTTVar)
+		binary.BigEndian.PutUint32(ack[12:16], uint32(maxBuffered-len(c.buffered)))
+		s.sendControl(pc, c, ctrlACK, c.ackNumber, ack)
+	} else if now.Sub(c.lastSent) >= keepalive {
+		s.sendControl(pc, c, ctrlKeepalive, 0, nil)
+	}
+}
+
+func (s *Source) sendControl(pc net.PacketConn, c *peer, typ uint16, info uint32, cif []byte) {
+	now := time.Now()
+	c.lastSent = now
+	ts := uint32(now.Sub(c.start).Microseconds())
+	pc.WriteTo(controlPacket(typ, info, ts, c.socketID, cif), c.addr)
+}
+
+// accessUnit packetizes an H.264 access unit, timed by its DTS
+func (s *Source) accessUnit(c *peer, au []byte, dts int64) {
+	s.mu.Lock()
+	s.codec = video.CodecH264
+	s.mu.Unlock()
+
+	now := time.Now()
+	if c.clock.IsZero() || dts < 0 {
+		c.clock = now
+	} else {
+		delta := (dts - c.lastDTS) & (1<<33 - 1)
+		if delta > maxTSJump {
+			c.clock = now
+		} else {
+			c.clock = c.clock.Add(time.Duration(delta) * time.Second / clockRate)
+		}
+	}
+	c.lastDTS = dts
+
+	for _, packet := range c.packetizer.Packetize(au, c.clock) {
+		select {
+		case s.rtpChan <- packet:
+		case <-s.stopCh:
+		default:
+			// Drop packet if channel full
+		}
+	}
+}
+
+func randomID() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])&0x7FFFFFFF | 1
+}
+
+// Codec returns the caller's codec, or "" before one connects
+func (s *Source) Codec() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codec
+}
+
+// RTPChannel returns the channel for receiving RTP packets
+func (s *Source) RTPChannel() <-chan []byte {
+	return s.rtpChan
+}
+
+// Close tells the caller to disconnect and stops listening
+func (s *Source) Close() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	pc := s.pc
+	s.mu.Unlock()
+
+	close(s.stopCh)
+	if pc != nil {
+		<-s.done
+		pc.Close()
+	}
+	close(s.rtpChan)
+	return nil
+}
@@ -0,0 +1,170 @@
+package testpattern
+
+import "math/bits"
+
+// NAL unit types
+const (
+	nalIDR = 5
+	nalSPS = 7
+	nalPPS = 8
+)
+
+// pcmEncoder writes frames as H.264 (Constrained Baseline) IDR pictures made
+// of I_PCM macroblocks: the samples are stored uncompressed, so no transform
+// or entropy coding is needed and decoded pixels match exactly
+type pcmEncoder struct {
+	width, height int // Multiples of 16
+	idrPicID      uint
+}
+
+// encode returns an access unit (Annex-B) with SPS, PPS and one IDR slice
+// for a 4:2:0 frame
+func (e *pcmEncoder) encode(y, cb, cr []byte) []byte {
+	var au []byte
+	au = appendNAL(au, nalSPS, e.sps())
+	au = appendNAL(au, nalPPS, e.pps())
+	au = appendNAL(au, nalIDR, e.slice(y, cb, cr))
+	e.idrPicID ^= 1 // Consecutive IDR pictures need different IDs
+	return au
+}
+
+func (e *pcmEncoder) sps() []byte {
+	var w bitWriter
+	w.bits(66, 8)   // profile_idc: Baseline
+	w.bits(0xC0, 8) // constraint_set0/1: Constrained Baseline
+	w.bits(30, 8)   // level_idc 3.0
+	w.ue(0)         // seq_parameter_set_id
+	w.ue(0)         // log2_max_frame_num_minus4
+	w.ue(2)         // pic_order_cnt_type: output order is decoding order
+	w.ue(0)         // max_num_ref_frames
+	w.bits(0, 1)    // gaps_in_frame_num_value_allowed_flag
+	w.ue(uint(e.width/16 - 1))
+	w.ue(uint(e.height/16 - 1))
+	w.bits(1, 1) // frame_mbs_only_flag
+	w.bits(1, 1) // direct_8x8_inference_flag
+	w.bits(0, 1) // frame_cropping_flag
+	w.bits(0, 1) // vui_parameters_present_flag
+	w.trailing()
+	return w.buf
+}
+
+func (e *pcmEncoder) pps() []byte {
+	var w bitWriter
+	w.ue(0)      // pic_parameter_set_id
+	w.ue(0)      // seq_parameter_set_id
+	w.bits(0, 1) // entropy_coding_mode_flag: CAVLC
+	w.bits(0, 1) // bottom_field_pic_order_in_frame_present_flag
+	w.ue(0)      // num_slice_groups_minus1
+	w.ue(0)      // num_ref_idx_l0_default_active_minus1
+	w.ue(0)      // num_ref_idx_l1_default_active_minus1
+	w.bits(0, 1) // weighted_pred_flag
+	w.bits(0, 2) // weighted_bipred_idc
+	w.se(0)      // pic_init_qp_minus26
+	w.se(0)      // pic_init_qs_minus26
+	w.se(0)      // chroma_qp_index_offset
+	w.bits(1, 1) // deblocking_filter_control_present_flag
+	w.bits(0, 1) // constrained_intra_pred_flag
+	w.bits(0, 1) // redundant_pic_cnt_present_flag
+	w.trailing()
+	return w.buf
+}
+
+func (e *pcmEncoder) slice(y, cb, cr []byte) []byte {
+	var w bitWriter
+	w.ue(0)          // first_mb_in_slice
+	w.ue(7)          // slice_type: I, all slices of the picture
+	w.ue(0)          // pic_parameter_set_id
+	w.bits(0, 4)     // frame_num
+	w.ue(e.idrPicID) // idr_pic_id
+	w.bits(0, 1)     // no_output_of_prior_pics_flag
+	w.bits(0, 1)     // long_term_reference_flag
+	w.se(0)          // slice_qp_delta
+	w.ue(1)          // disable_deblocking_filter_idc: off, so samples stay exact
+
+	cw := e.width / 2
+	for mby := 0; mby < e.height/16; mby++ {
+		for mbx := 0; mbx < e.width/16; mbx++ {
+			w.ue(25) // mb_type I_PCM
+			w.align()
+			for row := 0; row < 16; row++ {
+				off := (mby*16+row)*e.width + mbx*16
+				w.buf = append(w.buf, y[off:off+16]...)
+			}
+			for _, plane := range [][]byte{cb, cr} {
+				for row := 0; row < 8; row++ {
+					off := (mby*8+row)*cw + mbx*8
+					w.buf = append(w.buf, plane[off:off+8]...)
+				}
+			}
+		}
+	}
+	w.trailing()
+	return w.buf
+}
+
+// appendNAL appends a start code and the NAL unit, with emulation
+// prevention bytes inserted into the RBSP
+func appendNAL(au []byte, nalType byte, rbsp []byte) []byte {
+	au = append(au, 0, 0, 0, 1, 3<<5|nalType)
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			au = append(au, 3)
+			zeros = 0
+		}
+		au = append(au, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return au
+}
+
+// bitWriter writes the bit fields of an RBSP, most significant bit first
+type bitWriter struct {
+	buf  []byte
+	cur  byte
+	nbit int // Bits used in cur
+}
+
+func (w *bitWriter) bits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>i&1)
+		if w.nbit++; w.nbit == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.nbit = 0, 0
+		}
+	}
+}
+
+// ue writes an unsigned Exp-Golomb code
+func (w *bitWriter) ue(v uint) {
+	x := uint64(v) + 1
+	n := bits.Len64(x)
+	w.bits(0, n-1)
+	w.bits(x, n)
+}
+
+// se writes a signed Exp-Golomb code
+func (w *bitWriter) se(v int) {
+	if v > 0 {
+		w.ue(uint(2*v - 1))
+	} else {
+		w.ue(uint(-2 * v))
+	}
+}
+
+// align pads with zero bits to a byte boundary
+func (w *bitWriter) align() {
+	if w.nbit > 0 {
+		w.bits(0, 8-w.nbit)
+	}
+}
+
+// trailing writes the RBSP stop bit and alignment
+func (w *bitWriter) trailing() {
+	w.bits(1, 1)
+	w.align()
+}
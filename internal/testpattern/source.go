@@ -0,0 +1,168 @@
+package testpattern
+
+import (
+	"sync"
+	"time"
+
+	"ptz-remote/internal/video"
+)
+
+// Pattern size, in pixels. I_PCM frames are uncompressed (23 KB each), so
+// the pattern is small: about 2.8 Mbit/s at 15 fps.
+const (
+	width  = 160
+	height = 96
+)
+
+// Timestamp code layout: codeBits cells of cellSize pixels, codeColumns per
+// row, from the top left corner. White is 1.
+const (
+	cellSize    = 8
+	codeColumns = width / cellSize
+	codeBits    = 60 // 48-bit Unix time in milliseconds, then a 12-bit check
+)
+
+// Sample values (limited range)
+const (
+	black   = 16
+	white   = 235
+	neutral = 128 // Chroma without color
+)
+
+// Config for the test pattern source
+type Config struct {
+	FPS int // Default 15
+}
+
+// Source generates an H.264 test pattern carrying the time each frame was
+// made, so clients can measure glass-to-glass latency: the time from the
+// frame leaving the source to being displayed, by reading the code off the
+// decoded video. A bar moving across the frame shows motion and stalls.
+type Source struct {
+	cfg     Config
+	rtpChan chan []byte
+	stopCh  chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	started bool
+}
+
+// NewSource creates a test pattern source; frames start with Connect
+func NewSource(cfg Config) (*Source, error) {
+	if cfg.FPS <= 0 {
+		cfg.FPS = 15
+	}
+	return &Source{
+		cfg:     cfg,
+		rtpChan: make(chan []byte, 500),
+		stopCh:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Connect starts generating frames
+func (s *Source) Connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		s.started = true
+		go s.run()
+	}
+	return nil
+}
+
+// Codec returns CodecH264
+func (s *Source) Codec() string {
+	return video.CodecH264
+}
+
+// RTPChannel returns the channel of RTP packets
+func (s *Source) RTPChannel() <-chan []byte {
+	return s.rtpChan
+}
+
+// Close stops the pattern and closes the RTP channel
+func (s *Source) Close() error {
+	s.mu.Lock()
+	started := s.started
+	s.started = true // No run after Close
+	s.mu.Unlock()
+
+	close(s.stopCh)
+	if started {
+		<-s.done
+	}
+	close(s.rtpChan)
+	return nil
+}
+
+func (s *Source) run() {
+	defer close(s.done)
+
+	enc := &pcmEncoder{width: width, height: height}
+	packetizer := video.NewH264Packetizer()
+	y := make([]byte, width*height)
+	cb := make([]byte, width*height/4)
+	cr := make([]byte, width*height/4)
+
+	ticker := time.NewTicker(time.Second / time.Duration(s.cfg.FPS))
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		draw(y, cb, cr, frame, now)
+		for _, packet := range packetizer.Packetize(enc.encode(y, cb, cr), now) {
+			select {
+			case s.rtpChan <- packet:
+			case <-s.stopCh:
+				video.ReleasePacket(packet)
+				return
+			}
+		}
+	}
+}
+
+// draw renders a frame: a gray ramp, a moving bar, and the timestamp code
+func draw(y, cb, cr []byte, frame int, t time.Time) {
+	bar := frame * 4 % width
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			v := byte(48 + col*144/width)
+			if col >= bar && col < bar+8 {
+				v = white
+			}
+			y[row*width+col] = v
+		}
+	}
+	for i := range cb {
+		cb[i], cr[i] = neutral, neutral
+	}
+
+	bits := code(t.UnixMilli())
+	for i := 0; i < codeBits; i++ {
+		v := byte(black)
+		if bits>>(codeBits-1-i)&1 == 1 {
+			v = white
+		}
+		x0, y0 := i%codeColumns*cellSize, i/codeColumns*cellSize
+		for row := y0; row < y0+cellSize; row++ {
+			for col := x0; col < x0+cellSize; col++ {
+				y[row*width+col] = v
+			}
+		}
+	}
+}
+
+// code returns the codeBits-bit code for a Unix time in milliseconds: the 48
+// time bits followed by the XOR of their four 12-bit groups
+func code(ms int64) uint64 {
+	t := uint64(ms) & (1<<48 - 1)
+	check := (t ^ t>>12 ^ t>>24 ^ t>>36) & 0xFFF
+	return t<<12 | check
+}
@@ -0,0 +1,145 @@
+package turn
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	pturn "github.com/pion/turn/v2"
+)
+
+// Config for the embedded TURN server
+type Config struct {
+	Address  string        // UDP and TCP listen address, default ":3478"
+	PublicIP string        // Address clients reach the server at, given out as the relay address
+	Secret   string        // Shared secret for the credentials; random if empty
+	TTL      time.Duration // Credential lifetime, default 24h
+	Realm    string        // Default "ptz-remote"
+}
+
+// Server is a TURN relay for clients behind symmetric NATs or firewalls
+// that block UDP. Credentials are time-limited (TURN REST API style): the
+// username is the expiry time and the password its HMAC-SHA1 under the
+// shared secret, so the server keeps no user list. Relays are only
+// permitted to this host's own addresses, so the server is not an open
+// relay for anyone holding credentials.
+type Server struct {
+	cfg    Config
+	port   int
+	server *pturn.Server
+}
+
+// NewServer starts a TURN server
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Address == "" {
+		cfg.Address = ":3478"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = "ptz-remote"
+	}
+	if cfg.Secret == "" {
+		var b [32]byte
+		rand.Read(b[:])
+		cfg.Secret = hex.EncodeToString(b[:])
+	}
+	publicIP := net.ParseIP(cfg.PublicIP)
+	if publicIP == nil {
+		return nil, fmt.Errorf("invalid TURN public IP %q", cfg.PublicIP)
+	}
+	_, portStr, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TURN address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TURN port %q", portStr)
+	}
+
+	udp, err := net.ListenPacket("udp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+
+	permit := localPeers(publicIP)
+	relay := func() pturn.RelayAddressGenerator {
+		return &pturn.RelayAddressGeneratorStatic{RelayAddress: publicIP, Address: "0.0.0.0"}
+	}
+	server, err := pturn.NewServer(pturn.ServerConfig{
+		Realm:       cfg.Realm,
+		AuthHandler: pturn.NewLongTermAuthHandler(cfg.Secret, nil),
+		PacketConnConfigs: []pturn.PacketConnConfig{{
+			PacketConn:            udp,
+			RelayAddressGenerator: relay(),
+			PermissionHandler:     permit,
+		}},
+		ListenerConfigs: []pturn.ListenerConfig{{
+			Listener:              tcp,
+			RelayAddressGenerator: relay(),
+			PermissionHandler:     permit,
+		}},
+	})
+	if err != nil {
+		udp.Close()
+		tcp.Close()
+		return nil, fmt.Errorf("failed to start TURN server: %w", err)
+	}
+
+	log.Printf("TURN: Listening on %s (relay address %s)", cfg.Address, publicIP)
+	return &Server{cfg: cfg, port: port, server: server}, nil
+}
+
+// URLs returns the server's TURN URLs for clients, UDP first
+func (s *Server) URLs() []string {
+	hostPort := net.JoinHostPort(s.cfg.PublicIP, strconv.Itoa(s.port))
+	return []string{
+		"turn:" + hostPort + "?transport=udp",
+		"turn:" + hostPort + "?transport=tcp",
+	}
+}
+
+// Credentials returns a new username and password, valid for the TTL. An
+// allocation made with them can't be refreshed after they expire.
+func (s *Server) Credentials() (username, password string) {
+	username, password, err := pturn.GenerateLongTermCredentials(s.cfg.Secret, s.cfg.TTL)
+	if err != nil {
+		log.Printf("Warning: TURN: Failed to generate credentials: %v", err)
+	}
+	return username, password
+}
+
+// Close stops the server and its relays
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// localPeers permits relaying to the public IP and the addresses of this
+// host's interfaces, where the WebRTC sessions' candidates are
+func localPeers(publicIP net.IP) pturn.PermissionHandler {
+	return func(_ net.Addr, peer net.IP) bool {
+		if peer.Equal(publicIP) || peer.IsLoopback() {
+			return true
+		}
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return false
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(peer) {
+				return true
+			}
+		}
+		return false
+	}
+}
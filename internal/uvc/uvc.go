@@ -0,0 +1,390 @@
+package uvc
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/ptz"
+	"ptz-remote/internal/v4l2"
+)
+
+const minInterval = 50 * time.Millisecond // ~20 commands/sec max
+
+// jogRange is the fraction of an absolute control's range covered per second
+// at full speed when an axis has no speed control
+const jogRange = 0.4
+
+// axis is a UVC control; ok is false if the camera doesn't have it
+type axis struct {
+	v4l2.Control
+	ok bool
+}
+
+// position is an absolute pan/tilt/zoom position, used for presets
+type position struct {
+	pan, tilt, zoom int32
+}
+
+// Controller drives a USB camera's pan/tilt/zoom through UVC camera
+// terminal controls. Axes without a speed control are jogged by stepping
+// their absolute control.
+type Controller struct {
+	dev    *v4l2.Device
+	devMu  sync.Mutex // Serializes control reads and writes, guards closed
+	closed bool
+	stopCh chan struct{}
+
+	panSpeed, tiltSpeed, zoomContinuous axis
+	panAbs, tiltAbs, zoomAbs            axis
+
+	// Jog state for axes driven through absolute controls
+	jogMu      sync.Mutex
+	jog        struct{ pan, tilt, zoom float64 } // -1.0 to 1.0
+	jogPos     struct{ pan, tilt, zoom float64 }
+	jogRunning bool
+
+	presetsMu sync.Mutex
+	presets   map[int]position // In-memory; UVC cameras have no preset storage
+
+	// Combined pan/tilt/zoom velocity
+	move struct {
+		ptz.Throttle
+		pending, sent struct{ pan, tilt, zoom float64 }
+	}
+}
+
+// Config for UVC controller
+type Config struct {
+	Device string // V4L2 device node of the camera, e.g. "/dev/video0"
+}
+
+// NewController opens the camera and looks up its PTZ controls
+func NewController(cfg Config) (*Controller, error) {
+	if cfg.Device == "" {
+		return nil, fmt.Errorf("video device is required")
+	}
+
+	dev, err := v4l2.Open(cfg.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Controller{
+		dev:     dev,
+		stopCh:  make(chan struct{}),
+		presets: make(map[int]position),
+	}
+	c.panSpeed = c.query(v4l2.CIDPanSpeed)
+	c.tiltSpeed = c.query(v4l2.CIDTiltSpeed)
+	c.zoomContinuous = c.query(v4l2.CIDZoomContinuous)
+	c.panAbs = c.query(v4l2.CIDPanAbsolute)
+	c.tiltAbs = c.query(v4l2.CIDTiltAbsolute)
+	c.zoomAbs = c.query(v4l2.CIDZoomAbsolute)
+
+	caps := c.Capabilities()
+	if !caps.PanTilt && !caps.Zoom {
+		dev.Close()
+		return nil, fmt.Errorf("%s has no UVC pan/tilt/zoom controls", cfg.Device)
+	}
+
+	// Wire up throttle flush callback
+	c.move.Interval = minInterval
+	c.move.StopCh = c.stopCh
+	c.move.Flush = func() {
+		if c.move.pending != c.move.sent {
+			p := c.move.pending
+			c.sendMove(p.pan, p.tilt, p.zoom)
+			c.move.sent = c.move.pending
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Controller) query(id uint32) axis {
+	ctrl, err := c.dev.QueryControl(id)
+	if err != nil || ctrl.Max <= ctrl.Min {
+		return axis{}
+	}
+	return axis{Control: ctrl, ok: true}
+}
+
+// Close closes the device
+func (c *Controller) Close() error {
+	close(c.stopCh)
+	c.devMu.Lock()
+	defer c.devMu.Unlock()
+	c.closed = true
+	return c.dev.Close()
+}
+
+// PanTilt sends a pan/tilt command
+// pan: -1.0 (left) to 1.0 (right)
+// tilt: -1.0 (down) to 1.0 (up)
+func (c *Controller) PanTilt(pan, tilt float64) error {
+	c.move.Lock()
+	c.move.pending.pan = deadzone(pan)
+	c.move.pending.tilt = deadzone(tilt)
+	changed := c.move.pending != c.move.sent
+	c.move.Unlock()
+
+	if changed {
+		c.move.Trigger()
+	}
+	return nil
+}
+
+// Zoom sends a zoom command
+// zoom: -1.0 (wide/out) to 1.0 (tele/in)
+func (c *Controller) Zoom(zoom float64) error {
+	c.move.Lock()
+	c.move.pending.zoom = deadzone(zoom)
+	changed := c.move.pending != c.move.sent
+	c.move.Unlock()
+
+	if changed {
+		c.move.Trigger()
+	}
+	return nil
+}
+
+// Stop stops all PTZ movement immediately
+func (c *Controller) Stop() error {
+	c.move.Lock()
+	c.move.pending = struct{ pan, tilt, zoom float64 }{}
+	c.move.sent = c.move.pending
+	c.move.Unlock()
+
+	c.sendMove(0, 0, 0)
+	return nil
+}
+
+// RecallPreset moves to a position saved with SavePreset (0-255)
+func (c *Controller) RecallPreset(preset int) error {
+	if err := c.Capabilities().CheckPreset(preset); err != nil {
+		return err
+	}
+
+	c.presetsMu.Lock()
+	pos, ok := c.presets[preset]
+	c.presetsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("preset %d not saved", preset)
+	}
+
+	c.Stop()
+	c.devMu.Lock()
+	defer c.devMu.Unlock()
+	if c.closed {
+		return fmt.Errorf("controller closed")
+	}
+	if c.panAbs.ok {
+		c.dev.SetControl(c.panAbs.ID, pos.pan)
+	}
+	if c.tiltAbs.ok {
+		c.dev.SetControl(c.tiltAbs.ID, pos.tilt)
+	}
+	if c.zoomAbs.ok {
+		c.dev.SetControl(c.zoomAbs.ID, pos.zoom)
+	}
+	return nil
+}
+
+// SavePreset stores the current absolute position under a preset number (0-255).
+// Presets are kept in memory only.
+func (c *Controller) SavePreset(preset int) error {
+	if err := c.Capabilities().CheckPreset(preset); err != nil {
+		return err
+	}
+
+	c.devMu.Lock()
+	if c.closed {
+		c.devMu.Unlock()
+		return fmt.Errorf("controller closed")
+	}
+	pos := position{
+		pan:  int32(c.getAbs(c.panAbs)),
+		tilt: int32(c.getAbs(c.tiltAbs)),
+		zoom: int32(c.getAbs(c.zoomAbs)),
+	}
+	c.devMu.Unlock()
+
+	c.presetsMu.Lock()
+	c.presets[preset] = pos
+	c.presetsMu.Unlock()
+	return nil
+}
+
+// Capabilities describes the UVC controls the camera exposes
+func (c *Controller) Capabilities() ptz.Capabilities {
+	caps := ptz.Capabilities{
+		PanTilt: (c.panSpeed.ok || c.panAbs.ok) && (c.tiltSpeed.ok || c.tiltAbs.ok),
+		Zoom:    c.zoomContinuous.ok || c.zoomAbs.ok,
+		Presets: c.panAbs.ok || c.tiltAbs.ok || c.zoomAbs.ok,
+	}
+	if caps.Presets {
+		caps.PresetMin, caps.PresetMax = 0, 255
+	}
+	if c.panSpeed.ok {
+		caps.PanSpeedSteps = int(c.panSpeed.Max)
+	}
+	if c.tiltSpeed.ok {
+		caps.TiltSpeedSteps = int(c.tiltSpeed.Max)
+	}
+	if c.zoomContinuous.ok {
+		caps.ZoomSpeedSteps = int(c.zoomContinuous.Max)
+	}
+	return caps
+}
+
+// sendMove sets the speed controls, and jogs the axes that only have
+// absolute controls
+func (c *Controller) sendMove(pan, tilt, zoom float64) {
+	var jogPan, jogTilt, jogZoom float64
+
+	c.devMu.Lock()
+	if c.closed {
+		c.devMu.Unlock()
+		return
+	}
+	if c.panSpeed.ok {
+		c.setSpeed(c.panSpeed, pan)
+	} else {
+		jogPan = pan
+	}
+	if c.tiltSpeed.ok {
+		c.setSpeed(c.tiltSpeed, tilt)
+	} else {
+		jogTilt = tilt
+	}
+	if c.zoomContinuous.ok {
+		c.setSpeed(c.zoomContinuous, zoom)
+	} else {
+		jogZoom = zoom
+	}
+	c.devMu.Unlock()
+
+	c.setJog(jogPan, jogTilt, jogZoom)
+}
+
+// setSpeed scales v (-1.0 to 1.0) to a signed speed control's range.
+// Called with devMu held.
+func (c *Controller) setSpeed(a axis, v float64) {
+	limit := a.Max
+	if v < 0 {
+		limit = -a.Min
+	}
+	speed := int32(math.Round(v * float64(limit)))
+	if v != 0 && speed == 0 {
+		// Smallest non-zero speed in the requested direction
+		speed = 1
+		if v < 0 {
+			speed = -1
+		}
+	}
+	if err := c.dev.SetControl(a.ID, speed); err != nil {
+		log.Printf("UVC: %s: %v", a.Name, err)
+	}
+}
+
+// setJog updates the jog velocities, starting the jog loop if needed
+func (c *Controller) setJog(pan, tilt, zoom float64) {
+	c.jogMu.Lock()
+	defer c.jogMu.Unlock()
+
+	c.jog.pan, c.jog.tilt, c.jog.zoom = pan, tilt, zoom
+	if c.jogRunning || (pan == 0 && tilt == 0 && zoom == 0) {
+		return
+	}
+
+	// Start from the current position
+	c.devMu.Lock()
+	c.jogPos.pan = c.getAbs(c.panAbs)
+	c.jogPos.tilt = c.getAbs(c.tiltAbs)
+	c.jogPos.zoom = c.getAbs(c.zoomAbs)
+	c.devMu.Unlock()
+
+	c.jogRunning = true
+	go c.jogLoop()
+}
+
+// jogLoop steps absolute controls while a jog velocity is non-zero
+func (c *Controller) jogLoop() {
+	ticker := time.NewTicker(minInterval)
+	defer ticker.Stop()
+
+	step := jogRange * minInterval.Seconds()
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		c.jogMu.Lock()
+		jog := c.jog
+		if jog.pan == 0 && jog.tilt == 0 && jog.zoom == 0 {
+			c.jogRunning = false
+			c.jogMu.Unlock()
+			return
+		}
+
+		c.devMu.Lock()
+		if c.closed {
+			c.devMu.Unlock()
+			c.jogMu.Unlock()
+			return
+		}
+		if jog.pan != 0 && c.panAbs.ok {
+			c.jogPos.pan = c.stepAbs(c.panAbs, c.jogPos.pan, jog.pan*step)
+		}
+		if jog.tilt != 0 && c.tiltAbs.ok {
+			c.jogPos.tilt = c.stepAbs(c.tiltAbs, c.jogPos.tilt, jog.tilt*step)
+		}
+		if jog.zoom != 0 && c.zoomAbs.ok {
+			c.jogPos.zoom = c.stepAbs(c.zoomAbs, c.jogPos.zoom, jog.zoom*step)
+		}
+		c.devMu.Unlock()
+		c.jogMu.Unlock()
+	}
+}
+
+// getAbs reads an absolute control, or 0 if the camera doesn't have it.
+// Called with devMu held.
+func (c *Controller) getAbs(a axis) float64 {
+	if !a.ok {
+		return 0
+	}
+	v, err := c.dev.GetControl(a.ID)
+	if err != nil {
+		return float64(a.Default)
+	}
+	return float64(v)
+}
+
+// stepAbs moves an absolute control by fraction of its range and returns the
+// new (unrounded) position. Called with devMu held.
+func (c *Controller) stepAbs(a axis, pos, fraction float64) float64 {
+	pos += fraction * float64(a.Max-a.Min)
+	pos = math.Max(float64(a.Min), math.Min(float64(a.Max), pos))
+
+	value := int32(math.Round(pos))
+	if a.Step > 1 {
+		value = a.Min + (value-a.Min)/a.Step*a.Step
+	}
+	if err := c.dev.SetControl(a.ID, value); err != nil {
+		log.Printf("UVC: %s: %v", a.Name, err)
+	}
+	return pos
+}
+
+// deadzone zeroes small values so the camera fully stops
+func deadzone(v float64) float64 {
+	if v > -0.05 && v < 0.05 {
+		return 0
+	}
+	return math.Max(-1, math.Min(1, v))
+}
@@ -0,0 +1,27 @@
+package v4l2
+
+// Camera class control IDs from linux/v4l2-controls.h (UVC camera terminal controls)
+const (
+	cidCameraClassBase = 0x009a0900
+
+	CIDPanRelative    = cidCameraClassBase + 4
+	CIDTiltRelative   = cidCameraClassBase + 5
+	CIDPanAbsolute    = cidCameraClassBase + 8 // arc seconds
+	CIDTiltAbsolute   = cidCameraClassBase + 9 // arc seconds
+	CIDFocusAbsolute  = cidCameraClassBase + 10
+	CIDFocusAuto      = cidCameraClassBase + 12
+	CIDZoomAbsolute   = cidCameraClassBase + 13
+	CIDZoomContinuous = cidCameraClassBase + 15 // signed speed, 0 = stop
+	CIDPanSpeed       = cidCameraClassBase + 32 // signed speed, 0 = stop
+	CIDTiltSpeed      = cidCameraClassBase + 33 // signed speed, 0 = stop
+)
+
+// Control describes an integer control as reported by VIDIOC_QUERYCTRL
+type Control struct {
+	ID      uint32
+	Name    string
+	Min     int32
+	Max     int32
+	Step    int32
+	Default int32
+}
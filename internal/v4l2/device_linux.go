@@ -0,0 +1,363 @@
+//go:build linux
+
+package v4l2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Kernel ABI constants from linux/videodev2.h
+const (
+	bufTypeVideoCapture = 1
+	memoryMMAP          = 1
+
+	capVideoCapture = 0x00000001
+	capStreaming    = 0x04000000
+	capDeviceCaps   = 0x80000000
+)
+
+// v4l2_capability
+type capability struct {
+	driver       [16]byte
+	card         [32]byte
+	busInfo      [32]byte
+	version      uint32
+	capabilities uint32
+	deviceCaps   uint32
+	reserved     [3]uint32
+}
+
+// v4l2_pix_format, the capture member of the v4l2_format union
+type pixFormat struct {
+	width        uint32
+	height       uint32
+	pixelFormat  uint32
+	field        uint32
+	bytesPerLine uint32
+	sizeImage    uint32
+	colorspace   uint32
+	priv         uint32
+	flags        uint32
+	encoding     uint32
+	quantization uint32
+	xferFunc     uint32
+}
+
+// v4l2_format; the union contains pointers, so it is pointer aligned
+type format struct {
+	typ uint32
+	fmt struct {
+		_   [0]uintptr
+		raw [200]byte
+	}
+}
+
+// v4l2_captureparm, the capture member of the v4l2_streamparm union
+type captureParm struct {
+	capability   uint32
+	captureMode  uint32
+	numerator    uint32 // timeperframe
+	denominator  uint32
+	extendedMode uint32
+	readBuffers  uint32
+	reserved     [4]uint32
+}
+
+// v4l2_streamparm
+type streamParm struct {
+	typ  uint32
+	parm [200]byte
+}
+
+// v4l2_queryctrl
+type queryCtrl struct {
+	id           uint32
+	typ          uint32
+	name         [32]byte
+	minimum      int32
+	maximum      int32
+	step         int32
+	defaultValue int32
+	flags        uint32
+	reserved     [2]uint32
+}
+
+// v4l2_control
+type control struct {
+	id    uint32
+	value int32
+}
+
+const ctrlFlagDisabled = 0x0001
+
+// v4l2_requestbuffers
+type requestBuffers struct {
+	count        uint32
+	typ          uint32
+	memory       uint32
+	capabilities uint32
+	flags        uint8
+	reserved     [3]uint8
+}
+
+// v4l2_timecode
+type timecode struct {
+	typ      uint32
+	flags    uint32
+	frames   uint8
+	seconds  uint8
+	minutes  uint8
+	hours    uint8
+	userBits [4]uint8
+}
+
+// v4l2_buffer
+type buffer struct {
+	index     uint32
+	typ       uint32
+	bytesUsed uint32
+	flags     uint32
+	field     uint32
+	timestamp unix.Timeval
+	timecode  timecode
+	sequence  uint32
+	memory    uint32
+	offset    uintptr // union m: offset for MMAP buffers
+	length    uint32
+	reserved2 uint32
+	requestFD int32
+}
+
+// ioctl request numbers, _IOR/_IOW/_IOWR('V', nr, type)
+var (
+	vidiocQueryCap  = ioc(iocRead, 0, unsafe.Sizeof(capability{}))
+	vidiocSFmt      = ioc(iocRead|iocWrite, 5, unsafe.Sizeof(format{}))
+	vidiocReqBufs   = ioc(iocRead|iocWrite, 8, unsafe.Sizeof(requestBuffers{}))
+	vidiocQueryBuf  = ioc(iocRead|iocWrite, 9, unsafe.Sizeof(buffer{}))
+	vidiocQBuf      = ioc(iocRead|iocWrite, 15, unsafe.Sizeof(buffer{}))
+	vidiocDQBuf     = ioc(iocRead|iocWrite, 17, unsafe.Sizeof(buffer{}))
+	vidiocStreamOn  = ioc(iocWrite, 18, unsafe.Sizeof(int32(0)))
+	vidiocStreamOff = ioc(iocWrite, 19, unsafe.Sizeof(int32(0)))
+	vidiocSParm     = ioc(iocRead|iocWrite, 22, unsafe.Sizeof(streamParm{}))
+	vidiocGCtrl     = ioc(iocRead|iocWrite, 27, unsafe.Sizeof(control{}))
+	vidiocSCtrl     = ioc(iocRead|iocWrite, 28, unsafe.Sizeof(control{}))
+	vidiocQueryCtrl = ioc(iocRead|iocWrite, 36, unsafe.Sizeof(queryCtrl{}))
+)
+
+const (
+	iocWrite = 1
+	iocRead  = 2
+)
+
+func ioc(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'V'<<8 | nr
+}
+
+// Device is an open V4L2 video device
+type Device struct {
+	fd      int
+	path    string
+	buffers [][]byte // mmap'd capture buffers while streaming
+}
+
+// Open opens a V4L2 device node, e.g. /dev/video0
+func Open(path string) (*Device, error) {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &Device{fd: fd, path: path}, nil
+}
+
+// Name returns the device's card name
+func (d *Device) Name() (string, error) {
+	var c capability
+	if err := d.ioctl(vidiocQueryCap, unsafe.Pointer(&c)); err != nil {
+		return "", fmt.Errorf("%s is not a V4L2 device: %w", d.path, err)
+	}
+	caps := c.capabilities
+	if caps&capDeviceCaps != 0 {
+		caps = c.deviceCaps
+	}
+	if caps&capVideoCapture == 0 || caps&capStreaming == 0 {
+		return "", fmt.Errorf("%s does not support streaming video capture", d.path)
+	}
+	return cstring(c.card[:]), nil
+}
+
+// SetFormat requests a capture format and returns the one the driver chose
+func (d *Device) SetFormat(pixelFormat uint32, width, height int) (uint32, int, int, error) {
+	f := format{typ: bufTypeVideoCapture}
+	pix := (*pixFormat)(unsafe.Pointer(&f.fmt.raw[0]))
+	pix.width = uint32(width)
+	pix.height = uint32(height)
+	pix.pixelFormat = pixelFormat
+	if err := d.ioctl(vidiocSFmt, unsafe.Pointer(&f)); err != nil {
+		return 0, 0, 0, fmt.Errorf("VIDIOC_S_FMT: %w", err)
+	}
+	return pix.pixelFormat, int(pix.width), int(pix.height), nil
+}
+
+// SetFrameRate requests a frame rate; drivers pick the closest they support
+func (d *Device) SetFrameRate(fps int) error {
+	p := streamParm{typ: bufTypeVideoCapture}
+	cp := (*captureParm)(unsafe.Pointer(&p.parm[0]))
+	cp.numerator = 1
+	cp.denominator = uint32(fps)
+	if err := d.ioctl(vidiocSParm, unsafe.Pointer(&p)); err != nil {
+		return fmt.Errorf("VIDIOC_S_PARM: %w", err)
+	}
+	return nil
+}
+
+// StartStreaming allocates and queues count mmap buffers and starts capture
+func (d *Device) StartStreaming(count int) error {
+	req := requestBuffers{count: uint32(count), typ: bufTypeVideoCapture, memory: memoryMMAP}
+	if err := d.ioctl(vidiocReqBufs, unsafe.Pointer(&req)); err != nil {
+		return fmt.Errorf("VIDIOC_REQBUFS: %w", err)
+	}
+	if req.count == 0 {
+		return fmt.Errorf("driver allocated no buffers")
+	}
+
+	for i := uint32(0); i < req.count; i++ {
+		buf := buffer{index: i, typ: bufTypeVideoCapture, memory: memoryMMAP}
+		if err := d.ioctl(vidiocQueryBuf, unsafe.Pointer(&buf)); err != nil {
+			d.unmap()
+			return fmt.Errorf("VIDIOC_QUERYBUF: %w", err)
+		}
+		data, err := unix.Mmap(d.fd, int64(buf.offset), int(buf.length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+		if err != nil {
+			d.unmap()
+			return fmt.Errorf("mmap: %w", err)
+		}
+		d.buffers = append(d.buffers, data)
+
+		if err := d.ioctl(vidiocQBuf, unsafe.Pointer(&buf)); err != nil {
+			d.unmap()
+			return fmt.Errorf("VIDIOC_QBUF: %w", err)
+		}
+	}
+
+	typ := int32(bufTypeVideoCapture)
+	if err := d.ioctl(vidiocStreamOn, unsafe.Pointer(&typ)); err != nil {
+		d.unmap()
+		return fmt.Errorf("VIDIOC_STREAMON: %w", err)
+	}
+	return nil
+}
+
+// QueryControl returns the range of a control, or an error if the device
+// doesn't have it (or it is disabled)
+func (d *Device) QueryControl(id uint32) (Control, error) {
+	q := queryCtrl{id: id}
+	if err := d.ioctl(vidiocQueryCtrl, unsafe.Pointer(&q)); err != nil {
+		return Control{}, fmt.Errorf("VIDIOC_QUERYCTRL %#x: %w", id, err)
+	}
+	if q.flags&ctrlFlagDisabled != 0 {
+		return Control{}, fmt.Errorf("control %#x is disabled", id)
+	}
+	return Control{
+		ID:      id,
+		Name:    cstring(q.name[:]),
+		Min:     q.minimum,
+		Max:     q.maximum,
+		Step:    q.step,
+		Default: q.defaultValue,
+	}, nil
+}
+
+// GetControl reads a control's current value
+func (d *Device) GetControl(id uint32) (int32, error) {
+	c := control{id: id}
+	if err := d.ioctl(vidiocGCtrl, unsafe.Pointer(&c)); err != nil {
+		return 0, fmt.Errorf("VIDIOC_G_CTRL %#x: %w", id, err)
+	}
+	return c.value, nil
+}
+
+// SetControl sets a control's value
+func (d *Device) SetControl(id uint32, value int32) error {
+	c := control{id: id, value: value}
+	if err := d.ioctl(vidiocSCtrl, unsafe.Pointer(&c)); err != nil {
+		return fmt.Errorf("VIDIOC_S_CTRL %#x: %w", id, err)
+	}
+	return nil
+}
+
+// errTimeout is returned by ReadFrame when no frame arrived in time
+var errTimeout = errors.New("timed out waiting for frame")
+
+// ReadFrame waits up to timeout for the next captured frame and returns a copy of it
+func (d *Device) ReadFrame(timeout time.Duration) ([]byte, error) {
+	fds := []unix.PollFd{{Fd: int32(d.fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout.Milliseconds()))
+	if err != nil && err != unix.EINTR {
+		return nil, err
+	}
+	if n == 0 || err == unix.EINTR {
+		return nil, errTimeout
+	}
+
+	buf := buffer{typ: bufTypeVideoCapture, memory: memoryMMAP}
+	if err := d.ioctl(vidiocDQBuf, unsafe.Pointer(&buf)); err != nil {
+		if err == unix.EAGAIN {
+			return nil, errTimeout
+		}
+		return nil, fmt.Errorf("VIDIOC_DQBUF: %w", err)
+	}
+
+	frame := make([]byte, buf.bytesUsed)
+	copy(frame, d.buffers[buf.index][:buf.bytesUsed])
+
+	if err := d.ioctl(vidiocQBuf, unsafe.Pointer(&buf)); err != nil {
+		return nil, fmt.Errorf("VIDIOC_QBUF: %w", err)
+	}
+	return frame, nil
+}
+
+// Close stops streaming, releases the buffers and closes the device
+func (d *Device) Close() error {
+	if d.buffers != nil {
+		typ := int32(bufTypeVideoCapture)
+		d.ioctl(vidiocStreamOff, unsafe.Pointer(&typ))
+		d.unmap()
+	}
+	return unix.Close(d.fd)
+}
+
+func (d *Device) unmap() {
+	for _, b := range d.buffers {
+		unix.Munmap(b)
+	}
+	d.buffers = nil
+}
+
+func (d *Device) ioctl(req uintptr, arg unsafe.Pointer) error {
+	for {
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(d.fd), req, uintptr(arg))
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+// cstring converts a NUL-terminated byte array to a string
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
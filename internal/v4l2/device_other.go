@@ -0,0 +1,39 @@
+//go:build !linux
+
+package v4l2
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errTimeout = errors.New("timed out waiting for frame")
+
+// Device is an open V4L2 video device (Linux only)
+type Device struct{}
+
+// Open is only implemented on Linux
+func Open(path string) (*Device, error) {
+	return nil, fmt.Errorf("V4L2 devices are not supported on this platform")
+}
+
+func (d *Device) Name() (string, error) { return "", errors.ErrUnsupported }
+
+func (d *Device) SetFormat(pixelFormat uint32, width, height int) (uint32, int, int, error) {
+	return 0, 0, 0, errors.ErrUnsupported
+}
+
+func (d *Device) SetFrameRate(fps int) error { return errors.ErrUnsupported }
+
+func (d *Device) StartStreaming(count int) error { return errors.ErrUnsupported }
+
+func (d *Device) ReadFrame(timeout time.Duration) ([]byte, error) { return nil, errors.ErrUnsupported }
+
+func (d *Device) QueryControl(id uint32) (Control, error) { return Control{}, errors.ErrUnsupported }
+
+func (d *Device) GetControl(id uint32) (int32, error) { return 0, errors.ErrUnsupported }
+
+func (d *Device) SetControl(id uint32, value int32) error { return errors.ErrUnsupported }
+
+func (d *Device) Close() error { return nil }
@@ -0,0 +1,193 @@
+package v4l2
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/video"
+)
+
+// Pixel formats (fourcc codes) of compressed UVC streams
+const (
+	PixelFormatH264  = 'H' | '2'<<8 | '6'<<16 | '4'<<24
+	PixelFormatMJPEG = 'M' | 'J'<<8 | 'P'<<16 | 'G'<<24
+)
+
+// Config for a V4L2 video source
+type Config struct {
+	Device string // Device node, e.g. "/dev/video0"
+	Format string // "h264" (default) or "mjpeg"
+	Width  int    // Default 1920
+	Height int    // Default 1080
+	FPS    int    // Default 30
+}
+
+// Source captures H.264 or MJPEG from a UVC camera. H.264 access units are
+// packetized to RTP; MJPEG frames are delivered whole through Frames.
+type Source struct {
+	cfg     Config
+	codec   string
+	rtpChan chan []byte
+	frames  chan []byte
+	stopCh  chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	dev     *Device
+	stopped bool
+}
+
+// NewSource creates a V4L2 source; the device is opened by Connect
+func NewSource(cfg Config) (*Source, error) {
+	if cfg.Device == "" {
+		return nil, fmt.Errorf("video device is required")
+	}
+	if cfg.Format == "" {
+		cfg.Format = video.CodecH264
+	}
+	if cfg.Format != video.CodecH264 && cfg.Format != video.CodecMJPEG {
+		return nil, fmt.Errorf("unsupported video format: %s", cfg.Format)
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		cfg.Width, cfg.Height = 1920, 1080
+	}
+	if cfg.FPS == 0 {
+		cfg.FPS = 30
+	}
+
+	return &Source{
+		cfg:     cfg,
+		rtpChan: make(chan []byte, 500),
+		frames:  make(chan []byte, 4),
+		stopCh:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Connect opens the device, negotiates the format and starts capturing
+func (s *Source) Connect() error {
+	dev, err := Open(s.cfg.Device)
+	if err != nil {
+		return err
+	}
+
+	name, err := dev.Name()
+	if err != nil {
+		dev.Close()
+		return err
+	}
+
+	want := uint32(PixelFormatH264)
+	if s.cfg.Format == video.CodecMJPEG {
+		want = PixelFormatMJPEG
+	}
+	got, width, height, err := dev.SetFormat(want, s.cfg.Width, s.cfg.Height)
+	if err != nil {
+		dev.Close()
+		return err
+	}
+	if got != want {
+		dev.Close()
+		return fmt.Errorf("%s does not support %s capture", s.cfg.Device, s.cfg.Format)
+	}
+	if err := dev.SetFrameRate(s.cfg.FPS); err != nil {
+		log.Printf("V4L2: %v", err)
+	}
+	if err := dev.StartStreaming(4); err != nil {
+		dev.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.dev = dev
+	s.codec = s.cfg.Format
+	s.mu.Unlock()
+
+	log.Printf("V4L2: Capturing %s %dx%d from %s (%s)", s.cfg.Format, width, height, s.cfg.Device, name)
+	go s.capture(dev)
+	return nil
+}
+
+// capture reads frames until the source is closed
+func (s *Source) capture(dev *Device) {
+	defer close(s.done)
+
+	packetizer := video.NewH264Packetizer()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+
+		frame, err := dev.ReadFrame(time.Second)
+		if errors.Is(err, errTimeout) {
+			continue
+		}
+		if err != nil {
+			log.Printf("V4L2: Capture failed: %v", err)
+			return
+		}
+		if len(frame) == 0 {
+			continue
+		}
+
+		if s.codec == video.CodecMJPEG {
+			select {
+			case s.frames <- frame:
+			default:
+				// Drop frame if nobody keeps up
+			}
+			continue
+		}
+
+		for _, packet := range packetizer.Packetize(frame, time.Now()) {
+			select {
+			case s.rtpChan <- packet:
+			default:
+				// Drop packet if channel full
+			}
+		}
+	}
+}
+
+// Codec returns the captured codec, or "" before Connect
+func (s *Source) Codec() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codec
+}
+
+// RTPChannel returns the channel for receiving RTP packets (H.264 only)
+func (s *Source) RTPChannel() <-chan []byte {
+	return s.rtpChan
+}
+
+// Frames returns the channel for receiving JPEG images (MJPEG only)
+func (s *Source) Frames() <-chan []byte {
+	return s.frames
+}
+
+// Close stops capturing and closes the device
+func (s *Source) Close() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	dev := s.dev
+	s.mu.Unlock()
+
+	close(s.stopCh)
+	if dev != nil {
+		<-s.done
+		dev.Close()
+	}
+	close(s.rtpChan)
+	close(s.frames)
+	return nil
+}
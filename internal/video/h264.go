@@ -0,0 +1,33 @@
+package video
+
+// H.264 NAL unit types
+const (
+	naluIDR   = 5
+	naluSPS   = 7
+	naluSTAPA = 24
+	naluFUA   = 28
+)
+
+// IsH264Keyframe reports whether an H.264 RTP payload (RFC 6184) starts a
+// keyframe: an SPS or IDR slice, alone, first in a STAP-A, or at the start of
+// an FU-A. Decoders can join or switch streams at such a packet.
+func IsH264Keyframe(payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+	switch typ := payload[0] & 0x1F; typ {
+	case naluIDR, naluSPS:
+		return true
+	case naluSTAPA:
+		// First aggregated NAL unit follows a 2-byte size
+		if len(payload) < 4 {
+			return false
+		}
+		t := payload[3] & 0x1F
+		return t == naluSPS || t == naluIDR
+	case naluFUA:
+		start := payload[1]&0x80 != 0
+		return start && payload[1]&0x1F == naluIDR
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package video
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+const (
+	rtpMTU       = 1200 // Leaves room for SRTP and TURN overhead
+	rtpClockRate = 90000
+)
+
+// H264Packetizer turns H.264 access units (Annex-B byte stream) into RTP
+// packets for sources that produce encoded frames rather than RTP
+type H264Packetizer struct {
+	packetizer rtp.Packetizer
+	last       time.Time
+}
+
+// NewH264Packetizer creates a packetizer with a random SSRC
+func NewH264Packetizer() *H264Packetizer {
+	return &H264Packetizer{
+		packetizer: rtp.NewPacketizer(rtpMTU, 96, rand.Uint32(), &codecs.H264Payloader{},
+			rtp.NewRandomSequencer(), rtpClockRate),
+	}
+}
+
+// Packetize splits one access unit captured at t into marshaled RTP packets.
+// The RTP timestamp advances by the time since the previous access unit.
+func (p *H264Packetizer) Packetize(au []byte, t time.Time) [][]byte {
+	var samples uint32
+	if !p.last.IsZero() {
+		samples = uint32(t.Sub(p.last).Seconds() * rtpClockRate)
+	}
+	p.last = t
+
+	var packets [][]byte
+	for _, pkt := range p.packetizer.Packetize(au, samples) {
+		buf, err := MarshalPacket(pkt)
+		if err != nil {
+			continue
+		}
+		packets = append(packets, buf)
+	}
+	return packets
+}
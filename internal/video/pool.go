@@ -0,0 +1,39 @@
+package video
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// packetBufferSize is the capacity of pooled packet buffers; larger packets
+// are allocated
+const packetBufferSize = 2048
+
+var packetPool = sync.Pool{
+	New: func() any { return new([packetBufferSize]byte) },
+}
+
+// MarshalPacket marshals an RTP packet into a pooled buffer, for sending on
+// a Source's RTP channel
+func MarshalPacket(pkt *rtp.Packet) ([]byte, error) {
+	if pkt.MarshalSize() > packetBufferSize {
+		return pkt.Marshal()
+	}
+	buf := packetPool.Get().(*[packetBufferSize]byte)
+	n, err := pkt.MarshalTo(buf[:])
+	if err != nil {
+		packetPool.Put(buf)
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// ReleasePacket hands a packet received from a Source's RTP channel back to
+// the pool once it is no longer used. Packets not from MarshalPacket are
+// ignored.
+func ReleasePacket(b []byte) {
+	if cap(b) == packetBufferSize {
+		packetPool.Put((*[packetBufferSize]byte)(b[:packetBufferSize]))
+	}
+}
@@ -0,0 +1,39 @@
+package video
+
+// Codecs reported by Source.Codec
+const (
+	CodecH264  = "h264"
+	CodecH265  = "h265"
+	CodecMJPEG = "mjpeg"
+)
+
+// Source is a camera video feed shared by all clients
+type Source interface {
+	// Connect opens the source and starts streaming
+	Connect() error
+
+	// Codec returns the codec of the stream (CodecH264, ...), or "" before Connect
+	Codec() string
+
+	// RTPChannel returns the channel of marshaled RTP packets. Sources whose
+	// codec can't be carried over WebRTC (MJPEG) never send on it. Packets
+	// may be pooled; the receiver passes them to ReleasePacket when done.
+	RTPChannel() <-chan []byte
+
+	// Close stops streaming and closes the RTP channel
+	Close() error
+}
+
+// FrameSource is implemented by sources that yield whole encoded frames
+// (e.g. MJPEG images) instead of, or in addition to, RTP packets
+type FrameSource interface {
+	// Frames returns the channel of encoded frames, closed by Close
+	Frames() <-chan []byte
+}
+
+// Disconnecter is implemented by sources that can stop streaming while no
+// one is watching and be started again with Connect
+type Disconnecter interface {
+	// Disconnect stops streaming but keeps the RTP channel open
+	Disconnect() error
+}
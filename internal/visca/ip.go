@@ -0,0 +1,153 @@
+package visca
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"ptz-remote/internal/ptz"
+)
+
+// VISCA-over-IP payload types (first two bytes of the 8-byte header)
+const (
+	typeCommand        = 0x0100 // VISCA command
+	typeInquiry        = 0x0110 // VISCA inquiry
+	typeReply          = 0x0111 // VISCA reply (ACK, completion, error, inquiry result)
+	typeControlCommand = 0x0200 // Control command (RESET)
+	typeControlReply   = 0x0201 // Control reply (RESET ACK or sequence/message error)
+)
+
+// Control payloads
+var (
+	controlReset         = []byte{0x01}       // RESET sequence number
+	controlErrSequence   = []byte{0x0F, 0x01} // Abnormality in sequence number
+	controlErrMessageFmt = []byte{0x0F, 0x02} // Abnormality in message (type)
+)
+
+// minResetInterval limits how often a re-sync can be triggered, so a burst of
+// sequence errors for in-flight packets results in a single RESET
+const minResetInterval = time.Second
+
+// buildIPPacket wraps a payload in the VISCA-over-IP header
+func buildIPPacket(payloadType uint16, seq uint32, payload []byte) []byte {
+	packet := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(packet[0:2], payloadType)
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(payload)))
+	binary.BigEndian.PutUint32(packet[4:8], seq)
+	return append(packet, payload...)
+}
+
+// resetSequence sends the RESET control command and restarts the sequence
+// numbers at 0. The camera replies with a control reply carrying 0x01.
+// With retry, the last message is sent again with the restarted sequence
+// number, since the camera dropped it (or an earlier one it supersedes).
+func (t *Transport) resetSequence(retry bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Since(t.lastReset) < minResetInterval {
+		return
+	}
+	t.lastReset = time.Now()
+	t.seqNum = 0
+
+	t.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	t.conn.Write(buildIPPacket(typeControlCommand, t.seqNum, controlReset))
+
+	if retry && t.lastFrame != nil {
+		t.conn.Write(buildIPPacket(t.lastType, t.seqNum, t.lastFrame))
+		t.seqNum++
+	}
+}
+
+// readIPReplies reads VISCA-over-IP datagrams until the connection is closed,
+// re-syncing the sequence number when the camera reports a mismatch
+func (t *Transport) readIPReplies() {
+	buf := make([]byte, 1500)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			select {
+			case <-t.stopCh:
+				return
+			default:
+			}
+			// UDP reads fail transiently on ICMP port unreachable (camera rebooting)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if n < 8 {
+			continue
+		}
+
+		payloadType := binary.BigEndian.Uint16(buf[0:2])
+		length := int(binary.BigEndian.Uint16(buf[2:4]))
+		if 8+length > n {
+			continue
+		}
+		payload := buf[8 : 8+length]
+
+		switch payloadType {
+		case typeControlReply:
+			t.handleControlReply(payload)
+		case typeReply:
+			t.dispatch(payload)
+		}
+	}
+}
+
+func (t *Transport) handleControlReply(payload []byte) {
+	switch {
+	case string(payload) == string(controlReset):
+		log.Printf("VISCA: Sequence number reset acknowledged")
+	case string(payload) == string(controlErrSequence):
+		log.Printf("VISCA: Camera reported sequence number mismatch, resetting")
+		go t.resetSequence(true)
+	case string(payload) == string(controlErrMessageFmt):
+		log.Printf("VISCA: Camera reported abnormal message")
+	}
+}
+
+// handleReply records power inquiry replies (y0 50 0p FF) and logs VISCA
+// error replies (y0 6z ..) from this controller's camera. Power is the only
+// inquiry a Controller sends, so a 4-byte inquiry reply is its answer;
+// completions (y0 5z FF) are 3 bytes.
+func (c *Controller) handleReply(frame []byte) {
+	if len(frame) == 4 && frame[1] == 0x50 {
+		c.handlePowerReply(frame[2])
+		return
+	}
+	if len(frame) < 3 || frame[1]&0xF0 != 0x60 {
+		return
+	}
+	switch frame[2] {
+	case 0x01:
+		log.Printf("VISCA: Camera %d: Message length error", c.addr)
+	case 0x02:
+		log.Printf("VISCA: Camera %d: Syntax error", c.addr)
+	case 0x03:
+		log.Printf("VISCA: Camera %d: Command buffer full", c.addr)
+	case 0x04:
+		// Command canceled; expected when a drive command replaces another
+	case 0x05:
+		log.Printf("VISCA: Camera %d: No socket to cancel", c.addr)
+	case 0x41:
+		log.Printf("VISCA: Camera %d: Command not executable", c.addr)
+	}
+}
+
+// handlePowerReply records the power state from a CAM_PowerInq reply
+func (c *Controller) handlePowerReply(p byte) {
+	var state string
+	switch p {
+	case 0x02:
+		state = ptz.PowerOn
+	case 0x03:
+		state = ptz.PowerStandby
+	default:
+		return
+	}
+	c.mu.Lock()
+	c.power = state
+	c.mu.Unlock()
+}
@@ -0,0 +1,147 @@
+package visca
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"ptz-remote/internal/ptz"
+)
+
+// ipCamera is a VISCA-over-IP stand-in
+type ipCamera struct {
+	t    *testing.T
+	pc   net.PacketConn
+	peer net.Addr
+}
+
+// readPacket reads the next packet from the server, or returns ok=false if
+// none arrives within wait
+func (cam *ipCamera) readPacket(wait time.Duration) (typ uint16, seq uint32, payload []byte, ok bool) {
+	cam.t.Helper()
+	buf := make([]byte, 1500)
+	cam.pc.SetReadDeadline(time.Now().Add(wait))
+	n, addr, err := cam.pc.ReadFrom(buf)
+	if err != nil {
+		return 0, 0, nil, false
+	}
+	if n < 8 || int(binary.BigEndian.Uint16(buf[2:4])) != n-8 {
+		cam.t.Fatalf("malformed packet % X", buf[:n])
+	}
+	cam.peer = addr
+	return binary.BigEndian.Uint16(buf[0:2]), binary.BigEndian.Uint32(buf[4:8]), buf[8:n], true
+}
+
+// expect reads a packet and checks its header and payload
+func (cam *ipCamera) expect(typ uint16, seq uint32, payload []byte) {
+	cam.t.Helper()
+	gotTyp, gotSeq, got, ok := cam.readPacket(time.Second)
+	if !ok {
+		cam.t.Fatalf("no packet, want type %04X seq %d", typ, seq)
+	}
+	if gotTyp != typ || gotSeq != seq || !bytes.Equal(got, payload) {
+		cam.t.Fatalf("type %04X seq %d % X, want type %04X seq %d % X", gotTyp, gotSeq, got, typ, seq, payload)
+	}
+}
+
+func (cam *ipCamera) reply(typ uint16, seq uint32, payload []byte) {
+	cam.t.Helper()
+	if _, err := cam.pc.WriteTo(buildIPPacket(typ, seq, payload), cam.peer); err != nil {
+		cam.t.Fatal(err)
+	}
+}
+
+func TestSequenceResync(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	cam := &ipCamera{t: t, pc: pc}
+
+	tr, err := NewTransport(Config{Address: pc.LocalAddr().String(), Protocol: "udp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	cam.expect(typeControlCommand, 0, controlReset) // On startup
+	cam.reply(typeControlReply, 0, controlReset)
+
+	c, err := tr.NewController(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cam.expect(typeInquiry, 0, []byte{0x81, 0x09, 0x04, 0x00, 0xFF}) // Power
+
+	recall := []byte{0x81, 0x01, 0x04, 0x3F, 0x02, 0x03, 0xFF}
+	c.RecallPreset(3)
+	cam.expect(typeCommand, 1, recall)
+
+	// Allow a re-sync right after the startup RESET
+	tr.mu.Lock()
+	tr.lastReset = time.Time{}
+	tr.mu.Unlock()
+
+	// The camera rejects the recall; the server resets and sends it again
+	cam.reply(typeControlReply, 1, controlErrSequence)
+	cam.expect(typeControlCommand, 0, controlReset)
+	cam.expect(typeCommand, 0, recall)
+	cam.reply(typeControlReply, 0, controlReset)
+
+	// Numbering continues from the retried command
+	c.SavePreset(4)
+	cam.expect(typeCommand, 1, []byte{0x81, 0x01, 0x04, 0x3F, 0x01, 0x04, 0xFF})
+
+	// Errors for other in-flight packets don't reset again
+	cam.reply(typeControlReply, 1, controlErrSequence)
+	if typ, seq, payload, ok := cam.readPacket(200 * time.Millisecond); ok {
+		t.Errorf("second mismatch sent type %04X seq %d % X, want nothing", typ, seq, payload)
+	}
+}
+
+func TestIPReplyParsing(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	cam := &ipCamera{t: t, pc: pc}
+
+	tr, err := NewTransport(Config{Address: pc.LocalAddr().String(), Protocol: "udp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	cam.expect(typeControlCommand, 0, controlReset)
+
+	c, err := tr.NewController(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cam.expect(typeInquiry, 0, []byte{0x81, 0x09, 0x04, 0x00, 0xFF})
+
+	// Malformed datagrams are skipped; a reply after them still arrives
+	for _, b := range [][]byte{
+		{0x01, 0x11},
+		{0x01, 0x11, 0x00, 0x10, 0, 0, 0, 0, 0x90},                    // Length past the end
+		buildIPPacket(typeCommand, 0, []byte{0x90, 0x50, 0x03, 0xFF}), // Not a reply
+		buildIPPacket(typeControlReply, 0, controlErrMessageFmt),
+	} {
+		if _, err := pc.WriteTo(b, cam.peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cam.reply(typeReply, 0, []byte{0x90, 0x50, 0x02, 0xFF})
+
+	deadline := time.Now().Add(time.Second)
+	for c.PowerState() != ptz.PowerOn && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if p := c.PowerState(); p != ptz.PowerOn {
+		t.Errorf("power %q, want %q", p, ptz.PowerOn)
+	}
+}
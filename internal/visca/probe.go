@@ -0,0 +1,109 @@
+package visca
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// versionInquiry is CAM_VersionInq for camera 1: 81 09 00 02 FF
+var versionInquiry = []byte{0x81, 0x09, 0x00, 0x02, 0xFF}
+
+// Version is the reply to the version inquiry: y0 50 GG GG HH HH JJ JJ KK FF
+type Version struct {
+	VendorID  uint16 // GG GG, 0x0001 = Sony
+	ModelID   uint16 // HH HH
+	ROM       uint16 // JJ JJ
+	MaxSocket int    // KK
+}
+
+// Vendor returns the vendor name for well-known vendor IDs
+func (v Version) Vendor() string {
+	switch v.VendorID {
+	case 0x0001:
+		return "Sony"
+	}
+	return fmt.Sprintf("VISCA vendor %04X", v.VendorID)
+}
+
+// Model returns the model ID as reported by the camera
+func (v Version) Model() string {
+	return fmt.Sprintf("%04X", v.ModelID)
+}
+
+// Identify sends the version inquiry to camera 1 at address over "udp"
+// (VISCA over IP) or "tcp" (raw VISCA) and waits up to timeout for the reply.
+// It uses its own connection, so it can run before any Controller exists.
+func Identify(address, protocol string, timeout time.Duration) (Version, error) {
+	if protocol != "udp" && protocol != "tcp" {
+		return Version{}, fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+
+	conn, err := net.DialTimeout(protocol, address, timeout)
+	if err != nil {
+		return Version{}, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	conn.SetDeadline(deadline)
+
+	if protocol == "udp" {
+		conn.Write(buildIPPacket(typeControlCommand, 0, controlReset))
+		_, err = conn.Write(buildIPPacket(typeInquiry, 0, versionInquiry))
+	} else {
+		_, err = conn.Write(versionInquiry)
+	}
+	if err != nil {
+		return Version{}, err
+	}
+
+	buf := make([]byte, 1500)
+	var frame []byte
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return Version{}, fmt.Errorf("no VISCA reply: %w", err)
+		}
+
+		if protocol == "udp" {
+			if n < 8 || binary.BigEndian.Uint16(buf[0:2]) != typeReply {
+				continue
+			}
+			length := int(binary.BigEndian.Uint16(buf[2:4]))
+			if 8+length > n {
+				continue
+			}
+			if v, ok := parseVersion(buf[8 : 8+length]); ok {
+				return v, nil
+			}
+			continue
+		}
+
+		for _, b := range buf[:n] {
+			frame = append(frame, b)
+			if b == 0xFF {
+				if v, ok := parseVersion(frame); ok {
+					return v, nil
+				}
+				frame = frame[:0]
+			} else if len(frame) > 16 {
+				frame = frame[:0]
+			}
+		}
+	}
+}
+
+// parseVersion decodes a version inquiry reply
+func parseVersion(frame []byte) (Version, bool) {
+	if len(frame) != 10 || frame[0]&0x8F != 0x80 || frame[1] != 0x50 || frame[9] != 0xFF {
+		return Version{}, false
+	}
+	return Version{
+		VendorID:  binary.BigEndian.Uint16(frame[2:4]),
+		ModelID:   binary.BigEndian.Uint16(frame[4:6]),
+		ROM:       binary.BigEndian.Uint16(frame[6:8]),
+		MaxSocket: int(frame[8]),
+	}, true
+}
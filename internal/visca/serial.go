@@ -0,0 +1,59 @@
+package visca
+
+import (
+	"fmt"
+	"time"
+)
+
+// Broadcast messages used to configure a daisy chain of serial cameras
+var (
+	broadcastAddressSet = []byte{0x88, 0x30, 0x01, 0xFF}       // Assign addresses starting at 1
+	broadcastIFClear    = []byte{0x88, 0x01, 0x00, 0x01, 0xFF} // Clear command buffers of all cameras
+)
+
+// discoverTimeout bounds how long to wait for the address-set broadcast to
+// travel around the chain and return
+const discoverTimeout = 2 * time.Second
+
+// Discover assigns addresses to every camera on a serial daisy chain and
+// clears their command buffers. It returns the number of cameras found.
+func (t *Transport) Discover() (int, error) {
+	if t.protocol != "serial" {
+		return 0, fmt.Errorf("discovery is only supported over serial")
+	}
+
+	// Drain stale broadcast replies
+	for len(t.broadcasts) > 0 {
+		<-t.broadcasts
+	}
+
+	if err := t.writeRaw(broadcastAddressSet); err != nil {
+		return 0, err
+	}
+
+	// The last camera returns 88 30 0n FF, where n-1 cameras are on the chain
+	timeout := time.After(discoverTimeout)
+	for {
+		select {
+		case reply := <-t.broadcasts:
+			if len(reply) == 4 && reply[1] == 0x30 {
+				count := int(reply[2]) - 1
+				return count, t.writeRaw(broadcastIFClear)
+			}
+		case <-timeout:
+			return 0, fmt.Errorf("no reply to address set broadcast")
+		case <-t.stopCh:
+			return 0, fmt.Errorf("controller closed")
+		}
+	}
+}
+
+// writeRaw writes an already framed VISCA message
+func (t *Transport) writeRaw(frame []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := t.conn.Write(frame)
+	return err
+}
@@ -0,0 +1,108 @@
+//go:build linux
+
+package visca
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"ptz-remote/internal/serial/serialtest"
+)
+
+// busFrame reads the next 0xFF-terminated frame written to the bus
+func busFrame(bus *os.File) ([]byte, error) {
+	bus.SetReadDeadline(time.Now().Add(time.Second))
+	var frame []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := bus.Read(b); err != nil {
+			return frame, err
+		}
+		frame = append(frame, b[0])
+		if b[0] == 0xFF {
+			return frame, nil
+		}
+	}
+}
+
+// chain emulates a daisy chain of n cameras: each one takes the address in
+// the address set broadcast and passes it on incremented, so the last
+// returns 88 30 0(n+1) FF. It expects an IF_Clear next, and reports
+// unexpected frames on errs.
+func chain(bus *os.File, n int, errs chan<- error) {
+	frame, err := busFrame(bus)
+	if err != nil || !bytes.Equal(frame, broadcastAddressSet) {
+		errs <- fmt.Errorf("got % X (%v), want address set % X", frame, err, broadcastAddressSet)
+		return
+	}
+	bus.Write([]byte{0x88, 0x30, byte(n + 1), 0xFF})
+
+	frame, err = busFrame(bus)
+	if err != nil || !bytes.Equal(frame, broadcastIFClear) {
+		errs <- fmt.Errorf("got % X (%v), want IF_Clear % X", frame, err, broadcastIFClear)
+		return
+	}
+	errs <- nil
+}
+
+func TestSerialDaisyChain(t *testing.T) {
+	bus, path := serialtest.OpenPTY(t)
+	errs := make(chan error, 1)
+	go chain(bus, 3, errs)
+
+	// Opening the port numbers the chain
+	tr, err := NewTransport(Config{Protocol: "serial", Address: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// And so does Discover, which reports the count
+	go chain(bus, 3, errs)
+	count, err := tr.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("found %d cameras, want 3", count)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// Commands to the second camera carry its address
+	c, err := tr.NewController(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if frame, err := busFrame(bus); err != nil || !bytes.Equal(frame, []byte{0x82, 0x09, 0x04, 0x00, 0xFF}) {
+		t.Fatalf("got % X (%v), want the power inquiry for camera 2", frame, err)
+	}
+	c.RecallPreset(5)
+	if frame, err := busFrame(bus); err != nil || !bytes.Equal(frame, []byte{0x82, 0x01, 0x04, 0x3F, 0x02, 0x05, 0xFF}) {
+		t.Errorf("got % X (%v), want preset recall for camera 2", frame, err)
+	}
+}
+
+func TestSerialDiscoverNoReply(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the discovery timeout")
+	}
+	_, path := serialtest.OpenPTY(t)
+	tr, err := NewTransport(Config{Protocol: "serial", Address: path})
+	if err != nil {
+		t.Fatal(err) // A silent bus is logged, not an error
+	}
+	defer tr.Close()
+
+	if _, err := tr.Discover(); err == nil {
+		t.Error("Discover succeeded without a reply")
+	}
+}
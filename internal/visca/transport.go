@@ -0,0 +1,242 @@
+package visca
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"ptz-remote/internal/serial"
+)
+
+// conn is the transport to the camera: a net.Conn or a serial port
+type conn interface {
+	io.ReadWriteCloser
+	SetWriteDeadline(t time.Time) error
+}
+
+// Transport is a connection to one or more VISCA cameras. Several
+// Controllers (one per camera address) can share a Transport, e.g. cameras
+// daisy-chained on a serial bus or behind a VISCA-over-IP gateway.
+type Transport struct {
+	conn      conn
+	mu        sync.Mutex // Serializes writes and guards seqNum
+	seqNum    uint32     // Sequence number for VISCA over IP
+	lastReset time.Time
+	lastType  uint16 // Last VISCA-over-IP message, resent after a re-sync
+	lastFrame []byte
+	protocol  string
+	key       string // Registry key for shared transports, empty otherwise
+	stopCh    chan struct{}
+
+	broadcasts chan []byte // Broadcast (88 ..) replies, used by Discover
+
+	ctrlMu      sync.Mutex
+	controllers map[int]*Controller // Reply routing by camera address
+	closed      bool
+}
+
+// shared holds the transports opened by NewController, so controllers for
+// different addresses on the same socket or serial port reuse one connection
+var shared = struct {
+	sync.Mutex
+	transports map[string]*Transport
+}{transports: make(map[string]*Transport)}
+
+// NewTransport opens a connection to a VISCA camera or bus.
+// Config.CameraAddress is ignored; use Transport.NewController per camera.
+func NewTransport(cfg Config) (*Transport, error) {
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = "udp"
+	}
+
+	var conn conn
+	var err error
+	switch protocol {
+	case "udp":
+		conn, err = net.DialTimeout("udp", cfg.Address, 5*time.Second)
+	case "tcp":
+		conn, err = net.DialTimeout("tcp", cfg.Address, 5*time.Second)
+	case "serial":
+		baud := cfg.BaudRate
+		if baud == 0 {
+			baud = 9600
+		}
+		conn, err = serial.Open(cfg.Address, baud)
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect via %s: %w", protocol, err)
+	}
+
+	t := &Transport{
+		conn:        conn,
+		protocol:    protocol,
+		stopCh:      make(chan struct{}),
+		broadcasts:  make(chan []byte, 4),
+		controllers: make(map[int]*Controller),
+	}
+
+	// Start reading replies; for VISCA over IP also reset the camera's
+	// expected sequence number so a restarted server isn't ignored
+	if protocol == "udp" {
+		go t.readIPReplies()
+		t.resetSequence(false)
+	} else {
+		go t.readRawReplies()
+	}
+
+	// Number the cameras on a serial daisy chain before addressing one
+	if protocol == "serial" {
+		count, err := t.Discover()
+		if err != nil {
+			log.Printf("VISCA: Daisy chain discovery failed: %v", err)
+		} else {
+			log.Printf("VISCA: Found %d camera(s) on %s", count, cfg.Address)
+		}
+	}
+
+	return t, nil
+}
+
+// NewController creates a controller for the camera at addr (1-7) on this transport
+func (t *Transport) NewController(addr int) (*Controller, error) {
+	if addr < 1 || addr > 7 {
+		return nil, fmt.Errorf("camera address must be 1-7")
+	}
+
+	t.ctrlMu.Lock()
+	defer t.ctrlMu.Unlock()
+
+	if t.closed {
+		return nil, fmt.Errorf("transport closed")
+	}
+	if _, ok := t.controllers[addr]; ok {
+		return nil, fmt.Errorf("camera address %d already in use", addr)
+	}
+
+	c := newController(t, addr)
+	t.controllers[addr] = c
+	return c, nil
+}
+
+// release unregisters a closed controller, closing a shared transport
+// once its last controller is gone
+func (t *Transport) release(c *Controller) {
+	t.ctrlMu.Lock()
+	delete(t.controllers, c.addr)
+	last := len(t.controllers) == 0
+	t.ctrlMu.Unlock()
+
+	if t.key == "" || !last {
+		return
+	}
+
+	shared.Lock()
+	if shared.transports[t.key] == t {
+		delete(shared.transports, t.key)
+	}
+	shared.Unlock()
+	t.Close()
+}
+
+// Close closes the connection. Controllers using it stop working.
+func (t *Transport) Close() error {
+	t.ctrlMu.Lock()
+	if t.closed {
+		t.ctrlMu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.ctrlMu.Unlock()
+
+	close(t.stopCh)
+	return t.conn.Close()
+}
+
+// send frames a VISCA command for the camera at addr and writes it
+func (t *Transport) send(addr int, payload []byte) error {
+	return t.write(typeCommand, addr, payload)
+}
+
+// inquire frames a VISCA inquiry for the camera at addr and writes it; the
+// reply arrives through the controller's handleReply
+func (t *Transport) inquire(addr int, payload []byte) error {
+	return t.write(typeInquiry, addr, payload)
+}
+
+func (t *Transport) write(payloadType uint16, addr int, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Build VISCA frame: [0x80|addr] [payload...] [0xFF]
+	frame := make([]byte, 0, len(payload)+2)
+	frame = append(frame, byte(0x80|addr))
+	frame = append(frame, payload...)
+	frame = append(frame, 0xFF)
+
+	// Wrap in VISCA-over-IP for UDP
+	var packet []byte
+	if t.protocol == "udp" {
+		packet = buildIPPacket(payloadType, t.seqNum, frame)
+		t.seqNum++
+		t.lastType, t.lastFrame = payloadType, frame
+	} else {
+		packet = frame
+	}
+
+	t.conn.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	t.conn.Write(packet)
+	return nil
+}
+
+// readRawReplies reads 0xFF-terminated VISCA replies from a raw (TCP or serial) connection
+func (t *Transport) readRawReplies() {
+	buf := make([]byte, 256)
+	var frame []byte
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			frame = append(frame, b)
+			if b == 0xFF {
+				t.dispatch(frame)
+				frame = frame[:0]
+			} else if len(frame) > 16 {
+				// Not a valid VISCA message; resynchronize on the next terminator
+				frame = frame[:0]
+			}
+		}
+	}
+}
+
+// dispatch routes a reply frame to the controller for its source address.
+// Replies start with (addr+8)<<4, e.g. 0x90 for camera 1; broadcasts start with 0x88.
+func (t *Transport) dispatch(frame []byte) {
+	if len(frame) < 2 {
+		return
+	}
+	if frame[0] == 0x88 {
+		reply := append([]byte(nil), frame...)
+		select {
+		case t.broadcasts <- reply:
+		default:
+		}
+		return
+	}
+
+	addr := int(frame[0]>>4) - 8
+	t.ctrlMu.Lock()
+	c := t.controllers[addr]
+	t.ctrlMu.Unlock()
+
+	if c != nil {
+		c.handleReply(frame)
+	}
+}
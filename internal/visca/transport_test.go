@@ -0,0 +1,104 @@
+package visca
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func sharedTransport(key string) *Transport {
+	shared.Lock()
+	defer shared.Unlock()
+	return shared.transports[key]
+}
+
+func TestSharedTransport(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	cam := &ipCamera{t: t, pc: pc}
+	addr := pc.LocalAddr().String()
+	key := "udp|" + addr
+
+	c1, err := NewController(Config{Address: addr, Protocol: "udp", CameraAddress: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := NewController(Config{Address: addr, CameraAddress: 2}) // UDP by default
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := c1.transport
+	if c2.transport != tr || sharedTransport(key) != tr {
+		t.Fatal("controllers on the same address don't share a transport")
+	}
+	if _, err := NewController(Config{Address: addr, Protocol: "udp", CameraAddress: 2}); err == nil {
+		t.Error("second controller for camera 2 accepted")
+	}
+	if sharedTransport(key) != tr {
+		t.Error("failed controller dropped the shared transport")
+	}
+
+	// One RESET for the shared socket, then each camera's power inquiry
+	cam.expect(typeControlCommand, 0, controlReset)
+	for {
+		typ, _, payload, ok := cam.readPacket(200 * time.Millisecond)
+		if !ok {
+			break
+		}
+		if typ != typeInquiry {
+			t.Fatalf("type %04X % X, want only power inquiries", typ, payload)
+		}
+	}
+
+	// Closing one controller leaves the other working
+	c1.Close()
+	if sharedTransport(key) != tr {
+		t.Fatal("transport unregistered while camera 2 uses it")
+	}
+	c2.RecallPreset(1)
+	cam.expect(typeCommand, 2, []byte{0x82, 0x01, 0x04, 0x3F, 0x02, 0x01, 0xFF})
+
+	// The last one closes the socket
+	c2.Close()
+	if sharedTransport(key) != nil {
+		t.Error("registry entry left after the last controller closed")
+	}
+	tr.ctrlMu.Lock()
+	closed := tr.closed
+	tr.ctrlMu.Unlock()
+	if !closed {
+		t.Error("transport not closed")
+	}
+	if _, err := tr.conn.Write([]byte{0}); err == nil {
+		t.Error("socket still open")
+	}
+
+	// A new controller opens a fresh transport
+	c3, err := NewController(Config{Address: addr, Protocol: "udp", CameraAddress: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c3.Close()
+	if c3.transport == tr {
+		t.Error("closed transport reused")
+	}
+}
+
+func TestSharedTransportInvalidAddress(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	addr := pc.LocalAddr().String()
+
+	if _, err := NewController(Config{Address: addr, Protocol: "udp", CameraAddress: 8}); err == nil {
+		t.Fatal("camera address 8 accepted")
+	}
+	if sharedTransport("udp|"+addr) != nil {
+		t.Error("unused transport left in the registry")
+	}
+}
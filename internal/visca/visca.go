@@ -1,173 +1,184 @@
 package visca
 
 import (
-	"encoding/binary"
 	"fmt"
-	"net"
 	"sync"
 	"time"
+
+	"ptz-remote/internal/ptz"
 )
 
 const minInterval = 33 * time.Millisecond // ~30 commands/sec max per axis
 
-// throttle coalesces rapid updates, sending immediately when possible
-// and scheduling a trailing edge send for updates during cooldown
-type throttle struct {
-	mu           sync.Mutex
-	lastSendTime time.Time
-	timerRunning bool
-	stopCh       <-chan struct{}
-	flush        func() // called with mu held
-}
-
-func (t *throttle) trigger() {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	now := time.Now()
-	if now.Sub(t.lastSendTime) >= minInterval {
-		t.flush()
-		t.lastSendTime = now
-	} else if !t.timerRunning {
-		t.timerRunning = true
-		remaining := minInterval - now.Sub(t.lastSendTime)
-		go func() {
-			select {
-			case <-time.After(remaining):
-				t.mu.Lock()
-				t.flush()
-				t.lastSendTime = time.Now()
-				t.timerRunning = false
-				t.mu.Unlock()
-			case <-t.stopCh:
-			}
-		}()
-	}
-}
+// powerPollInterval is how often the camera's power state is inquired, so
+// changes made elsewhere (IR remote, another controller) show up
+const powerPollInterval = 10 * time.Second
 
-// Controller manages VISCA communication with a PTZ camera
+// Controller manages VISCA communication with one camera on a Transport
 type Controller struct {
-	conn     net.Conn
-	mu       sync.Mutex
-	addr     int    // Camera address (1-7), default 1
-	seqNum   uint32 // Sequence number for VISCA over IP
-	protocol string
-	stopCh   chan struct{}
+	transport *Transport
+	addr      int // Camera address (1-7), default 1
+	stopCh    chan struct{}
+
+	mu    sync.Mutex
+	power string // Last known power state (ptz.Power* constant)
 
 	// Pan/tilt state
 	panTilt struct {
-		throttle
+		ptz.Throttle
 		pending, sent struct{ pan, tilt float64 }
 	}
 
 	// Zoom state
 	zoom struct {
-		throttle
+		ptz.Throttle
 		pending, sent float64
 	}
 }
 
 // Config for VISCA controller
 type Config struct {
-	Address  string // UDP: "192.168.1.100:52381", TCP: "192.168.1.100:5678"
-	Protocol string // "udp" or "tcp"
+	Address       string // UDP: "192.168.1.100:52381", TCP: "192.168.1.100:5678", serial: "/dev/ttyUSB0"
+	Protocol      string // "udp", "tcp" or "serial"
+	BaudRate      int    // Serial baud rate, default 9600
+	CameraAddress int    // Camera address on the bus (1-7), default 1
 }
 
-// NewController creates a new VISCA controller
+// NewController creates a new VISCA controller. Controllers created with the
+// same Protocol and Address share one connection; it is closed along with
+// the last of them.
 func NewController(cfg Config) (*Controller, error) {
 	protocol := cfg.Protocol
 	if protocol == "" {
 		protocol = "udp"
 	}
+	addr := cfg.CameraAddress
+	if addr == 0 {
+		addr = 1
+	}
 
-	var conn net.Conn
-	var err error
-	switch protocol {
-	case "udp":
-		conn, err = net.DialTimeout("udp", cfg.Address, 5*time.Second)
-	case "tcp":
-		conn, err = net.DialTimeout("tcp", cfg.Address, 5*time.Second)
-	default:
-		return nil, fmt.Errorf("unsupported protocol: %s", protocol)
+	key := protocol + "|" + cfg.Address
+
+	shared.Lock()
+	defer shared.Unlock()
+
+	t := shared.transports[key]
+	if t == nil {
+		var err error
+		t, err = NewTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		t.key = key
+		shared.transports[key] = t
 	}
+
+	c, err := t.NewController(addr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect via %s: %w", protocol, err)
+		t.ctrlMu.Lock()
+		unused := len(t.controllers) == 0
+		t.ctrlMu.Unlock()
+		if unused {
+			delete(shared.transports, key)
+			t.Close()
+		}
+		return nil, err
 	}
+	return c, nil
+}
 
+// newController creates a controller and wires up its throttles
+func newController(t *Transport, addr int) *Controller {
 	c := &Controller{
-		conn:     conn,
-		addr:     1,
-		protocol: protocol,
-		stopCh:   make(chan struct{}),
+		transport: t,
+		addr:      addr,
+		stopCh:    make(chan struct{}),
+		power:     ptz.PowerUnknown,
 	}
 
 	// Wire up throttle flush callbacks
-	c.panTilt.stopCh = c.stopCh
-	c.panTilt.flush = func() {
+	c.panTilt.Interval = minInterval
+	c.panTilt.StopCh = c.stopCh
+	c.panTilt.Flush = func() {
 		if c.panTilt.pending != c.panTilt.sent {
 			c.sendPanTiltCmd(c.panTilt.pending.pan, c.panTilt.pending.tilt)
 			c.panTilt.sent = c.panTilt.pending
 		}
 	}
 
-	c.zoom.stopCh = c.stopCh
-	c.zoom.flush = func() {
+	c.zoom.Interval = minInterval
+	c.zoom.StopCh = c.stopCh
+	c.zoom.Flush = func() {
 		if c.zoom.pending != c.zoom.sent {
 			c.sendZoomCmd(c.zoom.pending)
 			c.zoom.sent = c.zoom.pending
 		}
 	}
 
-	return c, nil
+	go c.pollPower()
+	return c
+}
+
+// pollPower inquires the power state now and every powerPollInterval
+func (c *Controller) pollPower() {
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+	for {
+		// CAM_PowerInq: 09 04 00, answered by y0 50 0p FF (p: 2=on, 3=standby)
+		c.transport.inquire(c.addr, []byte{0x09, 0x04, 0x00})
+		select {
+		case <-ticker.C:
+		case <-c.stopCh:
+			return
+		}
+	}
 }
 
-// Close closes the VISCA connection
+// Close stops the controller and releases its transport
 func (c *Controller) Close() error {
 	close(c.stopCh)
-	if c.conn != nil {
-		return c.conn.Close()
-	}
+	c.transport.release(c)
 	return nil
 }
 
 // PanTilt sends a pan/tilt command. pan: -1.0 (left) to 1.0 (right), tilt: -1.0 (down) to 1.0 (up)
 func (c *Controller) PanTilt(pan, tilt float64) error {
-	c.panTilt.mu.Lock()
+	c.panTilt.Lock()
 	c.panTilt.pending.pan = pan
 	c.panTilt.pending.tilt = tilt
 	changed := c.panTilt.pending != c.panTilt.sent
-	c.panTilt.mu.Unlock()
+	c.panTilt.Unlock()
 
 	if changed {
-		c.panTilt.trigger()
+		c.panTilt.Trigger()
 	}
 	return nil
 }
 
 // Zoom sends a zoom command. zoom: -1.0 (wide/out) to 1.0 (tele/in)
 func (c *Controller) Zoom(zoom float64) error {
-	c.zoom.mu.Lock()
+	c.zoom.Lock()
 	c.zoom.pending = zoom
 	changed := c.zoom.pending != c.zoom.sent
-	c.zoom.mu.Unlock()
+	c.zoom.Unlock()
 
 	if changed {
-		c.zoom.trigger()
+		c.zoom.Trigger()
 	}
 	return nil
 }
 
 // Stop stops all PTZ movement immediately
 func (c *Controller) Stop() error {
-	c.panTilt.mu.Lock()
+	c.panTilt.Lock()
 	c.panTilt.pending = struct{ pan, tilt float64 }{}
 	c.panTilt.sent = c.panTilt.pending
-	c.panTilt.mu.Unlock()
+	c.panTilt.Unlock()
 
-	c.zoom.mu.Lock()
+	c.zoom.Lock()
 	c.zoom.pending = 0
 	c.zoom.sent = 0
-	c.zoom.mu.Unlock()
+	c.zoom.Unlock()
 
 	c.sendPanTiltCmd(0, 0)
 	c.sendZoomCmd(0)
@@ -190,6 +201,66 @@ func (c *Controller) SavePreset(preset int) error {
 	return c.sendCommand([]byte{0x01, 0x04, 0x3F, 0x01, byte(preset)})
 }
 
+// Capabilities describes what VISCA control supports
+func (c *Controller) Capabilities() ptz.Capabilities {
+	return ptz.Capabilities{
+		PanTilt:        true,
+		Zoom:           true,
+		Presets:        true,
+		PresetMin:      0,
+		PresetMax:      255,
+		Power:          true,
+		Tally:          true,
+		PanSpeedSteps:  24,
+		TiltSpeedSteps: 20,
+		ZoomSpeedSteps: 8,
+	}
+}
+
+// SetPower turns the camera on or puts it in standby
+func (c *Controller) SetPower(on bool) error {
+	// VISCA: 01 04 00 0p (p: 2=on, 3=standby)
+	p, state := byte(0x03), ptz.PowerStandby
+	if on {
+		p, state = 0x02, ptz.PowerOn
+	}
+	if err := c.sendCommand([]byte{0x01, 0x04, 0x00, p}); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.power = state
+	c.mu.Unlock()
+	return nil
+}
+
+// PowerState returns the power state last reported by the camera, or set
+// with SetPower since
+func (c *Controller) PowerState() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.power
+}
+
+// SetTally turns the red or green tally lamp on or off
+func (c *Controller) SetTally(color string, on bool) error {
+	// VISCA: 01 7E 01 0A 0l 0p (l: 0=red, 1=green; p: 2=on, 3=off)
+	var lamp byte
+	switch color {
+	case ptz.TallyRed:
+		lamp = 0x00
+	case ptz.TallyGreen:
+		lamp = 0x01
+	default:
+		return fmt.Errorf("unsupported tally color: %s", color)
+	}
+	p := byte(0x03)
+	if on {
+		p = 0x02
+	}
+	return c.sendCommand([]byte{0x01, 0x7E, 0x01, 0x0A, lamp, p})
+}
+
 // sendPanTiltCmd sends the VISCA pan/tilt drive command
 func (c *Controller) sendPanTiltCmd(pan, tilt float64) {
 	// VISCA: 01 06 01 VV WW XX YY (VV=pan speed 1-24, WW=tilt speed 1-20)
@@ -230,33 +301,9 @@ func (c *Controller) sendZoomCmd(zoom float64) {
 	c.sendCommand([]byte{0x01, 0x04, 0x07, cmd})
 }
 
-// sendCommand sends a raw VISCA command
+// sendCommand sends a raw VISCA command to this controller's camera
 func (c *Controller) sendCommand(payload []byte) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Build VISCA frame: [0x80|addr] [payload...] [0xFF]
-	frame := make([]byte, 0, len(payload)+2)
-	frame = append(frame, byte(0x80|c.addr))
-	frame = append(frame, payload...)
-	frame = append(frame, 0xFF)
-
-	// Wrap in VISCA-over-IP for UDP
-	var packet []byte
-	if c.protocol == "udp" {
-		header := make([]byte, 8)
-		header[0] = 0x01 // command
-		binary.BigEndian.PutUint16(header[2:4], uint16(len(frame)))
-		binary.BigEndian.PutUint32(header[4:8], c.seqNum)
-		c.seqNum++
-		packet = append(header, frame...)
-	} else {
-		packet = frame
-	}
-
-	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
-	c.conn.Write(packet)
-	return nil
+	return c.transport.send(c.addr, payload)
 }
 
 func abs(x float64) float64 {
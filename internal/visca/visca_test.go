@@ -0,0 +1,183 @@
+package visca
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"ptz-remote/internal/ptz"
+)
+
+// newTestTransport wraps one end of a connection in a raw (TCP-style) transport
+func newTestTransport(t *testing.T, c conn) *Transport {
+	t.Helper()
+	tr := &Transport{
+		conn:        c,
+		protocol:    "tcp",
+		stopCh:      make(chan struct{}),
+		broadcasts:  make(chan []byte, 4),
+		controllers: make(map[int]*Controller),
+	}
+	go tr.readRawReplies()
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+// readFrame reads one write of a frame
+func readFrame(t *testing.T, c net.Conn) []byte {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	return buf[:n]
+}
+
+func TestPowerInquiry(t *testing.T) {
+	tests := []struct {
+		reply []byte
+		want  string
+	}{
+		{[]byte{0x90, 0x50, 0x02, 0xFF}, ptz.PowerOn},
+		{[]byte{0x90, 0x50, 0x03, 0xFF}, ptz.PowerStandby},
+		{[]byte{0x90, 0x50, 0x04, 0xFF}, ptz.PowerUnknown}, // Not a power state
+		{[]byte{0x90, 0x51, 0xFF}, ptz.PowerUnknown},       // Completion, not an inquiry reply
+	}
+	for _, tt := range tests {
+		server, camera := net.Pipe()
+		tr := newTestTransport(t, server)
+		c, err := tr.NewController(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := readFrame(t, camera), []byte{0x81, 0x09, 0x04, 0x00, 0xFF}; !bytes.Equal(got, want) {
+			t.Fatalf("inquiry % X, want % X", got, want)
+		}
+		camera.Write(tt.reply)
+
+		deadline := time.Now().Add(200 * time.Millisecond)
+		for c.PowerState() != tt.want && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if got := c.PowerState(); got != tt.want {
+			t.Errorf("reply % X: power %q, want %q", tt.reply, got, tt.want)
+		}
+		c.Close()
+		camera.Close()
+	}
+}
+
+func TestRawReplyParsing(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     [][]byte
+		broadcasts [][]byte
+		power      map[int]string // By camera address
+	}{
+		{
+			name:   "power reply",
+			chunks: [][]byte{{0x90, 0x50, 0x02, 0xFF}},
+			power:  map[int]string{1: ptz.PowerOn, 2: ptz.PowerUnknown},
+		},
+		{
+			name:   "routed by address",
+			chunks: [][]byte{{0xA0, 0x50, 0x03, 0xFF}},
+			power:  map[int]string{1: ptz.PowerUnknown, 2: ptz.PowerStandby},
+		},
+		{
+			name:   "split across reads",
+			chunks: [][]byte{{0x90}, {0x50, 0x02}, {0xFF}},
+			power:  map[int]string{1: ptz.PowerOn},
+		},
+		{
+			name:   "several frames in one read",
+			chunks: [][]byte{{0x90, 0x41, 0xFF, 0x90, 0x51, 0xFF, 0xA0, 0x50, 0x02, 0xFF}},
+			power:  map[int]string{1: ptz.PowerUnknown, 2: ptz.PowerOn},
+		},
+		{
+			name:       "broadcast",
+			chunks:     [][]byte{{0x88, 0x30, 0x03, 0xFF}},
+			broadcasts: [][]byte{{0x88, 0x30, 0x03, 0xFF}},
+		},
+		{
+			name: "overlong garbage resynchronizes",
+			chunks: [][]byte{
+				bytes.Repeat([]byte{0x55}, 40),
+				{0xFF, 0x90, 0x50, 0x02, 0xFF},
+			},
+			power: map[int]string{1: ptz.PowerOn},
+		},
+		{
+			name:   "lone terminator and short frames",
+			chunks: [][]byte{{0xFF, 0xFF, 0x90, 0xFF}},
+			power:  map[int]string{1: ptz.PowerUnknown},
+		},
+		{
+			name:   "error replies",
+			chunks: [][]byte{{0x90, 0x60, 0x02, 0xFF, 0x90, 0x61, 0x41, 0xFF, 0x90, 0x60, 0xFF}},
+			power:  map[int]string{1: ptz.PowerUnknown},
+		},
+		{
+			name:   "unknown address",
+			chunks: [][]byte{{0xF0, 0x50, 0x02, 0xFF, 0x00, 0x50, 0x02, 0xFF}},
+			power:  map[int]string{1: ptz.PowerUnknown},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, camera := net.Pipe()
+			defer camera.Close()
+			tr := newTestTransport(t, server)
+			ctrls := map[int]*Controller{}
+			for addr := 1; addr <= 2; addr++ {
+				c, err := tr.NewController(addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close()
+				ctrls[addr] = c
+				readFrame(t, camera) // Power inquiry
+			}
+
+			for _, chunk := range tt.chunks {
+				camera.SetWriteDeadline(time.Now().Add(time.Second))
+				if _, err := camera.Write(chunk); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Replies are handled in order, so one more marks the end
+			camera.Write([]byte{0x88, 0x00, 0xFF})
+
+			var got [][]byte
+			for done := false; !done; {
+				select {
+				case b := <-tr.broadcasts:
+					if bytes.Equal(b, []byte{0x88, 0x00, 0xFF}) {
+						done = true
+					} else {
+						got = append(got, b)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("replies not processed")
+				}
+			}
+			if len(got) != len(tt.broadcasts) {
+				t.Fatalf("broadcasts % X, want % X", got, tt.broadcasts)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.broadcasts[i]) {
+					t.Errorf("broadcast %d: % X, want % X", i, got[i], tt.broadcasts[i])
+				}
+			}
+			for addr, want := range tt.power {
+				if p := ctrls[addr].PowerState(); p != want {
+					t.Errorf("camera %d power %q, want %q", addr, p, want)
+				}
+			}
+		})
+	}
+}
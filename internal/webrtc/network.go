@@ -0,0 +1,45 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// Network holds ICE sockets shared by all sessions: one UDP port, and
+// optionally one TCP port for clients whose UDP is blocked, so a firewall
+// only needs those opened. Sessions are told apart by their ICE username.
+type Network struct {
+	udp ice.UDPMux
+	tcp ice.TCPMux
+}
+
+// NewNetwork listens on the UDP port, and the TCP port unless it is 0
+func NewNetwork(udpPort, tcpPort int) (*Network, error) {
+	udp, err := ice.NewMultiUDPMuxFromPort(udpPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on UDP port %d: %w", udpPort, err)
+	}
+	n := &Network{udp: udp}
+
+	if tcpPort != 0 {
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(tcpPort))
+		if err != nil {
+			udp.Close()
+			return nil, fmt.Errorf("failed to listen on TCP port %d: %w", tcpPort, err)
+		}
+		n.tcp = webrtc.NewICETCPMux(nil, ln, 8)
+	}
+	return n, nil
+}
+
+// Close closes the shared sockets
+func (n *Network) Close() error {
+	if n.tcp != nil {
+		n.tcp.Close()
+	}
+	return n.udp.Close()
+}
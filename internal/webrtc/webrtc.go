@@ -1,36 +1,79 @@
 package webrtc
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 )
 
+// Send-side bandwidth estimation limits, in bits per second
+const (
+	initialBitrate = 10_000_000
+	minBitrate     = 100_000
+	maxBitrate     = 50_000_000
+)
+
+// rembTimeout is how long a REMB estimate is used after it was received
+const rembTimeout = 5 * time.Second
+
+// gatherTimeout bounds how long Answer waits for ICE candidates
+const gatherTimeout = 5 * time.Second
+
+// ErrOfferCollision is returned by AcceptOffer when the client's offer
+// crossed one of the server's. The server is the impolite peer in perfect
+// negotiation: its offer stands and the client rolls back its own.
+var ErrOfferCollision = errors.New("offer collided with the server's offer")
+
 // Session represents a WebRTC session with a client
 type Session struct {
 	pc                   *webrtc.PeerConnection
 	videoTrack           *webrtc.TrackLocalStaticRTP
+	videoSender          *webrtc.RTPSender // nil while the track is removed
 	onICE                func(candidate *webrtc.ICECandidate)
+	onOffer              func(sdp string)
+	onDisconnect         func()
+	onStateChange        func(StateChange)
+	offerPending         bool // Negotiate was called while an offer awaited its answer
+	restartPending       bool
+	estimator            cc.BandwidthEstimator // Google congestion control over TWCC feedback
+	stats                stats.Getter          // RTP stream stats, from RTCP reports
+	videoSSRC            uint32
 	mu                   sync.Mutex
 	closed               bool
 	remoteDescriptionSet bool
 	pendingCandidates    []webrtc.ICECandidateInit
+	twcc                 bool // The answer accepted transport-wide congestion control
+	remb                 int  // Latest receiver estimate, bits per second
+	rembAt               time.Time
+}
+
+// DefaultICEServer is the public STUN server used unless others are configured
+const DefaultICEServer = "stun:stun.l.google.com:19302"
+
+// ICEServer is a STUN or TURN server
+type ICEServer struct {
+	URLs       []string
+	Username   string // TURN credentials
+	Credential string
 }
 
 // Config for WebRTC session
 type Config struct {
-	ICEServers []string // STUN/TURN server URLs
+	ICEServers []ICEServer
 	StaticIPs  string   // Comma-separated list of static server IPs (enables ICE-lite mode)
-}
-
-// DefaultConfig returns a default WebRTC configuration
-func DefaultConfig() Config {
-	return Config{
-		ICEServers: []string{
-			"stun:stun.l.google.com:19302",
-		},
-	}
+	Network    *Network // Shared UDP/TCP ports; nil for a UDP port per session
+	PortMin    uint16   // UDP port range for sessions without a Network (0 = any)
+	PortMax    uint16
 }
 
 // NewSession creates a new WebRTC session
@@ -40,20 +83,94 @@ func NewSession(cfg Config, onICE func(*webrtc.ICECandidate)) (*Session, error)
 		ICEServers: []webrtc.ICEServer{},
 	}
 
-	// Handle ICE-lite mode with static IPs (no STUN/TURN needed)
+	settings := webrtc.SettingEngine{}
+	if cfg.Network != nil {
+		settings.SetICEUDPMux(cfg.Network.udp)
+		if cfg.Network.tcp != nil {
+			settings.SetICETCPMux(cfg.Network.tcp)
+			settings.SetNetworkTypes([]webrtc.NetworkType{
+				webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6,
+				webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6,
+			})
+		}
+	} else if cfg.PortMin != 0 || cfg.PortMax != 0 {
+		if err := settings.SetEphemeralUDPPortRange(cfg.PortMin, cfg.PortMax); err != nil {
+			return nil, fmt.Errorf("invalid UDP port range: %w", err)
+		}
+	}
+
+	// ICE-lite with static IPs: the server only offers host candidates, with
+	// its private addresses replaced by the static (1:1 NAT) IPs, and the
+	// browser does the connectivity checks. No STUN/TURN needed.
 	if cfg.StaticIPs != "" {
-		// In ICE-lite mode, we don't use STUN/TURN servers
+		var ips []string
+		for _, ip := range strings.Split(cfg.StaticIPs, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+		settings.SetLite(true)
+		settings.SetNAT1To1IPs(ips, webrtc.ICECandidateTypeHost)
 	} else {
 		// Normal mode: use STUN/TURN servers
-		for _, url := range cfg.ICEServers {
+		for _, server := range cfg.ICEServers {
 			config.ICEServers = append(config.ICEServers, webrtc.ICEServer{
-				URLs: []string{url},
+				URLs:       server.URLs,
+				Username:   server.Username,
+				Credential: server.Credential,
 			})
 		}
 	}
 
+	// Default codecs and interceptors, plus transport-wide congestion control
+	// feedback for send-side bandwidth estimation
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("failed to register codecs: %w", err)
+	}
+	congestion, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(initialBitrate),
+			gcc.SendSideBWEMinBitrate(minBitrate),
+			gcc.SendSideBWEMaxBitrate(maxBitrate),
+			// Only estimate; the stream's bitrate is set by the camera
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create congestion controller: %w", err)
+	}
+	estimators := make(chan cc.BandwidthEstimator, 1)
+	congestion.OnNewPeerConnection(func(_ string, e cc.BandwidthEstimator) {
+		estimators <- e
+	})
+	statsInterceptor, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stats interceptor: %w", err)
+	}
+	getters := make(chan stats.Getter, 1)
+	statsInterceptor.OnNewPeerConnection(func(_ string, g stats.Getter) {
+		getters <- g
+	})
+	// Registered first so that they see packets after the TWCC header
+	// extension has been added, and the sender reports for RTT
+	registry := &interceptor.Registry{}
+	registry.Add(congestion)
+	registry.Add(statsInterceptor)
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(m, registry); err != nil {
+		return nil, fmt.Errorf("failed to configure TWCC: %w", err)
+	}
+	if err := webrtc.RegisterDefaultInterceptors(m, registry); err != nil {
+		return nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+
 	// Create peer connection
-	pc, err := webrtc.NewPeerConnection(config)
+	api := webrtc.NewAPI(
+		webrtc.WithMediaEngine(m),
+		webrtc.WithInterceptorRegistry(registry),
+		webrtc.WithSettingEngine(settings),
+	)
+	pc, err := api.NewPeerConnection(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create peer connection: %w", err)
 	}
@@ -62,6 +179,14 @@ func NewSession(cfg Config, onICE func(*webrtc.ICECandidate)) (*Session, error)
 		pc:    pc,
 		onICE: onICE,
 	}
+	select {
+	case session.estimator = <-estimators:
+	default:
+	}
+	select {
+	case session.stats = <-getters:
+	default:
+	}
 
 	// Handle ICE candidates
 	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
@@ -70,45 +195,216 @@ func NewSession(cfg Config, onICE func(*webrtc.ICECandidate)) (*Session, error)
 		}
 	})
 
-	// Log connection state changes
-	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		fmt.Printf("WebRTC connection state: %s\n", s.String())
+	// Report state changes
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		session.stateChanged(StateICE, state.String())
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		session.stateChanged(StateConnection, state.String())
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			session.mu.Lock()
+			onDisconnect := session.onDisconnect
+			session.onDisconnect = nil
+			session.mu.Unlock()
+			if onDisconnect != nil {
+				onDisconnect()
+			}
+		}
 	})
 
 	return session, nil
 }
 
-// AddH264Track adds an H264 video track to the session
+// AddH264Track adds an H264 video track to the session, or adds the same
+// track back after RemoveVideoTrack. Once negotiated, call Negotiate.
 func (s *Session) AddH264Track() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.videoSender != nil {
+		return nil
+	}
+
 	// Create video track
-	videoTrack, err := webrtc.NewTrackLocalStaticRTP(
-		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264},
-		"video",
-		"ptz-camera",
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create video track: %w", err)
+	if s.videoTrack == nil {
+		videoTrack, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264},
+			"video",
+			"ptz-camera",
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create video track: %w", err)
+		}
+		s.videoTrack = videoTrack
 	}
 
 	// Add track to peer connection
-	_, err = s.pc.AddTrack(videoTrack)
+	sender, err := s.pc.AddTrack(s.videoTrack)
 	if err != nil {
 		return fmt.Errorf("failed to add video track: %w", err)
 	}
 
-	s.videoTrack = videoTrack
+	s.videoSender = sender
+	if encodings := sender.GetParameters().Encodings; len(encodings) > 0 {
+		s.videoSSRC = uint32(encodings[0].SSRC)
+	}
+	go s.readRTCP(sender)
 	return nil
 }
 
-// CreateOffer creates an SDP offer
-func (s *Session) CreateOffer() (string, error) {
+// RemoveVideoTrack stops sending video. Writes to the track are discarded
+// until AddH264Track adds it back. Call Negotiate to apply it.
+func (s *Session) RemoveVideoTrack() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	offer, err := s.pc.CreateOffer(nil)
+	if s.videoSender == nil {
+		return nil
+	}
+	err := s.pc.RemoveTrack(s.videoSender)
+	s.videoSender = nil
+	if err != nil {
+		return fmt.Errorf("failed to remove video track: %w", err)
+	}
+	return nil
+}
+
+// readRTCP reads the client's feedback, which also drives the interceptors
+// (NACK, TWCC), and keeps the latest REMB estimate
+func (s *Session) readRTCP(sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, p := range packets {
+			if remb, ok := p.(*rtcp.ReceiverEstimatedMaximumBitrate); ok {
+				s.mu.Lock()
+				s.remb = int(remb.Bitrate)
+				s.rembAt = time.Now()
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// EstimatedBitrate returns the available send bandwidth in bits per second:
+// the congestion controller's target when the client sends TWCC feedback,
+// capped by the client's REMB estimate if it sends one. It returns 0 while
+// there is no estimate.
+func (s *Session) EstimatedBitrate() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	estimate := 0
+	if s.twcc && s.estimator != nil {
+		estimate = s.estimator.GetTargetBitrate()
+	}
+	if s.remb > 0 && time.Since(s.rembAt) < rembTimeout && (estimate == 0 || s.remb < estimate) {
+		estimate = s.remb
+	}
+	return estimate
+}
+
+// Stats describes the session's connection, and the video sent to the
+// client from its RTCP receiver reports. The video fields are zero until
+// the first report.
+type Stats struct {
+	ConnectionState string
+	ICEState        string
+	LocalCandidate  string // Selected candidate pair, e.g. "udp host"; empty until connected
+	RemoteCandidate string
+	BytesSent       uint64 // Over the transport, media and data channels
+
+	RTT          time.Duration // From the report's last sender report time and delay
+	Jitter       time.Duration // Interarrival jitter
+	PacketsSent  uint64
+	PacketsLost  int64
+	FractionLost float64 // Share of packets lost since the previous report
+}
+
+// Stats returns the connection and video stats
+func (s *Session) Stats() Stats {
+	st := Stats{
+		ConnectionState: s.pc.ConnectionState().String(),
+		ICEState:        s.pc.ICEConnectionState().String(),
+	}
+	if pair, err := s.pc.SCTP().Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+		st.LocalCandidate = candidateName(pair.Local)
+		st.RemoteCandidate = candidateName(pair.Remote)
+	}
+	for _, report := range s.GetStats() {
+		if t, ok := report.(webrtc.TransportStats); ok {
+			st.BytesSent = t.BytesSent
+		}
+	}
+
+	s.mu.Lock()
+	ssrc := s.videoSSRC
+	s.mu.Unlock()
+	if s.stats == nil || ssrc == 0 {
+		return st
+	}
+	rtp := s.stats.Get(ssrc)
+	if rtp == nil {
+		return st
+	}
+	remote := rtp.RemoteInboundRTPStreamStats
+	st.RTT = remote.RoundTripTime
+	st.Jitter = time.Duration(remote.Jitter * float64(time.Second))
+	st.PacketsSent = rtp.OutboundRTPStreamStats.PacketsSent
+	st.PacketsLost = remote.PacketsLost
+	st.FractionLost = remote.FractionLost
+	return st
+}
+
+// GetStats returns the peer connection's W3C stats report
+func (s *Session) GetStats() webrtc.StatsReport {
+	return s.pc.GetStats()
+}
+
+// candidateName describes a candidate by protocol and type, e.g. "tcp relay"
+func candidateName(c *webrtc.ICECandidate) string {
+	if c == nil {
+		return ""
+	}
+	return c.Protocol.String() + " " + c.Typ.String()
+}
+
+// OnOffer sets the function that sends the server's offers to the client
+func (s *Session) OnOffer(f func(sdp string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onOffer = f
+}
+
+// Negotiate creates an offer, with new ICE credentials if iceRestart is
+// set, and passes it to the OnOffer function. While an earlier offer awaits
+// its answer, the new one is made once the answer has been set.
+func (s *Session) Negotiate(iceRestart bool) error {
+	s.mu.Lock()
+	sdp, err := s.offer(iceRestart)
+	onOffer := s.onOffer
+	s.mu.Unlock()
+
+	// Return immediately - ICE candidates will trickle via OnICECandidate callback
+	if sdp != "" && onOffer != nil {
+		onOffer(sdp)
+	}
+	return err
+}
+
+// offer creates and sets a local offer, or defers it; called with mu held
+func (s *Session) offer(iceRestart bool) (string, error) {
+	if s.pc.SignalingState() != webrtc.SignalingStateStable {
+		s.offerPending = true
+		s.restartPending = s.restartPending || iceRestart
+		return "", nil
+	}
+	iceRestart = iceRestart || s.restartPending
+	s.offerPending, s.restartPending = false, false
+
+	offer, err := s.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: iceRestart})
 	if err != nil {
 		return "", fmt.Errorf("failed to create offer: %w", err)
 	}
@@ -118,15 +414,13 @@ func (s *Session) CreateOffer() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to set local description: %w", err)
 	}
-
-	// Return immediately - ICE candidates will trickle via OnICECandidate callback
 	return offer.SDP, nil
 }
 
-// SetAnswer sets the remote SDP answer
+// SetAnswer sets the remote SDP answer, then sends any offer deferred by
+// Negotiate
 func (s *Session) SetAnswer(sdp string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	answer := webrtc.SessionDescription{
 		Type: webrtc.SDPTypeAnswer,
@@ -135,20 +429,129 @@ func (s *Session) SetAnswer(sdp string) error {
 
 	err := s.pc.SetRemoteDescription(answer)
 	if err != nil {
+		s.mu.Unlock()
 		return fmt.Errorf("failed to set remote description: %w", err)
 	}
 
 	s.remoteDescriptionSet = true
+	s.twcc = strings.Contains(sdp, "transport-wide-cc")
+	s.addPendingCandidates()
 
-	// Process any ICE candidates that arrived before the answer was set
+	var offer string
+	if s.offerPending {
+		offer, err = s.offer(false)
+	}
+	onOffer := s.onOffer
+	s.mu.Unlock()
+
+	if offer != "" && onOffer != nil {
+		onOffer(offer)
+	}
+	return err
+}
+
+// addPendingCandidates adds the ICE candidates that arrived before the
+// remote description was set; called with mu held
+func (s *Session) addPendingCandidates() {
 	for _, candidate := range s.pendingCandidates {
 		if err := s.pc.AddICECandidate(candidate); err != nil {
-			fmt.Printf("Failed to add queued ICE candidate: %v\n", err)
+			log.Printf("Warning: Failed to add queued ICE candidate: %v", err)
 		}
 	}
 	s.pendingCandidates = nil
+}
 
-	return nil
+// AcceptOffer sets an offer from the client, made to renegotiate or restart
+// ICE, and returns the answer. ICE candidates trickle via OnICECandidate. It
+// returns ErrOfferCollision while the server's own offer awaits its answer.
+func (s *Session) AcceptOffer(offer string) (string, error) {
+	answer, _, err := s.setOffer(offer)
+	return answer, err
+}
+
+// Answer sets the client's SDP offer and returns the answer, for clients
+// that make the offer (WHEP). It waits for ICE gathering so the answer
+// carries the server's candidates.
+func (s *Session) Answer(offer string) (string, error) {
+	_, gathered, err := s.setOffer(offer)
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case <-gathered:
+	case <-time.After(gatherTimeout):
+	}
+	return s.pc.LocalDescription().SDP, nil
+}
+
+// setOffer applies the remote offer and the local answer, returning the
+// answer and a channel closed when ICE gathering completes
+func (s *Session) setOffer(offer string) (string, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pc.SignalingState() != webrtc.SignalingStateStable {
+		return "", nil, ErrOfferCollision
+	}
+	err := s.pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to set remote description: %w", err)
+	}
+	s.remoteDescriptionSet = true
+	s.twcc = strings.Contains(offer, "transport-wide-cc")
+	s.addPendingCandidates()
+
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create answer: %w", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(s.pc)
+	if err := s.pc.SetLocalDescription(answer); err != nil {
+		return "", nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+	return answer.SDP, gathered, nil
+}
+
+// OnDisconnect sets a function called once when the connection fails or
+// is closed
+func (s *Session) OnDisconnect(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onDisconnect = f
+}
+
+// State change kinds
+const (
+	StateConnection = "connection" // Peer connection state
+	StateICE        = "ice"        // ICE connection state
+)
+
+// StateChange is a connection or ICE state transition
+type StateChange struct {
+	Time  time.Time
+	Kind  string // StateConnection or StateICE
+	State string // e.g. "checking", "connected", "failed"
+}
+
+// OnStateChange sets a function called on each connection and ICE state
+// change. ICE changes are reported synchronously, so f must not block.
+func (s *Session) OnStateChange(f func(StateChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStateChange = f
+}
+
+func (s *Session) stateChanged(kind, state string) {
+	s.mu.Lock()
+	f := s.onStateChange
+	s.mu.Unlock()
+	if f != nil {
+		f(StateChange{Time: time.Now(), Kind: kind, State: state})
+	}
 }
 
 // AddICECandidate adds a remote ICE candidate
@@ -192,6 +595,25 @@ func (s *Session) WriteRTP(packet []byte) error {
 	return err
 }
 
+// AddDataChannel creates an unordered data channel without retransmissions,
+// for messages where a late one is worse than a lost one. Add it before the
+// first Negotiate so it is part of the offer.
+func (s *Session) AddDataChannel(label string, onMessage func(data []byte)) error {
+	ordered := false
+	maxRetransmits := uint16(0)
+	dc, err := s.pc.CreateDataChannel(label, &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create data channel: %w", err)
+	}
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		onMessage(msg.Data)
+	})
+	return nil
+}
+
 // GetVideoTrack returns the video track for external writers
 func (s *Session) GetVideoTrack() *webrtc.TrackLocalStaticRTP {
 	s.mu.Lock()
@@ -4,13 +4,20 @@ import (
 	"embed"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"ptz-remote/internal/onvif"
+	"ptz-remote/internal/rtsp"
 	"ptz-remote/internal/server"
+	"ptz-remote/internal/webrtc"
 )
 
 //go:embed web/*
@@ -20,20 +27,144 @@ func main() {
 	// Command line flags
 	listenAddr := flag.String("listen", ":8080", "HTTP listen address")
 	rtspURL := flag.String("rtsp", "", "RTSP URL for camera stream")
-	viscaAddr := flag.String("visca", "", "VISCA address (host:port)")
-	viscaProto := flag.String("visca-proto", "udp", "VISCA protocol (udp or tcp)")
+	rtspSub := flag.String("rtsp-sub", "", "Comma-separated lower quality RTSP streams of the same camera, best first (enables adaptive stream selection)")
+	rtspTransport := flag.String("rtsp-transport", "tcp", "RTSP transport (tcp, udp, multicast or auto)")
+	rtspTimeout := flag.Duration("rtsp-timeout", 10*time.Second, "RTSP read/write timeout")
+	rtspUserAgent := flag.String("rtsp-user-agent", "", "User-Agent header for RTSP requests")
+	rtspUser := flag.String("rtsp-user", "", "RTSP username (instead of in the URL)")
+	rtspPass := flag.String("rtsp-pass", "", "RTSP password")
+	rtspOnDemand := flag.Bool("rtsp-on-demand", false, "Connect to the RTSP camera only while clients are watching")
+	rtspIdleTimeout := flag.Duration("rtsp-idle-timeout", 30*time.Second, "With -rtsp-on-demand, disconnect after this long without clients")
+	videoDevice := flag.String("video", "", "V4L2 video device for a USB camera (e.g. /dev/video0), instead of -rtsp")
+	videoFormat := flag.String("video-format", "h264", "V4L2 capture format (h264 or mjpeg)")
+	videoWidth := flag.Int("video-width", 1920, "V4L2 capture width")
+	videoHeight := flag.Int("video-height", 1080, "V4L2 capture height")
+	videoFPS := flag.Int("video-fps", 30, "V4L2 capture (or test pattern) frame rate")
+	srtListen := flag.String("srt-listen", "", "Accept an SRT caller's MPEG-TS/H.264 stream on this UDP address (e.g. :9000), instead of -rtsp")
+	rtmpListen := flag.String("rtmp-listen", "", "Accept an RTMP publish on this TCP address (e.g. :1935), instead of -rtsp")
+	rtmpKey := flag.String("rtmp-key", "", "Stream key RTMP publishers must use (default: any)")
+	rtspListen := flag.String("rtsp-listen", "", "Accept an RTSP publish (ANNOUNCE/RECORD) on this TCP address (e.g. :8554), instead of -rtsp")
+	testPattern := flag.Bool("test-pattern", false, "Serve a timestamped test pattern instead of a camera, to measure glass-to-glass latency")
+	uvcDevice := flag.String("uvc", "", "V4L2 device for UVC pan/tilt/zoom controls (default: the -video device)")
+	viscaAddr := flag.String("visca", "", "VISCA address (host:port, or serial device path)")
+	viscaProto := flag.String("visca-proto", "udp", "VISCA protocol (udp, tcp or serial)")
+	viscaBaud := flag.Int("visca-baud", 9600, "VISCA serial baud rate")
+	viscaCamAddr := flag.Int("visca-addr", 1, "VISCA camera address (1-7)")
 	panasonicAddr := flag.String("panasonic", "", "Panasonic camera address (host or host:port)")
+	panasonicUser := flag.String("panasonic-user", "", "Panasonic camera username")
+	panasonicPass := flag.String("panasonic-pass", "", "Panasonic camera password")
+	panasonicHTTPS := flag.Bool("panasonic-https", false, "Use HTTPS for Panasonic camera")
+	panasonicCA := flag.String("panasonic-ca", "", "PEM CA certificate for Panasonic HTTPS")
+	panasonicInsecure := flag.Bool("panasonic-insecure", false, "Skip TLS certificate verification for Panasonic HTTPS")
+	panasonicEvents := flag.Int("panasonic-events-port", 0, "TCP port for Panasonic update notifications (0 = disabled)")
+	onvifAddr := flag.String("onvif", "", "ONVIF camera address (host[:port] or device service URL)")
+	onvifUser := flag.String("onvif-user", "", "ONVIF username")
+	onvifPass := flag.String("onvif-pass", "", "ONVIF password")
+	onvifDiscover := flag.Bool("onvif-discover", false, "List ONVIF cameras on the LAN and exit")
+	pelcoAddr := flag.String("pelco", "", "Pelco address (serial device path, or host:port with -pelco-proto tcp)")
+	pelcoProto := flag.String("pelco-proto", "serial", "Pelco transport (serial or tcp)")
+	pelcoVariant := flag.String("pelco-variant", "d", "Pelco protocol variant (d or p)")
+	pelcoBaud := flag.Int("pelco-baud", 0, "Pelco serial baud rate (default 2400 for D, 4800 for P)")
+	pelcoCamAddr := flag.Int("pelco-addr", 1, "Pelco receiver address (1-255)")
+	cgiAddr := flag.String("cgi", "", "HTTP CGI camera address (host or host:port)")
+	cgiVendor := flag.String("cgi-vendor", "ptzoptics", "HTTP CGI vendor (ptzoptics, marshall, birddog, or one from -cgi-vendors)")
+	cgiVendors := flag.String("cgi-vendors", "", "JSON file with additional HTTP CGI vendor command maps")
+	cgiUser := flag.String("cgi-user", "", "HTTP CGI username")
+	cgiPass := flag.String("cgi-pass", "", "HTTP CGI password")
+	cgiHTTPS := flag.Bool("cgi-https", false, "Use HTTPS for HTTP CGI camera")
+	cgiCA := flag.String("cgi-ca", "", "PEM CA certificate for HTTP CGI HTTPS")
+	cgiInsecure := flag.Bool("cgi-insecure", false, "Skip TLS certificate verification for HTTP CGI HTTPS")
+	cameraHost := flag.String("camera", "", "Camera host; auto-detects VISCA (UDP/TCP), Panasonic or ONVIF control")
+	cameraUser := flag.String("camera-user", "", "Username for an auto-detected camera")
+	cameraPass := flag.String("camera-pass", "", "Password for an auto-detected camera")
 	iceIPs := flag.String("ice-ips", "", "Comma-separated list of static server IPs (enables ICE-lite mode)")
+	iceUDPPort := flag.Int("ice-udp-port", 0, "Single UDP port for all WebRTC sessions (default: a port per session)")
+	iceTCPPort := flag.Int("ice-tcp-port", 0, "ICE-TCP port for clients without UDP, with -ice-udp-port (0 = disabled)")
+	icePortRange := flag.String("ice-port-range", "", "UDP port range for WebRTC sessions without -ice-udp-port (e.g. 50000-50100)")
+	iceServers := flag.String("ice-servers", webrtc.DefaultICEServer, "Comma-separated STUN/TURN server URLs for the server and browsers (empty for none)")
+	iceUsername := flag.String("ice-username", "", "Username for the TURN servers in -ice-servers")
+	iceCredential := flag.String("ice-credential", "", "Password for the TURN servers in -ice-servers")
+	turnListen := flag.String("turn-listen", "", "Run a TURN server on this UDP and TCP address (e.g. :3478)")
+	turnIP := flag.String("turn-ip", "", "Public IP of the TURN server (default: the first -ice-ips address)")
+	turnSecret := flag.String("turn-secret", "", "Shared secret for TURN credentials (default: random at startup)")
+	turnTTL := flag.Duration("turn-ttl", 24*time.Hour, "Lifetime of TURN credentials given to browsers")
 	flag.Parse()
 
+	if *onvifDiscover {
+		discoverONVIF()
+		return
+	}
+
 	// Create server config
 	cfg := server.Config{
-		ListenAddr:       *listenAddr,
-		RTSPURL:          *rtspURL,
-		VISCAAddress:     *viscaAddr,
-		VISCAProtocol:    *viscaProto,
-		PanasonicAddress: *panasonicAddr,
-		ICEIPs:           *iceIPs,
+		ListenAddr:         *listenAddr,
+		RTSPURL:            *rtspURL,
+		RTSPSubURLs:        splitList(*rtspSub),
+		RTSPTransport:      *rtspTransport,
+		RTSPTimeout:        *rtspTimeout,
+		RTSPUserAgent:      *rtspUserAgent,
+		RTSPUser:           *rtspUser,
+		RTSPPass:           *rtspPass,
+		RTSPOnDemand:       *rtspOnDemand,
+		RTSPIdleTimeout:    *rtspIdleTimeout,
+		VideoDevice:        *videoDevice,
+		VideoFormat:        *videoFormat,
+		VideoWidth:         *videoWidth,
+		VideoHeight:        *videoHeight,
+		VideoFPS:           *videoFPS,
+		SRTListen:          *srtListen,
+		RTMPListen:         *rtmpListen,
+		RTMPStreamKey:      *rtmpKey,
+		RTSPListen:         *rtspListen,
+		TestPattern:        *testPattern,
+		UVCDevice:          *uvcDevice,
+		VISCAAddress:       *viscaAddr,
+		VISCAProtocol:      *viscaProto,
+		VISCABaudRate:      *viscaBaud,
+		VISCACameraAddress: *viscaCamAddr,
+		PanasonicAddress:   *panasonicAddr,
+		PanasonicUser:      *panasonicUser,
+		PanasonicPass:      *panasonicPass,
+		PanasonicHTTPS:     *panasonicHTTPS,
+		PanasonicCACert:    *panasonicCA,
+		PanasonicInsecure:  *panasonicInsecure,
+		PanasonicEventPort: *panasonicEvents,
+		ONVIFAddress:       *onvifAddr,
+		ONVIFUser:          *onvifUser,
+		ONVIFPass:          *onvifPass,
+		PelcoAddress:       *pelcoAddr,
+		PelcoProtocol:      *pelcoProto,
+		PelcoVariant:       *pelcoVariant,
+		PelcoBaudRate:      *pelcoBaud,
+		PelcoCameraAddress: *pelcoCamAddr,
+		CGIAddress:         *cgiAddr,
+		CGIVendor:          *cgiVendor,
+		CGIVendorsFile:     *cgiVendors,
+		CGIUser:            *cgiUser,
+		CGIPass:            *cgiPass,
+		CGIHTTPS:           *cgiHTTPS,
+		CGICACert:          *cgiCA,
+		CGIInsecure:        *cgiInsecure,
+		CameraHost:         *cameraHost,
+		CameraUser:         *cameraUser,
+		CameraPass:         *cameraPass,
+		ICEIPs:             *iceIPs,
+		ICEUDPPort:         *iceUDPPort,
+		ICETCPPort:         *iceTCPPort,
+		ICEServers:         splitList(*iceServers),
+		ICEUsername:        *iceUsername,
+		ICECredential:      *iceCredential,
+		TURNListen:         *turnListen,
+		TURNPublicIP:       *turnIP,
+		TURNSecret:         *turnSecret,
+		TURNTTL:            *turnTTL,
+	}
+	if *icePortRange != "" {
+		portMin, portMax, err := parsePortRange(*icePortRange)
+		if err != nil {
+			log.Fatalf("Invalid -ice-port-range: %v", err)
+		}
+		cfg.ICEPortMin, cfg.ICEPortMax = portMin, portMax
 	}
 
 	// Create server
@@ -56,19 +187,114 @@ func main() {
 	log.Printf("PTZ Remote Control Server")
 	log.Printf("  Listen: %s", cfg.ListenAddr)
 	if cfg.RTSPURL != "" {
-		log.Printf("  RTSP: %s", cfg.RTSPURL)
+		log.Printf("  RTSP: %s (%s)", rtsp.RedactURL(cfg.RTSPURL), cfg.RTSPTransport)
+		for _, u := range cfg.RTSPSubURLs {
+			log.Printf("  RTSP sub stream: %s", rtsp.RedactURL(u))
+		}
+	} else if cfg.SRTListen != "" {
+		log.Printf("  SRT listener: %s", cfg.SRTListen)
+	} else if cfg.RTMPListen != "" {
+		log.Printf("  RTMP listener: %s", cfg.RTMPListen)
+	} else if cfg.RTSPListen != "" {
+		log.Printf("  RTSP record listener: %s", cfg.RTSPListen)
+	} else if cfg.VideoDevice != "" {
+		log.Printf("  V4L2: %s (%s %dx%d@%d)", cfg.VideoDevice, cfg.VideoFormat, cfg.VideoWidth, cfg.VideoHeight, cfg.VideoFPS)
+	} else if cfg.TestPattern {
+		log.Printf("  Test pattern (%d fps)", cfg.VideoFPS)
+	}
+	if cfg.UVCDevice != "" {
+		log.Printf("  UVC: %s", cfg.UVCDevice)
+	}
+	if cfg.CameraHost != "" {
+		log.Printf("  Camera: %s (auto-detect)", cfg.CameraHost)
 	}
 	if cfg.VISCAAddress != "" {
-		log.Printf("  VISCA: %s (%s)", cfg.VISCAAddress, cfg.VISCAProtocol)
+		log.Printf("  VISCA: %s (%s, camera %d)", cfg.VISCAAddress, cfg.VISCAProtocol, cfg.VISCACameraAddress)
 	}
 	if cfg.PanasonicAddress != "" {
-		log.Printf("  Panasonic: %s", cfg.PanasonicAddress)
+		scheme := "http"
+		if cfg.PanasonicHTTPS {
+			scheme = "https"
+		}
+		log.Printf("  Panasonic: %s (%s)", cfg.PanasonicAddress, scheme)
+	}
+	if cfg.ONVIFAddress != "" {
+		log.Printf("  ONVIF: %s", cfg.ONVIFAddress)
+	}
+	if cfg.PelcoAddress != "" {
+		log.Printf("  Pelco-%s: %s (%s, address %d)", strings.ToUpper(cfg.PelcoVariant), cfg.PelcoAddress, cfg.PelcoProtocol, cfg.PelcoCameraAddress)
+	}
+	if cfg.CGIAddress != "" {
+		log.Printf("  HTTP CGI: %s (%s)", cfg.CGIAddress, cfg.CGIVendor)
 	}
 	if cfg.ICEIPs != "" {
 		log.Printf("  WebRTC: ICE-lite mode enabled with IPs: %s", cfg.ICEIPs)
 	}
+	if cfg.ICEUDPPort != 0 {
+		log.Printf("  WebRTC: UDP port %d", cfg.ICEUDPPort)
+		if cfg.ICETCPPort != 0 {
+			log.Printf("  WebRTC: ICE-TCP port %d", cfg.ICETCPPort)
+		}
+	} else if cfg.ICEPortMin != 0 {
+		log.Printf("  WebRTC: UDP ports %d-%d", cfg.ICEPortMin, cfg.ICEPortMax)
+	}
+	if len(cfg.ICEServers) > 0 {
+		log.Printf("  ICE servers: %s", strings.Join(cfg.ICEServers, ", "))
+	}
+	if cfg.TURNListen != "" {
+		log.Printf("  TURN server: %s", cfg.TURNListen)
+	}
 
 	if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePortRange parses "min-max"
+func parsePortRange(value string) (uint16, uint16, error) {
+	lo, hi, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected min-max, got %q", value)
+	}
+	portMin, err := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	portMax, err := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	if portMin == 0 || portMin > portMax {
+		return 0, 0, fmt.Errorf("invalid range %q", value)
+	}
+	return uint16(portMin), uint16(portMax), nil
+}
+
+// discoverONVIF prints the ONVIF cameras that answer a WS-Discovery probe
+func discoverONVIF() {
+	devices, err := onvif.Discover(3 * time.Second)
+	if err != nil {
+		log.Fatalf("ONVIF discovery failed: %v", err)
+	}
+	if len(devices) == 0 {
+		fmt.Println("No ONVIF cameras found")
+		return
+	}
+	for _, d := range devices {
+		fmt.Printf("%s (%s)\n", d.Name, d.Hardware)
+		for _, xaddr := range d.XAddrs {
+			fmt.Printf("  %s\n", xaddr)
+		}
+	}
+}
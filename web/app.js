@@ -6,16 +6,32 @@ class PTZClient {
         this.pc = null;
         this.remoteDescriptionSet = false;
         this.pendingICECandidates = [];
+        this.makingOffer = false;
+        this.iceServers = [];
         this.latency = 0;
+        this.clockOffset = null; // Server clock minus ours, from ping/pong
+        this.videoStats = null;
+        this.g2gLatency = null;
+        this.g2gRunning = false;
+        this.videoProtocol = null;
         this.lastPTZ = { pan: 0, tilt: 0, zoom: 0 };
         this.currentPTZ = { pan: 0, tilt: 0, zoom: 0 };
         this.gamepadIndex = null;
         this.lastPTZSendTime = 0;
         this.ptzSendInterval = 100; // 10 commands per second max
+        this.ptzRepeatInterval = 500; // Resend an unchanged command over the lossy data channel
+        this.ptzChannel = null;
+        this.ptzSeq = 0;
+        this.lastPTZCommandTime = 0;
         this.pendingPTZUpdate = false;
         this.isMoving = false;
         this.mouseDown = false;
         this.mouseControlActive = false;
+        this.powerState = null;
+        this.tally = { red: false, green: false };
+        this.capabilities = null;
+        this.streams = [];
+        this.videoState = null;
 
         this.elements = {
             // Connection status
@@ -26,11 +42,13 @@ class PTZClient {
             cameraStatus: document.getElementById('camera-status'),
             // Latency
             latency: document.getElementById('latency'),
+            videoStats: document.getElementById('video-stats'),
             // Gamepad
             gamepadDot: document.getElementById('gamepad-dot'),
             gamepadStatus: document.getElementById('gamepad-status'),
             // Video
             video: document.getElementById('video'),
+            mjpeg: document.getElementById('mjpeg'),
             videoOverlay: document.getElementById('video-overlay'),
             videoStatus: document.getElementById('video-status'),
             // PTZ display
@@ -39,6 +57,14 @@ class PTZClient {
             panValue: document.getElementById('pan-value'),
             tiltValue: document.getElementById('tilt-value'),
             zoomValue: document.getElementById('zoom-value'),
+            panTiltControl: document.getElementById('pan-tilt-control'),
+            zoomControl: document.getElementById('zoom-control'),
+            // Power / tally
+            powerButton: document.getElementById('power-button'),
+            tallyRed: document.getElementById('tally-red'),
+            tallyGreen: document.getElementById('tally-green'),
+            tallyControls: document.getElementById('tally-controls'),
+            streamSelect: document.getElementById('stream-select'),
             // Error
             errorBanner: document.getElementById('error-banner'),
             errorMessage: document.getElementById('error-message'),
@@ -50,7 +76,10 @@ class PTZClient {
 
     init() {
         this.setupErrorDismiss();
+        this.setupPowerControls();
+        this.setupStreamSelect();
         this.connect();
+        window.addEventListener('online', () => this.restartIce());
         this.setupGamepad();
         this.setupMouseControl();
         this.startPingLoop();
@@ -84,6 +113,12 @@ class PTZClient {
             console.log('WebSocket disconnected');
             this.updateConnectionStatus('disconnected');
             this.updateCameraStatus(false);
+            // The next connection gets a new server session
+            if (this.pc) {
+                this.pc.close();
+                this.pc = null;
+            }
+            this.ptzChannel = null;
             setTimeout(() => this.connect(), 3000);
         };
 
@@ -140,9 +175,21 @@ class PTZClient {
             case 'offer':
                 this.handleOffer(msg.payload);
                 break;
+            case 'answer':
+                this.handleAnswer(msg.payload);
+                break;
             case 'ice_candidate':
                 this.handleICECandidate(msg.payload);
                 break;
+            case 'camera_event':
+                this.handleCameraEvent(msg.payload);
+                break;
+            case 'stream':
+                this.handleStream(msg.payload);
+                break;
+            case 'stats':
+                this.handleStats(msg.payload);
+                break;
             case 'error':
                 this.handleError(msg.payload);
                 break;
@@ -159,6 +206,130 @@ class PTZClient {
         if (payload.video_protocol) {
             console.log('Video protocol:', payload.video_protocol);
         }
+        this.videoProtocol = payload.video_protocol || null;
+        if (this.videoProtocol === 'test') {
+            this.startG2G();
+        }
+        this.updateVideoCodec(payload.video_codec);
+        this.updateCapabilities(payload.capabilities);
+        this.updatePowerStatus(payload.power);
+        this.updateStreams(payload.streams);
+        this.updateVideoState(payload.video_state);
+        this.updateICEServers(payload.ice_servers);
+    }
+
+    // On-demand video: keep the overlay up while the server connects to the camera
+    updateVideoState(state) {
+        const { video, videoOverlay, videoStatus } = this.elements;
+        const prev = this.videoState;
+        this.videoState = state || null;
+        if (state === 'starting') {
+            videoStatus.textContent = 'Starting stream...';
+            videoOverlay.classList.remove('hidden');
+        } else if (state === 'streaming' && prev === 'starting' && video.srcObject) {
+            videoOverlay.classList.add('hidden');
+        }
+    }
+
+    // MJPEG sources (USB cameras) can't go over WebRTC; show the server's
+    // multipart stream in an <img> instead
+    updateVideoCodec(codec) {
+        const { mjpeg, video, videoOverlay } = this.elements;
+        const useMJPEG = codec === 'mjpeg';
+        if (useMJPEG && mjpeg.classList.contains('hidden')) {
+            mjpeg.src = '/video.mjpeg';
+            mjpeg.classList.remove('hidden');
+            video.classList.add('hidden');
+            videoOverlay.classList.add('hidden');
+        } else if (!useMJPEG && !mjpeg.classList.contains('hidden')) {
+            mjpeg.removeAttribute('src');
+            mjpeg.classList.add('hidden');
+            video.classList.remove('hidden');
+        }
+    }
+
+    // Enable or disable controls for the active controller's capabilities.
+    // Without a controller (no capabilities) everything stays enabled.
+    updateCapabilities(caps) {
+        this.capabilities = caps || null;
+        this.elements.panTiltControl.classList.toggle('opacity-30', !this.supports('pan_tilt'));
+        this.elements.zoomControl.classList.toggle('opacity-30', !this.supports('zoom'));
+        this.elements.tallyControls.classList.toggle('hidden', !this.supports('tally'));
+    }
+
+    supports(feature) {
+        return !this.capabilities || this.capabilities[feature];
+    }
+
+    // --- Stream Selection ---
+
+    // The selector is only shown when the camera has more than one stream
+    setupStreamSelect() {
+        this.elements.streamSelect.addEventListener('change', (e) => {
+            this.send('select_stream', { stream: e.target.value });
+        });
+    }
+
+    updateStreams(streams) {
+        const names = (streams || []).map((s) => s.name);
+        const select = this.elements.streamSelect;
+        select.classList.toggle('hidden', names.length < 2);
+        if (names.join() === this.streams.join()) {
+            return;
+        }
+        this.streams = names;
+        const value = select.value;
+        select.replaceChildren(new Option('Auto', 'auto'), ...names.map((n) => new Option(n, n)));
+        select.value = names.includes(value) ? value : 'auto';
+    }
+
+    handleStream(payload) {
+        const select = this.elements.streamSelect;
+        select.value = payload.auto ? 'auto' : payload.stream;
+        select.options[0].text = payload.auto ? `Auto (${payload.stream})` : 'Auto';
+        if (payload.estimated_bitrate) {
+            console.log(`Stream ${payload.stream}, estimate ${Math.round(payload.estimated_bitrate / 1000)} kbps`);
+        }
+    }
+
+    // --- Power / Tally ---
+
+    setupPowerControls() {
+        this.elements.powerButton.addEventListener('click', () => {
+            // Wake from any state other than "on"
+            this.send('power', { on: this.powerState !== 'on' });
+        });
+        this.elements.tallyRed.addEventListener('click', () => this.toggleTally('red'));
+        this.elements.tallyGreen.addEventListener('click', () => this.toggleTally('green'));
+    }
+
+    toggleTally(color) {
+        this.tally[color] = !this.tally[color];
+        this.send('tally', { color, on: this.tally[color] });
+        this.updateTallyDisplay();
+    }
+
+    updatePowerStatus(power) {
+        this.powerState = power || null;
+        const button = this.elements.powerButton;
+        button.textContent = `Power: ${power || '--'}`;
+        if (power === 'on') {
+            button.className = 'px-2 py-0.5 rounded border border-green-700 text-green-400 hover:text-white';
+        } else if (power === 'standby') {
+            button.className = 'px-2 py-0.5 rounded border border-gray-600 text-gray-400 hover:text-white';
+        } else {
+            button.className = 'px-2 py-0.5 rounded border border-yellow-700 text-yellow-400 hover:text-white';
+        }
+        if (!power) {
+            button.classList.add('hidden');
+        }
+    }
+
+    updateTallyDisplay() {
+        this.elements.tallyRed.classList.toggle('bg-red-500', this.tally.red);
+        this.elements.tallyRed.classList.toggle('bg-gray-700', !this.tally.red);
+        this.elements.tallyGreen.classList.toggle('bg-green-500', this.tally.green);
+        this.elements.tallyGreen.classList.toggle('bg-gray-700', !this.tally.green);
     }
 
     updateCameraStatus(connected) {
@@ -174,8 +345,19 @@ class PTZClient {
         }
     }
 
+    handleCameraEvent(payload) {
+        console.log('Camera event:', payload.event, payload.data);
+        if (payload.event === 'tally') {
+            this.tally[payload.data.color] = payload.data.on;
+            this.updateTallyDisplay();
+        }
+    }
+
     handlePong(payload) {
-        this.latency = Date.now() - payload.client_timestamp;
+        const now = Date.now();
+        this.latency = now - payload.client_timestamp;
+        // Assume the server stamped the pong halfway through the round trip
+        this.clockOffset = payload.server_timestamp - (payload.client_timestamp + now) / 2;
         const latencyEl = this.elements.latency;
         latencyEl.textContent = `${this.latency}ms`;
 
@@ -189,6 +371,129 @@ class PTZClient {
         }
     }
 
+    handleStats(payload) {
+        for (const event of payload.events || []) {
+            console.log(`WebRTC ${event.kind} state:`, event.state, new Date(event.time).toISOString());
+        }
+        for (const warning of payload.warnings || []) {
+            console.warn('Connection quality:', warning);
+        }
+        this.videoStats = payload;
+        this.renderVideoStats();
+    }
+
+    renderVideoStats() {
+        const el = this.elements.videoStats;
+        const st = this.videoStats;
+        if (!st) {
+            return;
+        }
+        const parts = [];
+        if (this.g2gLatency !== null) {
+            parts.push(`G2G ${this.g2gLatency}ms`);
+        }
+        parts.push(`srv ${Math.round(st.server_delay_ms)}ms`);
+        parts.push(`RTT ${Math.round(st.rtt_ms)}ms`);
+        parts.push(`loss ${(st.fraction_lost * 100).toFixed(1)}%`);
+        el.textContent = parts.join(' · ');
+        const lines = [
+            `Server delay ${st.server_delay_ms}ms (max ${st.server_delay_max_ms}ms)`,
+            `Jitter ${st.jitter_ms}ms`,
+            `Packets sent ${st.packets_sent}, lost ${st.packets_lost}`,
+            `Connection ${st.connection_state}, ICE ${st.ice_state}`,
+        ];
+        if (st.local_candidate) {
+            lines.push(`Candidates ${st.local_candidate} ↔ ${st.remote_candidate}`);
+        }
+        for (const warning of st.warnings || []) {
+            lines.push(`Warning: ${warning}`);
+        }
+        el.title = lines.join('\n');
+
+        // The server decides when loss or RTT warrants a warning
+        if (st.warnings && st.warnings.length > 0) {
+            el.className = 'text-red-400 font-mono';
+        } else if (st.fraction_lost >= 0.01 || st.rtt_ms >= 150) {
+            el.className = 'text-yellow-400 font-mono';
+        } else {
+            el.className = 'text-green-400 font-mono';
+        }
+    }
+
+    // With the server's test pattern, read the timestamp code off each
+    // displayed frame to measure glass-to-glass latency: from the frame
+    // being made on the server to it being shown here. The layout matches
+    // internal/testpattern: 60 cells of 8x8 pixels, 20 per row, white is 1;
+    // a 48-bit Unix time in milliseconds, then the XOR of its 12-bit groups.
+    startG2G() {
+        if (this.g2gRunning) {
+            return;
+        }
+        this.g2gRunning = true;
+
+        const video = this.elements.video;
+        const canvas = document.createElement('canvas');
+        canvas.width = 160;
+        canvas.height = 96;
+        const ctx = canvas.getContext('2d', { willReadFrequently: true });
+        const interval = 500;
+        let last = 0;
+
+        const measure = (shownAt) => {
+            if (this.clockOffset === null || video.videoWidth === 0) {
+                return;
+            }
+            ctx.drawImage(video, 0, 0, canvas.width, canvas.height);
+            const pixels = ctx.getImageData(0, 0, canvas.width, 24).data;
+            const bit = (i) => {
+                const x = (i % 20) * 8 + 4;
+                const y = Math.floor(i / 20) * 8 + 4;
+                return pixels[(y * canvas.width + x) * 4] > 128 ? 1 : 0;
+            };
+            let stamp = 0; // 48 bits fit in a double exactly
+            for (let i = 0; i < 48; i++) {
+                stamp = stamp * 2 + bit(i);
+            }
+            let check = 0;
+            for (let i = 48; i < 60; i++) {
+                check = check * 2 + bit(i);
+            }
+            let expected = 0;
+            for (let i = 0; i < 4; i++) {
+                expected ^= Math.floor(stamp / 2 ** (12 * i)) % 4096;
+            }
+            if (check !== expected) {
+                return; // Not a test pattern frame, or a corrupt one
+            }
+            this.g2gLatency = Math.round(shownAt + this.clockOffset - stamp);
+            this.renderVideoStats();
+        };
+
+        if ('requestVideoFrameCallback' in HTMLVideoElement.prototype) {
+            const onFrame = (now, metadata) => {
+                if (this.videoProtocol !== 'test') {
+                    this.g2gRunning = false;
+                    return;
+                }
+                if (now - last >= interval) {
+                    last = now;
+                    measure(performance.timeOrigin + metadata.expectedDisplayTime);
+                }
+                video.requestVideoFrameCallback(onFrame);
+            };
+            video.requestVideoFrameCallback(onFrame);
+        } else {
+            const timer = setInterval(() => {
+                if (this.videoProtocol !== 'test') {
+                    this.g2gRunning = false;
+                    clearInterval(timer);
+                    return;
+                }
+                measure(Date.now());
+            }, interval);
+        }
+    }
+
     handleError(payload) {
         console.error('Server error:', payload.code, payload.message);
         this.showError(`${payload.code}: ${payload.message}`);
@@ -212,23 +517,14 @@ class PTZClient {
 
     // --- WebRTC ---
 
-    async handleOffer(payload) {
-        this.elements.videoStatus.textContent = 'Establishing connection...';
-
-        // Close existing peer connection if any
-        if (this.pc) {
-            this.pc.close();
-        }
-
-        // Reset ICE candidate queue for new connection
+    createPeerConnection() {
         this.remoteDescriptionSet = false;
         this.pendingICECandidates = [];
+        this.makingOffer = false;
 
-        this.pc = new RTCPeerConnection({
-            iceServers: [{ urls: 'stun:stun.l.google.com:19302' }]
-        });
+        const pc = new RTCPeerConnection({ iceServers: this.iceServers });
 
-        this.pc.onicecandidate = (event) => {
+        pc.onicecandidate = (event) => {
             if (event.candidate) {
                 this.send('ice_candidate', {
                     candidate: event.candidate.candidate,
@@ -238,42 +534,123 @@ class PTZClient {
             }
         };
 
-        this.pc.oniceconnectionstatechange = () => {
-            console.log('ICE connection state:', this.pc.iceConnectionState);
-            if (this.pc.iceConnectionState === 'failed') {
+        pc.oniceconnectionstatechange = () => {
+            console.log('ICE connection state:', pc.iceConnectionState);
+            if (pc.iceConnectionState === 'failed') {
                 this.elements.videoStatus.textContent = 'Connection failed. Retrying...';
+                this.send('ice_restart', {});
             }
         };
 
-        this.pc.ontrack = (event) => {
+        // Offers of our own, e.g. after restartIce(). The server is the
+        // impolite peer, so if offers cross it ignores ours and we answer its.
+        pc.onnegotiationneeded = async () => {
+            try {
+                this.makingOffer = true;
+                await pc.setLocalDescription();
+                this.send('offer', { sdp: pc.localDescription.sdp });
+            } catch (e) {
+                console.error('WebRTC error:', e);
+            } finally {
+                this.makingOffer = false;
+            }
+        };
+
+        // Unordered, no retransmits: PTZ commands skip the WebSocket's
+        // head-of-line blocking once it is open
+        pc.ondatachannel = (event) => {
+            const channel = event.channel;
+            if (channel.label !== 'ptz') {
+                return;
+            }
+            channel.onopen = () => {
+                console.log('PTZ data channel open');
+                this.ptzChannel = channel;
+            };
+            channel.onclose = () => {
+                if (this.ptzChannel === channel) {
+                    this.ptzChannel = null;
+                }
+            };
+        };
+
+        pc.ontrack = (event) => {
             console.log('Received track:', event.track.kind);
             if (event.streams && event.streams[0]) {
                 this.elements.video.srcObject = event.streams[0];
-                this.elements.videoOverlay.classList.add('hidden');
+                if (this.videoState !== 'starting') {
+                    this.elements.videoOverlay.classList.add('hidden');
+                }
             }
         };
 
+        return pc;
+    }
+
+    async handleOffer(payload) {
+        // The first offer creates the peer connection; later ones renegotiate it
+        if (!this.pc) {
+            this.elements.videoStatus.textContent = 'Establishing connection...';
+            this.pc = this.createPeerConnection();
+        }
+        const pc = this.pc;
+
         try {
-            await this.pc.setRemoteDescription({ type: 'offer', sdp: payload.sdp });
-            this.remoteDescriptionSet = true;
-
-            // Process any ICE candidates that arrived before remote description was set
-            for (const candidate of this.pendingICECandidates) {
-                await this.pc.addIceCandidate(candidate).catch(e =>
-                    console.error('Error adding queued ICE candidate:', e)
-                );
-            }
-            this.pendingICECandidates = [];
+            // Rolls back our own offer if they crossed
+            await pc.setRemoteDescription({ type: 'offer', sdp: payload.sdp });
+            await this.remoteDescriptionApplied();
 
-            const answer = await this.pc.createAnswer();
-            await this.pc.setLocalDescription(answer);
-            this.send('answer', { sdp: answer.sdp });
+            await pc.setLocalDescription();
+            this.send('answer', { sdp: pc.localDescription.sdp });
         } catch (e) {
             console.error('WebRTC error:', e);
             this.elements.videoStatus.textContent = 'WebRTC error: ' + e.message;
         }
     }
 
+    async handleAnswer(payload) {
+        if (!this.pc || this.pc.signalingState !== 'have-local-offer') {
+            return;
+        }
+        try {
+            await this.pc.setRemoteDescription({ type: 'answer', sdp: payload.sdp });
+            await this.remoteDescriptionApplied();
+        } catch (e) {
+            console.error('WebRTC error:', e);
+        }
+    }
+
+    // STUN/TURN servers from status; TURN credentials are renewed with each
+    // status, so an ICE restart gets current ones
+    updateICEServers(servers) {
+        this.iceServers = servers || [];
+        if (this.pc) {
+            try {
+                this.pc.setConfiguration({ iceServers: this.iceServers });
+            } catch (e) {
+                console.error('Failed to update ICE servers:', e);
+            }
+        }
+    }
+
+    // Add any ICE candidates that arrived before the remote description
+    async remoteDescriptionApplied() {
+        this.remoteDescriptionSet = true;
+        for (const candidate of this.pendingICECandidates) {
+            await this.pc.addIceCandidate(candidate).catch(e =>
+                console.error('Error adding queued ICE candidate:', e)
+            );
+        }
+        this.pendingICECandidates = [];
+    }
+
+    // Restart ICE after a network change, e.g. switching from Wi-Fi to cellular
+    restartIce() {
+        if (this.pc && this.pc.connectionState !== 'new') {
+            this.send('ice_restart', {});
+        }
+    }
+
     handleICECandidate(payload) {
         if (!this.pc || !payload.candidate) {
             return;
@@ -433,9 +810,18 @@ class PTZClient {
     startPTZSendLoop() {
         // Rate-limited PTZ command sending (10 commands/sec max)
         setInterval(() => {
-            const { pan, tilt, zoom } = this.currentPTZ;
+            let { pan, tilt, zoom } = this.currentPTZ;
             const threshold = 0.02;
 
+            // Don't drive axes the controller can't move
+            if (!this.supports('pan_tilt')) {
+                pan = 0;
+                tilt = 0;
+            }
+            if (!this.supports('zoom')) {
+                zoom = 0;
+            }
+
             const hasChanged =
                 Math.abs(pan - this.lastPTZ.pan) > threshold ||
                 Math.abs(tilt - this.lastPTZ.tilt) > threshold ||
@@ -444,20 +830,42 @@ class PTZClient {
             const wasMoving = this.isMoving;
             this.isMoving = pan !== 0 || tilt !== 0 || zoom !== 0;
 
-            if (hasChanged) {
+            // A command lost on the data channel isn't retransmitted, so
+            // repeat it now and then while moving
+            const repeat = this.isMoving && this.ptzChannel &&
+                Date.now() - this.lastPTZCommandTime >= this.ptzRepeatInterval;
+
+            if (hasChanged || repeat) {
                 // If we just stopped moving, send a stop command
                 if (wasMoving && !this.isMoving) {
                     this.sendPTZStop();
                 } else if (this.isMoving) {
-                    this.send('ptz_command', { pan, tilt, zoom });
+                    this.sendPTZ('ptz_command', { pan, tilt, zoom });
+                    this.lastPTZCommandTime = Date.now();
                 }
                 this.lastPTZ = { pan, tilt, zoom };
             }
         }, this.ptzSendInterval);
     }
 
+    // Sends over the data channel when open, otherwise the WebSocket. seq
+    // lets the server drop messages that arrive late or twice.
+    sendPTZ(type, payload) {
+        payload.seq = ++this.ptzSeq;
+        const channel = this.ptzChannel;
+        if (channel && channel.readyState === 'open') {
+            channel.send(JSON.stringify({ type, payload }));
+            // A lost stop would leave the camera moving; also send it reliably
+            if (type === 'ptz_stop') {
+                this.send(type, payload);
+            }
+        } else {
+            this.send(type, payload);
+        }
+    }
+
     sendPTZStop() {
-        this.send('ptz_stop', {});
+        this.sendPTZ('ptz_stop', {});
         this.lastPTZ = { pan: 0, tilt: 0, zoom: 0 };
         this.currentPTZ = { pan: 0, tilt: 0, zoom: 0 };
         this.isMoving = false;